@@ -0,0 +1,120 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"fmt"
+
+	"github.com/zeebo/xxh3"
+)
+
+// The algorithm used to digest document bodies before they are written to the diff files
+type HashAlgorithm uint8
+
+const (
+	HashAlgorithmSha512 HashAlgorithm = iota
+	// 128 bit non-cryptographic hash. Much cheaper than sha512 and good enough to detect mismatches
+	HashAlgorithmXxh3
+)
+
+const (
+	HashAlgorithmSha512Name = "sha512"
+	HashAlgorithmXxh3Name   = "xxh3"
+)
+
+const xxh3HashSize = 16
+
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	switch name {
+	case HashAlgorithmSha512Name:
+		return HashAlgorithmSha512, nil
+	case HashAlgorithmXxh3Name:
+		return HashAlgorithmXxh3, nil
+	default:
+		return 0, fmt.Errorf("invalid hash algorithm %v. Accepted values are %v and %v", name, HashAlgorithmSha512Name, HashAlgorithmXxh3Name)
+	}
+}
+
+func (h HashAlgorithm) String() string {
+	switch h {
+	case HashAlgorithmSha512:
+		return HashAlgorithmSha512Name
+	case HashAlgorithmXxh3:
+		return HashAlgorithmXxh3Name
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(h))
+	}
+}
+
+func (h HashAlgorithm) IsValid() bool {
+	return h == HashAlgorithmSha512 || h == HashAlgorithmXxh3
+}
+
+// Number of bytes in a digest
+func (h HashAlgorithm) Size() int {
+	switch h {
+	case HashAlgorithmXxh3:
+		return xxh3HashSize
+	default:
+		return sha512.Size
+	}
+}
+
+func (h HashAlgorithm) Sum(data []byte) []byte {
+	switch h {
+	case HashAlgorithmXxh3:
+		sum := xxh3.Hash128(data).Bytes()
+		return sum[:]
+	default:
+		sum := sha512.Sum512(data)
+		return sum[:]
+	}
+}
+
+// Digests are only comparable when they are produced by the same algorithm
+func (h HashAlgorithm) Equal(hash1, hash2 []byte) bool {
+	return len(hash1) == h.Size() && bytes.Equal(hash1, hash2)
+}
+
+// Every diff file starts with a header that records how it was written
+//
+//	magic         - 4 bytes
+//	version       - 1 byte
+//	hashAlgorithm - 1 byte
+const (
+	MutationFileMagic      = "XDFM"
+	MutationFileVersion    = 2
+	MutationFileHeaderSize = len(MutationFileMagic) + 2
+)
+
+func EncodeMutationFileHeader(hashAlgorithm HashAlgorithm) []byte {
+	header := make([]byte, MutationFileHeaderSize)
+	copy(header, MutationFileMagic)
+	header[len(MutationFileMagic)] = MutationFileVersion
+	header[len(MutationFileMagic)+1] = byte(hashAlgorithm)
+	return header
+}
+
+// Returns the hash algorithm recorded in the header
+func DecodeMutationFileHeader(header []byte) (HashAlgorithm, error) {
+	if len(header) != MutationFileHeaderSize || string(header[:len(MutationFileMagic)]) != MutationFileMagic {
+		return 0, fmt.Errorf("missing file header. The file was written by an older version of the differ")
+	}
+	if version := header[len(MutationFileMagic)]; version != MutationFileVersion {
+		return 0, fmt.Errorf("unsupported file version %v", version)
+	}
+	hashAlgorithm := HashAlgorithm(header[len(MutationFileMagic)+1])
+	if !hashAlgorithm.IsValid() {
+		return 0, fmt.Errorf("unknown hash algorithm %v in file header", uint8(hashAlgorithm))
+	}
+	return hashAlgorithm, nil
+}
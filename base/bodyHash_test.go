@@ -0,0 +1,57 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"crypto/sha512"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashAlgorithm(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, name := range []string{HashAlgorithmSha512Name, HashAlgorithmXxh3Name} {
+		hashAlgorithm, err := ParseHashAlgorithm(name)
+		assert.Nil(err)
+		assert.Equal(name, hashAlgorithm.String())
+
+		body := []byte(`{"foo":"bar"}`)
+		hash := hashAlgorithm.Sum(body)
+		assert.Len(hash, hashAlgorithm.Size())
+		assert.True(hashAlgorithm.Equal(hash, hashAlgorithm.Sum(body)))
+		assert.False(hashAlgorithm.Equal(hash, hashAlgorithm.Sum([]byte(`{"foo":"baz"}`))))
+		assert.False(hashAlgorithm.Equal(nil, nil))
+	}
+	assert.Equal(sha512.Size, HashAlgorithmSha512.Size())
+	assert.Equal(16, HashAlgorithmXxh3.Size())
+
+	_, err := ParseHashAlgorithm("md5")
+	assert.NotNil(err)
+}
+
+func TestMutationFileHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	header := EncodeMutationFileHeader(HashAlgorithmXxh3)
+	assert.Len(header, MutationFileHeaderSize)
+	hashAlgorithm, err := DecodeMutationFileHeader(header)
+	assert.Nil(err)
+	assert.Equal(HashAlgorithmXxh3, hashAlgorithm)
+
+	// a record of an older file, starting with the key length
+	_, err = DecodeMutationFileHeader([]byte{0, 3, 'k', 'e', 'y', 0})
+	assert.NotNil(err)
+
+	header[len(header)-1] = 0xff
+	_, err = DecodeMutationFileHeader(header)
+	assert.NotNil(err)
+}
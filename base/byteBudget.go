@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"sync"
+	"time"
+)
+
+// Bounds the number of bytes held at any one time. Acquire blocks until enough bytes have been released
+// A single acquisition larger than the limit is let through once nothing else is held, so that it cannot block forever
+// Shared by all its users, so each of them stops waiting on its own cancel channel
+type ByteBudget struct {
+	limit uint64
+	used  uint64
+	mtx   sync.Mutex
+	cond  *sync.Cond
+
+	// number of times Acquire had to wait, and for how long in total
+	backpressureCount uint64
+	backpressureTime  time.Duration
+}
+
+func NewByteBudget(limit uint64) *ByteBudget {
+	budget := &ByteBudget{limit: limit}
+	budget.cond = sync.NewCond(&budget.mtx)
+	return budget
+}
+
+// Returns false if cancelChan is closed before enough bytes have been released, in which case nothing is acquired
+func (b *ByteBudget) Acquire(size uint64, cancelChan chan bool) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.used > 0 && b.used+size > b.limit {
+		b.backpressureCount++
+		startTime := time.Now()
+		waitDone := make(chan bool)
+		defer close(waitDone)
+		go func() {
+			select {
+			case <-cancelChan:
+				b.mtx.Lock()
+				b.cond.Broadcast()
+				b.mtx.Unlock()
+			case <-waitDone:
+			}
+		}()
+		for b.used > 0 && b.used+size > b.limit {
+			if isCancelled(cancelChan) {
+				b.backpressureTime += time.Since(startTime)
+				return false
+			}
+			b.cond.Wait()
+		}
+		b.backpressureTime += time.Since(startTime)
+	}
+
+	b.used += size
+	return true
+}
+
+func isCancelled(cancelChan chan bool) bool {
+	select {
+	case <-cancelChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *ByteBudget) Release(size uint64) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if size > b.used {
+		size = b.used
+	}
+	b.used -= size
+	b.cond.Broadcast()
+}
+
+func (b *ByteBudget) Limit() uint64 {
+	return b.limit
+}
+
+func (b *ByteBudget) Used() uint64 {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.used
+}
+
+func (b *ByteBudget) BackpressureStats() (uint64, time.Duration) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.backpressureCount, b.backpressureTime
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestByteBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	budget := NewByteBudget(100)
+	cancelChan := make(chan bool)
+	// larger than the limit, but nothing else is held
+	assert.True(budget.Acquire(150, cancelChan))
+	budget.Release(150)
+
+	assert.True(budget.Acquire(60, cancelChan))
+	acquiredChan := make(chan bool)
+	go func() {
+		acquiredChan <- budget.Acquire(60, cancelChan)
+	}()
+
+	select {
+	case <-acquiredChan:
+		assert.Fail("acquired beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	budget.Release(60)
+	assert.True(<-acquiredChan)
+	assert.Equal(uint64(60), budget.Used())
+
+	count, _ := budget.BackpressureStats()
+	assert.Equal(uint64(1), count)
+
+	// a cancelled acquisition leaves the others sharing the budget waiting
+	otherCancelChan := make(chan bool)
+	otherAcquiredChan := make(chan bool)
+	go func() {
+		acquiredChan <- budget.Acquire(60, cancelChan)
+	}()
+	go func() {
+		otherAcquiredChan <- budget.Acquire(60, otherCancelChan)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(cancelChan)
+	assert.False(<-acquiredChan)
+	assert.Equal(uint64(60), budget.Used())
+
+	select {
+	case <-otherAcquiredChan:
+		assert.Fail("acquired beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	budget.Release(60)
+	assert.True(<-otherAcquiredChan)
+	assert.True(budget.Acquire(1, cancelChan), "nothing to wait for")
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"time"
+)
+
+// CAS is a hybrid logical clock whose value is the wall-clock time in nanoseconds since epoch
+// with the lower 16 bits possibly used as a logical counter. This is precise enough to restrict
+// the diff to documents modified within a wall-clock window
+type CasWindow struct {
+	start uint64
+	// 0 means open-ended
+	end uint64
+}
+
+// Both start and end are RFC3339 timestamps, i.e. "2026-10-18T02:00:00Z", and either one can be left empty
+// Returns nil if neither are specified
+func NewCasWindow(start, end string) (*CasWindow, error) {
+	if start == "" && end == "" {
+		return nil, nil
+	}
+
+	window := &CasWindow{}
+	if start != "" {
+		startTime, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window start %v - %v", start, err)
+		}
+		window.start = uint64(startTime.UnixNano())
+	}
+	if end != "" {
+		endTime, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window end %v - %v", end, err)
+		}
+		window.end = uint64(endTime.UnixNano())
+	}
+	if window.end != 0 && window.end <= window.start {
+		return nil, fmt.Errorf("window end %v is not after window start %v", end, start)
+	}
+	return window, nil
+}
+
+func (w *CasWindow) Contains(cas uint64) bool {
+	if w == nil {
+		return true
+	}
+	return cas >= w.start && (w.end == 0 || cas < w.end)
+}
+
+// Returns true if any one of the given CAS values falls within the window
+func (w *CasWindow) ContainsAny(casList ...uint64) bool {
+	if w == nil {
+		return true
+	}
+	for _, cas := range casList {
+		if cas != 0 && w.Contains(cas) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *CasWindow) String() string {
+	if w == nil {
+		return "CasWindow{}"
+	}
+	var endStr string
+	if w.end != 0 {
+		endStr = time.Unix(0, int64(w.end)).UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("CasWindow{start: %v, end: %v}", time.Unix(0, int64(w.start)).UTC().Format(time.RFC3339), endStr)
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCasWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	window, err := NewCasWindow("", "")
+	assert.Nil(err)
+	assert.Nil(window)
+	assert.True(window.Contains(1))
+
+	window, err = NewCasWindow("2026-10-18T02:00:00Z", "2026-10-18T03:00:00Z")
+	assert.Nil(err)
+	inWindow := uint64(time.Date(2026, 10, 18, 2, 30, 0, 0, time.UTC).UnixNano())
+	before := uint64(time.Date(2026, 10, 18, 1, 59, 0, 0, time.UTC).UnixNano())
+	after := uint64(time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC).UnixNano())
+	assert.True(window.Contains(inWindow))
+	assert.False(window.Contains(before))
+	assert.False(window.Contains(after))
+	assert.True(window.ContainsAny(before, inWindow))
+	assert.False(window.ContainsAny(before, 0))
+
+	window, err = NewCasWindow("2026-10-18T02:00:00Z", "")
+	assert.Nil(err)
+	assert.True(window.Contains(after))
+
+	_, err = NewCasWindow("2026-10-18T03:00:00Z", "2026-10-18T02:00:00Z")
+	assert.NotNil(err)
+	_, err = NewCasWindow("yesterday", "")
+	assert.NotNil(err)
+}
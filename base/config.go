@@ -0,0 +1,367 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type ConfigValueType int
+
+const (
+	ConfigString ConfigValueType = iota
+	ConfigBool
+	ConfigInt
+	ConfigUint
+)
+
+func (t ConfigValueType) String() string {
+	switch t {
+	case ConfigBool:
+		return "boolean"
+	case ConfigInt:
+		return "integer"
+	case ConfigUint:
+		return "non-negative integer"
+	default:
+		return "string"
+	}
+}
+
+// A secret key can also be given as <key>File, the path of a file holding the value,
+// or as <key>Env, the name of an environment variable holding the value
+const (
+	ConfigSecretFileSuffix = "File"
+	ConfigSecretEnvSuffix  = "Env"
+)
+
+type ConfigKey struct {
+	Type        ConfigValueType
+	Description string
+	Default     string
+	Secret      bool
+}
+
+// Keyed by the name of the key in the yaml file
+type ConfigSchema map[string]*ConfigKey
+
+// A value from the config file, after variable expansion and type checking
+type ConfigValue struct {
+	Value string
+	// line in the config file the value is set on
+	Line int
+}
+
+// ${NAME} refers to another key in the config file or, failing that, to an environment variable
+// $${NAME} is kept as ${NAME}, for values such as passwords that contain it literally
+var configVariableRegex = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+const configVariableEscape = "$$"
+
+// Reads the yaml config file at path and validates it against the schema
+// Unknown keys are an error, as they are for the JSON schema of the config file
+func LoadConfig(path string, schema ConfigSchema) (map[string]*ConfigValue, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ParseConfig(path, data, schema)
+}
+
+// fileName is only used to point at the offending line in errors and warnings
+func ParseConfig(fileName string, data []byte, schema ConfigSchema) (map[string]*ConfigValue, []string, error) {
+	parser := &configParser{
+		fileName:  fileName,
+		schema:    schema,
+		nodes:     make(map[string]*yaml.Node),
+		resolved:  make(map[string]string),
+		resolving: make(map[string]bool),
+	}
+	if err := parser.parse(data); err != nil {
+		return nil, nil, err
+	}
+
+	// in a fixed order so that the same file always reports the same first error
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make(map[string]*ConfigValue)
+	for _, key := range keys {
+		value, line, found, err := parser.value(key, schema[key])
+		if err != nil {
+			return nil, nil, err
+		}
+		if found {
+			values[key] = &ConfigValue{Value: value, Line: line}
+		}
+	}
+	return values, parser.warnings, nil
+}
+
+type configParser struct {
+	fileName string
+	schema   ConfigSchema
+	// raw value of each key in the file
+	nodes map[string]*yaml.Node
+	lines map[string]int
+	// expanded value of each key, filled in as variables are resolved
+	resolved  map[string]string
+	resolving map[string]bool
+	warnings  []string
+}
+
+func (p *configParser) errorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("%v:%d: %v", p.fileName, line, fmt.Sprintf(format, args...))
+}
+
+func (p *configParser) warnf(line int, format string, args ...interface{}) {
+	p.warnings = append(p.warnings, fmt.Sprintf("%v:%d: %v", p.fileName, line, fmt.Sprintf(format, args...)))
+}
+
+func (p *configParser) parse(data []byte) error {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return fmt.Errorf("%v: %v", p.fileName, err)
+	}
+	if len(document.Content) == 0 {
+		// empty file
+		return nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return p.errorf(root.Line, "the config file should be a mapping of keys to values")
+	}
+
+	p.lines = make(map[string]int)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode, valueNode := root.Content[i], root.Content[i+1]
+		key := keyNode.Value
+		if line, exists := p.lines[key]; exists {
+			return p.errorf(keyNode.Line, "%v is already set on line %d", key, line)
+		}
+		if valueNode.Kind == yaml.AliasNode {
+			valueNode = valueNode.Alias
+		}
+		if valueNode.Kind != yaml.ScalarNode {
+			return p.errorf(valueNode.Line, "%v should be a single value", key)
+		}
+		p.nodes[key] = valueNode
+		p.lines[key] = keyNode.Line
+
+		if !p.isKnownKey(key) {
+			return p.unknownKeyError(key, keyNode.Line)
+		}
+	}
+	return nil
+}
+
+func (p *configParser) isKnownKey(key string) bool {
+	if _, ok := p.schema[key]; ok {
+		return true
+	}
+	for _, suffix := range []string{ConfigSecretFileSuffix, ConfigSecretEnvSuffix} {
+		if configKey, ok := p.schema[strings.TrimSuffix(key, suffix)]; ok && strings.HasSuffix(key, suffix) && configKey.Secret {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *configParser) unknownKeyError(key string, line int) error {
+	for knownKey := range p.schema {
+		if strings.EqualFold(key, knownKey) {
+			return p.errorf(line, "unknown key %v. Did you mean %v?", key, knownKey)
+		}
+	}
+	return p.errorf(line, "unknown key %v", key)
+}
+
+// Returns the expanded value of a key as it is written in the file, whether or not it is in the schema
+func (p *configParser) expand(key string) (string, error) {
+	if value, ok := p.resolved[key]; ok {
+		return value, nil
+	}
+	if p.resolving[key] {
+		return "", p.errorf(p.lines[key], "%v refers to itself through ${} variables", key)
+	}
+	p.resolving[key] = true
+	defer delete(p.resolving, key)
+
+	node := p.nodes[key]
+	if node.Tag == "!!null" {
+		p.resolved[key] = ""
+		return "", nil
+	}
+
+	var expandErr error
+	value := configVariableRegex.ReplaceAllStringFunc(node.Value, func(variable string) string {
+		if expandErr != nil {
+			return ""
+		}
+		if strings.HasPrefix(variable, configVariableEscape) {
+			return variable[1:]
+		}
+		name := configVariableRegex.FindStringSubmatch(variable)[1]
+		if _, ok := p.nodes[name]; ok {
+			var value string
+			value, expandErr = p.expand(name)
+			return value
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		expandErr = p.errorf(node.Line, "%v is neither a key in the config file nor an environment variable", variable)
+		return ""
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	p.resolved[key] = value
+	return value, nil
+}
+
+// Returns the value of a schema key, read from the secret sources if the key allows it
+func (p *configParser) value(key string, configKey *ConfigKey) (string, int, bool, error) {
+	sources := []string{key}
+	if configKey.Secret {
+		sources = append(sources, key+ConfigSecretFileSuffix, key+ConfigSecretEnvSuffix)
+	}
+	var source string
+	for _, candidate := range sources {
+		if _, ok := p.nodes[candidate]; !ok {
+			continue
+		}
+		if source != "" {
+			return "", 0, false, p.errorf(p.lines[candidate], "%v and %v are mutually exclusive", source, candidate)
+		}
+		source = candidate
+	}
+	if source == "" {
+		return "", 0, false, nil
+	}
+	line := p.lines[source]
+
+	value, err := p.expand(source)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if p.nodes[source].Tag == "!!null" && (source != key || configKey.Type != ConfigString) {
+		return "", 0, false, p.errorf(line, "%v has no value", source)
+	}
+	switch source {
+	case key:
+		if configKey.Secret && value != "" {
+			p.warnf(line, "%v is stored in plain text. Consider %v%v or %v%v instead", key, key, ConfigSecretFileSuffix, key, ConfigSecretEnvSuffix)
+		}
+	case key + ConfigSecretFileSuffix:
+		content, err := os.ReadFile(value)
+		if err != nil {
+			return "", 0, false, p.errorf(line, "unable to read %v: %v", source, err)
+		}
+		value = strings.TrimRight(string(content), "\r\n")
+	case key + ConfigSecretEnvSuffix:
+		envValue, ok := os.LookupEnv(value)
+		if !ok {
+			return "", 0, false, p.errorf(line, "environment variable %v named by %v is not set", value, source)
+		}
+		value = envValue
+	}
+
+	if configKey.Type == ConfigBool && value == "" {
+		// config files written for runDiffer.sh set booleans to "" to turn them off
+		value = "false"
+	}
+	if err := configKey.Type.check(value); err != nil {
+		return "", 0, false, p.errorf(line, "invalid value %q for %v. Expected a %v", value, key, configKey.Type)
+	}
+	return value, line, true, nil
+}
+
+// The strings accepted by strconv.ParseBool, and the empty string for false
+const configBoolStringPattern = "^(|1|0|t|f|T|F|true|false|TRUE|FALSE|True|False)$"
+
+func (t ConfigValueType) check(value string) error {
+	var err error
+	switch t {
+	case ConfigBool:
+		_, err = strconv.ParseBool(value)
+	case ConfigInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case ConfigUint:
+		_, err = strconv.ParseUint(value, 10, 64)
+	}
+	return err
+}
+
+// Returns the schema in JSON Schema form, for editors and other tools to validate config files with
+func (s ConfigSchema) JSONSchema() ([]byte, error) {
+	properties := make(map[string]interface{})
+	for key, configKey := range s {
+		property := map[string]interface{}{
+			"description": configKey.Description,
+		}
+		switch configKey.Type {
+		case ConfigBool:
+			// also quoted, as config files written for runDiffer.sh have them
+			property["oneOf"] = []interface{}{
+				map[string]interface{}{"type": "boolean"},
+				map[string]interface{}{"type": "string", "pattern": configBoolStringPattern},
+			}
+		case ConfigInt:
+			property["type"] = "integer"
+		case ConfigUint:
+			property["type"] = "integer"
+			property["minimum"] = 0
+		default:
+			property["type"] = "string"
+		}
+		if configKey.Default != "" {
+			var defaultValue interface{} = configKey.Default
+			if configKey.Type != ConfigString {
+				// the default is a valid literal of its own type
+				if err := json.Unmarshal([]byte(configKey.Default), &defaultValue); err != nil {
+					return nil, fmt.Errorf("invalid default %v for %v: %v", configKey.Default, key, err)
+				}
+			}
+			property["default"] = defaultValue
+		}
+		properties[key] = property
+
+		if configKey.Secret {
+			properties[key+ConfigSecretFileSuffix] = map[string]interface{}{
+				"type":        "string",
+				"description": fmt.Sprintf("path of a file holding %v", key),
+			}
+			properties[key+ConfigSecretEnvSuffix] = map[string]interface{}{
+				"type":        "string",
+				"description": fmt.Sprintf("name of an environment variable holding %v", key),
+			}
+		}
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "xdcrDiffer configuration",
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}, "", "  ")
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testConfigSchema = ConfigSchema{
+	"outputFileDir":  {Type: ConfigString},
+	"sourceFileDir":  {Type: ConfigString, Default: "source"},
+	"targetFileDir":  {Type: ConfigString, Default: "target"},
+	"sourcePassword": {Type: ConfigString, Secret: true},
+	"numberOfBins":   {Type: ConfigUint, Default: "5"},
+	"setupTimeout":   {Type: ConfigInt, Default: "10"},
+	"debugMode":      {Type: ConfigBool, Default: "false"},
+}
+
+func TestParseConfig(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("XDCR_DIFFER_TEST_DIR", "/tmp/differ")
+
+	data := []byte(`outputFileDir: "${XDCR_DIFFER_TEST_DIR}/outputs"
+sourceFileDir: "${outputFileDir}/source"
+numberOfBins: 7
+setupTimeout: -1
+debugMode: true
+targetFileDir: "$${outputFileDir}/target"
+`)
+	values, warnings, err := ParseConfig("test.yaml", data, testConfigSchema)
+	assert.Nil(err)
+	assert.Equal("/tmp/differ/outputs", values["outputFileDir"].Value)
+	assert.Equal("/tmp/differ/outputs/source", values["sourceFileDir"].Value)
+	assert.Equal(2, values["sourceFileDir"].Line)
+	assert.Equal("7", values["numberOfBins"].Value)
+	assert.Equal("-1", values["setupTimeout"].Value)
+	assert.Equal("true", values["debugMode"].Value)
+	assert.Equal("${outputFileDir}/target", values["targetFileDir"].Value)
+	assert.NotContains(values, "sourcePassword")
+	assert.Empty(warnings)
+
+	values, _, err = ParseConfig("test.yaml", []byte(`debugMode: ""`), testConfigSchema)
+	assert.Nil(err)
+	assert.Equal("false", values["debugMode"].Value)
+
+	values, _, err = ParseConfig("test.yaml", []byte(`debugMode: "true"`), testConfigSchema)
+	assert.Nil(err)
+	assert.Equal("true", values["debugMode"].Value)
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	for data, line := range map[string]string{
+		"debugMode: false\nnumberOfBins: -1\n":                               "test.yaml:2:",
+		"numberOfBins: 1.5\n":                                                "test.yaml:1:",
+		"debugMode: maybe\n":                                                 "test.yaml:1:",
+		"numberOfBins:\n":                                                    "test.yaml:1:",
+		"numberOfBins: [1, 2]\n":                                             "test.yaml:1:",
+		"numberOfBins: 1\nnumberOfBins: 2\n":                                 "test.yaml:2:",
+		"sourceFileDir: ${XDCR_DIFFER_TEST_UNDEFINED}\n":                     "test.yaml:1:",
+		"outputFileDir: ${sourceFileDir}\nsourceFileDir: ${outputFileDir}\n": "test.yaml:",
+		"numberOfBins: 1\nnumberofbins: 3\n":                                 "test.yaml:2: unknown key numberofbins. Did you mean numberOfBins?",
+		"unknownKey: 1\n":                                                    "test.yaml:1: unknown key unknownKey",
+	} {
+		_, _, err := ParseConfig("test.yaml", []byte(data), testConfigSchema)
+		if assert.NotNil(err, data) {
+			assert.Contains(err.Error(), line, data)
+		}
+	}
+}
+
+func TestParseConfigSecrets(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("XDCR_DIFFER_TEST_PASSWORD", "fromEnv")
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.Nil(os.WriteFile(passwordFile, []byte("fromFile\n"), 0600))
+
+	values, warnings, err := ParseConfig("test.yaml", []byte("sourcePasswordEnv: XDCR_DIFFER_TEST_PASSWORD\n"), testConfigSchema)
+	assert.Nil(err)
+	assert.Empty(warnings)
+	assert.Equal("fromEnv", values["sourcePassword"].Value)
+
+	values, warnings, err = ParseConfig("test.yaml", []byte("sourcePasswordFile: "+passwordFile+"\n"), testConfigSchema)
+	assert.Nil(err)
+	assert.Empty(warnings)
+	assert.Equal("fromFile", values["sourcePassword"].Value)
+
+	values, warnings, err = ParseConfig("test.yaml", []byte("sourcePassword: plain\n"), testConfigSchema)
+	assert.Nil(err)
+	assert.Len(warnings, 1)
+	assert.Equal("plain", values["sourcePassword"].Value)
+
+	_, _, err = ParseConfig("test.yaml", []byte("sourcePassword: plain\nsourcePasswordEnv: XDCR_DIFFER_TEST_PASSWORD\n"), testConfigSchema)
+	assert.NotNil(err)
+
+	_, _, err = ParseConfig("test.yaml", []byte("sourcePasswordEnv: XDCR_DIFFER_TEST_UNDEFINED\n"), testConfigSchema)
+	assert.NotNil(err)
+}
+
+func TestConfigJSONSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	bytes, err := testConfigSchema.JSONSchema()
+	assert.Nil(err)
+	var schema map[string]interface{}
+	assert.Nil(json.Unmarshal(bytes, &schema))
+	properties := schema["properties"].(map[string]interface{})
+	assert.Len(properties, len(testConfigSchema)+2)
+	assert.Equal(float64(5), properties["numberOfBins"].(map[string]interface{})["default"])
+	assert.Equal(false, properties["debugMode"].(map[string]interface{})["default"])
+	assert.Contains(properties, "sourcePasswordFile")
+}
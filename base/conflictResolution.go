@@ -0,0 +1,103 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import "fmt"
+
+// Conflict resolution types of a bucket, as given by conflictResolutionType in its bucket info
+const (
+	ConflictResolutionSeqno  = "seqno" // revId based. This is the default of a bucket
+	ConflictResolutionLww    = "lww"
+	ConflictResolutionCustom = "custom"
+)
+
+var ConflictResolutionTypes = []string{ConflictResolutionSeqno, ConflictResolutionLww, ConflictResolutionCustom}
+
+func ValidateConflictResolutionType(crType string) error {
+	for _, validType := range ConflictResolutionTypes {
+		if crType == validType {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown conflict resolution type %v", crType)
+}
+
+// The side whose version XDCR would keep
+type CrWinner int
+
+const (
+	// XDCR only replicates a source version that wins, so the target also wins a tie
+	CrWinnerTarget CrWinner = iota
+	CrWinnerSource CrWinner = iota
+	// The versions are concurrent, and the custom conflict resolver merges them
+	CrWinnerMerge CrWinner = iota
+)
+
+func (w CrWinner) String() string {
+	switch w {
+	case CrWinnerSource:
+		return "Source"
+	case CrWinnerMerge:
+		return "Merge"
+	default:
+		return "Target"
+	}
+}
+
+// The document metadata that conflict resolution looks at
+type CrVersion struct {
+	Cas      uint64
+	RevSeqno uint64
+	Expiry   uint32
+	Flags    uint32
+	Deleted  bool
+	// Pruned by the pruning window of the bucket. nil if the document has no HLV
+	Hlv *VersionVector
+}
+
+// Under revId based conflict resolution, the CAS does not tell versions apart, so a target with a different CAS
+// is still the same version as long as the revId, the flags and whether it is deleted match. The expiry is not compared
+func (v CrVersion) SameUnderSeqnoCR(other CrVersion) bool {
+	return v.RevSeqno == other.RevSeqno && v.Flags == other.Flags && v.Deleted == other.Deleted
+}
+
+// Returns the side whose version XDCR would keep when the source version is replicated to the target
+// seqno compares the revId first and then the CAS, lww the other way around, and both then compare the expiry
+// and the flags. custom keeps the side whose HLV dominates, and merges concurrent ones. It is lww when either
+// side has no HLV, or both HLVs have seen the same versions
+func ResolveConflict(crType string, source, target CrVersion) CrWinner {
+	if crType == ConflictResolutionCustom {
+		switch CompareVersionVectors(source.Hlv, target.Hlv) {
+		case CausalitySourceDominates:
+			return CrWinnerSource
+		case CausalityTargetDominates:
+			return CrWinnerTarget
+		case CausalityConcurrent:
+			return CrWinnerMerge
+		}
+	}
+
+	order := [][2]uint64{
+		{source.Cas, target.Cas},
+		{source.RevSeqno, target.RevSeqno},
+	}
+	if crType == ConflictResolutionSeqno {
+		order[0], order[1] = order[1], order[0]
+	}
+	order = append(order, [2]uint64{uint64(source.Expiry), uint64(target.Expiry)}, [2]uint64{uint64(source.Flags), uint64(target.Flags)})
+	for _, values := range order {
+		if values[0] > values[1] {
+			return CrWinnerSource
+		} else if values[0] < values[1] {
+			return CrWinnerTarget
+		}
+	}
+	return CrWinnerTarget
+}
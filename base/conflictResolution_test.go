@@ -0,0 +1,73 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveConflict(t *testing.T) {
+	assert := assert.New(t)
+
+	// more revisions, but older
+	source := CrVersion{Cas: 100, RevSeqno: 5}
+	target := CrVersion{Cas: 200, RevSeqno: 3}
+	assert.Equal(CrWinnerSource, ResolveConflict(ConflictResolutionSeqno, source, target))
+	assert.Equal(CrWinnerTarget, ResolveConflict(ConflictResolutionLww, source, target))
+	assert.Equal(CrWinnerTarget, ResolveConflict(ConflictResolutionCustom, source, target))
+
+	// same revId, the CAS breaks the tie
+	target.RevSeqno = 5
+	assert.Equal(CrWinnerTarget, ResolveConflict(ConflictResolutionSeqno, source, target))
+
+	// then the expiry and the flags
+	target.Cas = 100
+	source.Flags = 1
+	assert.Equal(CrWinnerSource, ResolveConflict(ConflictResolutionLww, source, target))
+	target.Expiry = 10
+	assert.Equal(CrWinnerTarget, ResolveConflict(ConflictResolutionLww, source, target))
+
+	// a tie is kept by the target
+	assert.Equal(CrWinnerTarget, ResolveConflict(ConflictResolutionSeqno, source, source))
+
+	// concurrent HLVs are merged
+	source = CrVersion{Cas: 300, RevSeqno: 1, Hlv: &VersionVector{CvSrc: "a", CvVer: 300}}
+	target = CrVersion{Cas: 200, RevSeqno: 1, Hlv: &VersionVector{CvSrc: "b", CvVer: 200}}
+	assert.Equal(CrWinnerMerge, ResolveConflict(ConflictResolutionCustom, source, target))
+	assert.Equal(CausalityConcurrent, CompareVersionVectors(source.Hlv, target.Hlv))
+	assert.Equal(CrWinnerSource, ResolveConflict(ConflictResolutionLww, source, target))
+
+	// a side that has seen the current version of the other dominates it, whatever their CAS
+	target.Hlv.Pv = map[string]uint64{"a": 300}
+	assert.Equal(CrWinnerTarget, ResolveConflict(ConflictResolutionCustom, source, target))
+	assert.Equal(CausalityTargetDominates, CompareVersionVectors(source.Hlv, target.Hlv))
+	source.Hlv = &VersionVector{CvSrc: "a", CvVer: 400, Pv: map[string]uint64{"b": 200}}
+	assert.Equal(CrWinnerSource, ResolveConflict(ConflictResolutionCustom, source, target))
+
+	// the same versions, so lww decides
+	source.Hlv = &VersionVector{CvSrc: "b", CvVer: 200, Pv: map[string]uint64{"a": 300}}
+	assert.Equal(CrWinnerSource, ResolveConflict(ConflictResolutionCustom, source, target))
+	assert.Equal("Merge", CrWinnerMerge.String())
+}
+
+func TestSameUnderSeqnoCR(t *testing.T) {
+	assert := assert.New(t)
+
+	version := CrVersion{Cas: 100, RevSeqno: 5, Flags: 1}
+	assert.True(version.SameUnderSeqnoCR(CrVersion{Cas: 200, RevSeqno: 5, Flags: 1}))
+	assert.False(version.SameUnderSeqnoCR(CrVersion{Cas: 100, RevSeqno: 6, Flags: 1}))
+	assert.False(version.SameUnderSeqnoCR(CrVersion{Cas: 100, RevSeqno: 5, Flags: 1, Deleted: true}))
+	assert.True(version.SameUnderSeqnoCR(CrVersion{Cas: 100, RevSeqno: 5, Flags: 1, Expiry: 10}))
+
+	assert.Nil(ValidateConflictResolutionType(ConflictResolutionLww))
+	assert.NotNil(ValidateConflictResolutionType("timestamp"))
+}
@@ -20,29 +20,54 @@ const StreamingBucketName = "xdcrDiffTool"
 const VbucketSeqnoStatName = "vbucket-seqno"
 const VbucketHighSeqnoStatsKey = "vb_%v:high_seqno"
 const VbucketUuidStatsKey = "vb_%v:uuid"
+const VbucketPurgeSeqnoStatsKey = "vb_%v:purge_seqno"
+const VbucketDetailsStatName = "vbucket-details"
+const VbucketStateStatsKey = "vb_%v"
+const VbucketNumItemsStatsKey = "vb_%v:num_items"
+const VbucketStateActive = "active"
+const CollectionsStatName = "collections"
+const CollectionItemsStatName = "items"
 const SourceFileDir = "source"
 const TargetFileDir = "target"
 const CheckpointFileDir = "checkpoint"
 const FileDifferDir = "fileDiff"
 const MutationDifferDir = "mutationDiff"
+const PreflightDir = "preflight"
+const PreflightReportFileName = "preflightReport"
 const DiffKeysFileName = "diffKeys"
 const DiffDetailsFileName = "diffDetails"
+const TtlDriftReportFileName = "ttlDriftReport"
 const DiffKeysSrcMigrationHintSuffix = "hint"
 const MutationDiffFileName = "mutationDiffDetails"
 const MutationDiffColIdMapping = "mutationDiffColIdMapping"
 const MutationDiffMigrationDetails = "mutationMigrationDetails"
+const MutationDiffLagReportFileName = "mutationDiffLagReport"
+const MutationDiffWinnerReportFileName = "mutationDiffWinnerReport"
+const WinnerReportFileName = "winnerReport"
+const MutationDiffHlvCausalityReportFileName = "mutationDiffHlvCausalityReport"
+const HlvCausalityReportFileName = "hlvCausalityReport"
+const MutationDiffMobileReportFileName = "mutationDiffMobileReport"
+const MobileReportFileName = "mobileReport"
+const MappingPlanFileName = "mappingPlan.json"
+const MappingPlanTableFileName = "mappingPlan.txt"
+const MigrationSummaryFileName = "migrationSummary"
 const DiffErrorKeysFileName = "diffKeysWithError"
 const StatsReportInterval = 5
 const SourceClusterName = "source"
 const TargetClusterName = "target"
 const SelfReferenceName = "xdcrDifftoolSelfRef"
 const ManifestFileName = "manifest"
+const CheckpointTempFileSuffix = ".tmp"
+const LiveDiffDir = "live"
+const LiveWindowDirTimeFormat = "20060102T150405Z"
+const LiveMetricsPath = "/metrics"
 
 const NodesKey = "nodes"
 const PoolsDefaultBucketPath = "/pools/default/buckets/"
 const SASLPasswordKey = "saslPassword"
 const HttpGet = "GET"
 const NumVBucketsKey = "numVBuckets" // key to obtain the number of Vbuckets stat from bucketInfo
+const ConflictResolutionTypeKey = "conflictResolutionType"
 
 // default values for configurable parameters if not specified by user
 const BucketOpTimeout uint64 = 20
@@ -53,9 +78,23 @@ const SendBatchMaxBackoff uint64 = 5
 const GetStatsBackoffFactor = 2
 const SendBatchBackoffFactor = 2
 const MaxNumOfGetStatsRetry = 10
+const MinDcpBufferSize = 1024 * 1024
+const MaxDcpBufferSize = 20 * 1024 * 1024 // gocbcore default
 const MaxNumOfSendBatchRetry = 10
 const DelayBetweenSourceAndTarget uint64 = 2
 const CheckpointInterval = 600
+const CheckpointHistory uint64 = 5
+const LiveSettleSecs uint64 = 30
+const LiveWindowSecs uint64 = 300
+const LiveWindowsToKeep uint64 = 12
+const LiveRecheckInterval = 1 // in seconds
+const LiveMetricsHost = "127.0.0.1"
+const LiveMaxPendingKeys uint64 = 1000000
+
+// Sync Gateway metadata fetched along with the HLV
+const XattrPreImportCasPath = "_mou.pCas"
+const XattrSyncRevPath = "_sync.rev"
+const XattrSyncCasPath = "_sync.cas"
 
 const ClusterRunMinPortNo uint16 = 9000
 const ClusterRunMaxPortNo uint16 = 9007
@@ -67,15 +106,20 @@ const ClusterRunMaxPortNo uint16 = 9007
 // cas                - 8 bytes
 // importCas          - 8 bytes
 // pRev               - 8 bytes
+// preImportCas       - 8 bytes
+// syncCas            - 8 bytes
 // flags              - 4 bytes
 // expiry             - 4 bytes
 // opCode             - 2 bytes
 // datatype           - 2 bytes
-// hash               - 64 bytes
+// syncRevLen         - 2 bytes
+// hashLen            - 2 bytes
 // collectionId       - 4 bytes
 // migrationFilterLen - 2 bytes
+// (variable) - syncRev is syncRevLen bytes, 0 if the document has no _sync xattr
+// (variable) - hash is hashLen bytes, which depends on the hash algorithm. 0 if the body was not streamed
 // (variable) - each filterID is 2 bytes
-const BodyLength = 120
+const BodyLength = 76
 const KeyLenVariable = 2
 const MigrationFilterLen = 2
 const xattrSizeLen = 8 // To store the size of the HLV
@@ -89,9 +133,11 @@ const (
 // This function is used to calculate the length of the byte array for serializing a mutation
 // @param keyLen denotes the length of the document key
 // @param size denoted the length of HLV
+// @param hashLen denotes the length of the body hash
+// @param syncRevLen denotes the length of the current revision in the _sync xattr
 // @param colMigrationFilterMatched denotes the list of Migration Filters matched
-func GetFixedSizeMutationLen(keyLen int, size uint64, colMigrationFilterMatched []uint8) int {
-	return KeyLenVariable + keyLen + xattrSizeLen + int(size) + BodyLength + MigrationFilterLen + len(colMigrationFilterMatched)*2 // (xattrSizeLen - to store the size of HLV)
+func GetFixedSizeMutationLen(keyLen int, size uint64, hashLen, syncRevLen int, colMigrationFilterMatched []uint8) int {
+	return KeyLenVariable + keyLen + xattrSizeLen + int(size) + BodyLength + hashLen + syncRevLen + MigrationFilterLen + len(colMigrationFilterMatched)*2 // (xattrSizeLen - to store the size of HLV)
 
 }
 
@@ -127,4 +173,13 @@ const (
 
 var MutationDiffCompareType = []string{MutationCompareTypeMetadata, MutationCompareTypeBodyOnly, MutationCompareTypeBodyAndMeta}
 
+// How the file differ treats a tombstone on one side for a document that is absent from the other side
+const (
+	TombstonePolicyStrict = "strict" // This is the default. Reported as missing, like any other document
+	TombstonePolicyIgnore = "ignore"
+	TombstonePolicyReport = "report" // Reported separately from the missing documents
+)
+
+var TombstonePolicies = []string{TombstonePolicyStrict, TombstonePolicyIgnore, TombstonePolicyReport}
+
 const Uint32MaxVal uint32 = 1<<32 - 1
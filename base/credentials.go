@@ -0,0 +1,102 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const CredentialsStdin = "-"
+
+// Keys accepted in a credentials file
+var CredentialKeys = []string{"sourceUsername", "sourcePassword", "targetUsername", "targetPassword"}
+
+// Reads the credentials file at the given path, or from stdin if the path is "-"
+func LoadCredentials(path string) (map[string]string, error) {
+	var reader io.Reader
+	name := path
+	if path == CredentialsStdin {
+		reader = os.Stdin
+		name = "stdin"
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	return ParseCredentials(name, reader)
+}
+
+// A credentials file has one name=value pair per line. The value is everything after the first =, taken as it is,
+// so that passwords do not need to be quoted or escaped. Empty lines and lines starting with # are skipped
+func ParseCredentials(name string, reader io.Reader) (map[string]string, error) {
+	credentials := make(map[string]string)
+	scanner := bufio.NewScanner(reader)
+	var line int
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(strings.TrimSpace(text), "#") {
+			continue
+		}
+		key, value, found := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !found {
+			// the line may be a password, so it is not repeated in the error
+			return nil, fmt.Errorf("%v:%d: expected name=value", name, line)
+		}
+		if !isCredentialKey(key) {
+			return nil, fmt.Errorf("%v:%d: unknown name %v. Accepted names are %v", name, line, key, strings.Join(CredentialKeys, ", "))
+		}
+		if _, exists := credentials[key]; exists {
+			return nil, fmt.Errorf("%v:%d: %v is given more than once", name, line, key)
+		}
+		credentials[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return credentials, nil
+}
+
+func isCredentialKey(key string) bool {
+	for _, credentialKey := range CredentialKeys {
+		if key == credentialKey {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads a PEM encoded client certificate chain and its private key, and checks that they belong together
+func LoadClientCertificate(certFile, keyFile string) ([]byte, []byte, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, nil, fmt.Errorf("both the client certificate and its key need to be given")
+	}
+	certificate, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = tls.X509KeyPair(certificate, key); err != nil {
+		return nil, nil, fmt.Errorf("invalid client certificate %v or key %v: %v", certFile, keyFile, err)
+	}
+	return certificate, key, nil
+}
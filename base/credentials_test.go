@@ -0,0 +1,66 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCredentials(t *testing.T) {
+	assert := assert.New(t)
+
+	credentials, err := ParseCredentials("creds", strings.NewReader("# comment\n\nsourceUsername=Administrator\nsourcePassword= p@ss=word#1 \r\n"))
+	assert.Nil(err)
+	assert.Equal("Administrator", credentials["sourceUsername"])
+	assert.Equal(" p@ss=word#1 ", credentials["sourcePassword"])
+	assert.NotContains(credentials, "targetPassword")
+
+	_, err = ParseCredentials("creds", strings.NewReader("sourceUsername=a\nsecret\n"))
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "creds:2:")
+		assert.NotContains(err.Error(), "secret")
+	}
+	_, err = ParseCredentials("creds", strings.NewReader("sourcePasword=a\n"))
+	assert.NotNil(err)
+	_, err = ParseCredentials("creds", strings.NewReader("sourcePassword=a\nsourcePassword=b\n"))
+	assert.NotNil(err)
+}
+
+func TestLoadClientCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	clientCert := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "Administrator"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, newTestCA(t))
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	assert.Nil(os.WriteFile(certFile, clientCert.certPEM, 0600))
+	assert.Nil(os.WriteFile(keyFile, clientCert.keyPEM, 0600))
+
+	certificate, key, err := LoadClientCertificate(certFile, keyFile)
+	assert.Nil(err)
+	assert.NotEmpty(certificate)
+	assert.NotEmpty(key)
+
+	_, _, err = LoadClientCertificate(certFile, "")
+	assert.NotNil(err)
+	_, _, err = LoadClientCertificate(certFile, certFile)
+	assert.NotNil(err)
+}
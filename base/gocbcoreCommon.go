@@ -2,9 +2,11 @@ package base
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"github.com/couchbase/gocbcore/v10"
 	"net/url"
+	"reflect"
 	"strings"
 	"time"
 )
@@ -39,7 +41,7 @@ func (c *CertificateAuth) SupportsNonTLS() bool {
 func (c *CertificateAuth) Certificate(req gocbcore.AuthCertRequest) (*tls.Certificate, error) {
 	clientCert, err := tls.X509KeyPair(c.CertificateBytes, c.PrivateKey)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid keypair")
+		return nil, fmt.Errorf("Invalid keypair: %v", err)
 	}
 	return &clientCert, nil
 }
@@ -98,3 +100,52 @@ func TagCouchbaseSecurePrefix(url *string) {
 }
 
 var ScramShaAuth = []gocbcore.AuthMechanism{gocbcore.ScramSha1AuthMechanism, gocbcore.ScramSha256AuthMechanism, gocbcore.ScramSha512AuthMechanism}
+
+// The pool of certificates the cluster is verified against when contacted over TLS
+func NewRootCAPool(rootCAs []byte) (*x509.CertPool, error) {
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(rootCAs) {
+		return nil, fmt.Errorf("Invalid rootCA %s", rootCAs)
+	}
+	return certPool, nil
+}
+
+// Returns the security config shared by all the gocbcore agents, given a *PasswordAuth or a *CertificateAuth
+// A client certificate is presented during the TLS handshake, in place of SASL authentication, so it requires TLS
+func NewSecurityConfig(useTLS bool, rootCAs []byte, authIn interface{}) (gocbcore.SecurityConfig, error) {
+	certPool := x509.NewCertPool()
+	if useTLS {
+		var err error
+		if certPool, err = NewRootCAPool(rootCAs); err != nil {
+			return gocbcore.SecurityConfig{}, err
+		}
+	}
+
+	var auth gocbcore.AuthProvider
+	switch authMech := authIn.(type) {
+	case *PasswordAuth:
+		auth = gocbcore.PasswordAuthProvider{
+			Username: authMech.Username,
+			Password: authMech.Password,
+		}
+	case *CertificateAuth:
+		if !useTLS {
+			return gocbcore.SecurityConfig{}, fmt.Errorf("client certificate authentication requires TLS")
+		}
+		if _, err := authMech.Certificate(gocbcore.AuthCertRequest{}); err != nil {
+			return gocbcore.SecurityConfig{}, err
+		}
+		auth = authMech
+	default:
+		return gocbcore.SecurityConfig{}, fmt.Errorf("unknown auth type %v", reflect.TypeOf(authIn))
+	}
+
+	return gocbcore.SecurityConfig{
+		UseTLS: useTLS,
+		TLSRootCAProvider: func() *x509.CertPool {
+			return certPool
+		},
+		Auth:           auth,
+		AuthMechanisms: ScramShaAuth,
+	}, nil
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// Self-signed if parent is nil
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func newTestCA(t *testing.T) *testCertificate {
+	return newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "xdcrDiffer test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+// The same TLS config gocbcore builds from a security config
+func tlsConfigFromSecurityConfig(securityConfig gocbcore.SecurityConfig) *tls.Config {
+	return &tls.Config{
+		RootCAs: securityConfig.TLSRootCAProvider(),
+		GetClientCertificate: func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := securityConfig.Auth.Certificate(gocbcore.AuthCertRequest{})
+			if err != nil {
+				return nil, err
+			}
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+	}
+}
+
+func TestSecurityConfigMutualTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	ca := newTestCA(t)
+	serverCert := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	clientCert := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "Administrator"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	// Stands in for a cluster at the strict security level, which only accepts clients with a certificate it trusts
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	serverKeyPair, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+	assert.Nil(err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(securityConfig gocbcore.SecurityConfig) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfigFromSecurityConfig(securityConfig)}}
+		res, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		body := make([]byte, 64)
+		n, _ := res.Body.Read(body)
+		return string(body[:n]), nil
+	}
+
+	certAuth := &CertificateAuth{CertificateBytes: clientCert.certPEM, PrivateKey: clientCert.keyPEM}
+	securityConfig, err := NewSecurityConfig(true, ca.certPEM, certAuth)
+	assert.Nil(err)
+	assert.True(securityConfig.UseTLS)
+	// the client certificate authenticates the connection, so there are no credentials for SASL
+	creds, err := securityConfig.Auth.Credentials(gocbcore.AuthCredsRequest{})
+	assert.Nil(err)
+	assert.Equal("", creds[0].Username)
+	subject, err := get(securityConfig)
+	assert.Nil(err)
+	assert.Equal("Administrator", subject)
+
+	// no client certificate
+	securityConfig, err = NewSecurityConfig(true, ca.certPEM, &PasswordAuth{Username: "Administrator", Password: "password"})
+	assert.Nil(err)
+	_, err = get(securityConfig)
+	assert.NotNil(err)
+
+	// the server is not trusted, as the client certificate is not one of the root CAs
+	otherCA := newTestCA(t)
+	securityConfig, err = NewSecurityConfig(true, otherCA.certPEM, certAuth)
+	assert.Nil(err)
+	_, err = get(securityConfig)
+	assert.NotNil(err)
+}
+
+func TestNewSecurityConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	ca := newTestCA(t)
+	securityConfig, err := NewSecurityConfig(false, nil, &PasswordAuth{Username: "Administrator", Password: "password"})
+	assert.Nil(err)
+	assert.False(securityConfig.UseTLS)
+	assert.Equal(ScramShaAuth, securityConfig.AuthMechanisms)
+
+	_, err = NewSecurityConfig(true, []byte("not a certificate"), &PasswordAuth{})
+	assert.NotNil(err)
+
+	certAuth := &CertificateAuth{CertificateBytes: ca.certPEM, PrivateKey: ca.keyPEM}
+	_, err = NewSecurityConfig(false, nil, certAuth)
+	assert.NotNil(err)
+	_, err = NewSecurityConfig(true, ca.certPEM, &CertificateAuth{CertificateBytes: ca.certPEM, PrivateKey: newTestCA(t).keyPEM})
+	assert.NotNil(err)
+	_, err = NewSecurityConfig(true, ca.certPEM, "Administrator")
+	assert.NotNil(err)
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const DefaultScopeCollectionName = "_default"
+const KeyListStdin = "-"
+const keyListNamespaceDelimiter = "/"
+const keyListScopeCollectionDelimiter = "."
+
+// One document to be verified, i.e. "inventory.airline/airline_10"
+// An entry without a namespace refers to the default collection
+type KeyListEntry struct {
+	ScopeName      string
+	CollectionName string
+	Key            string
+}
+
+func (k *KeyListEntry) Namespace() string {
+	return k.ScopeName + keyListScopeCollectionDelimiter + k.CollectionName
+}
+
+func (k *KeyListEntry) String() string {
+	return k.Namespace() + keyListNamespaceDelimiter + k.Key
+}
+
+// Reads the key list from the given file, or from stdin if the path is "-"
+func LoadKeyList(path string) ([]*KeyListEntry, error) {
+	rawEntries, err := ReadKeyList(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeyListEntries(rawEntries)
+}
+
+// Each entry is "scope.collection/key" or simply "key" for the default collection
+// Since scope and collection names cannot contain "/" or ".", only the first "/" is considered as the delimiter
+// and only if what precedes it looks like a namespace. A default collection key that itself contains "/" must
+// then be fully qualified, i.e. "_default._default/a.b/c"
+func ParseKeyList(reader io.Reader) ([]*KeyListEntry, error) {
+	rawEntries, err := readKeyListEntries(reader)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeyListEntries(rawEntries)
+}
+
+// Reads the entries of a key list from the given file, or from stdin if the path is "-", without interpreting them
+func ReadKeyList(path string) ([]string, error) {
+	var reader io.Reader
+	if path == KeyListStdin {
+		reader = os.Stdin
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	return readKeyListEntries(reader)
+}
+
+// The list is either a JSON array of strings, or newline-separated entries
+// Blank entries are skipped and duplicates are dropped
+func readKeyListEntries(reader io.Reader) ([]string, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var rawEntries []string
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &rawEntries)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse JSON key list - %v", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Split(bufio.ScanLines)
+		for scanner.Scan() {
+			rawEntries = append(rawEntries, scanner.Text())
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []string
+	dedupMap := make(map[string]bool)
+	for _, rawEntry := range rawEntries {
+		rawEntry = strings.TrimSpace(rawEntry)
+		if rawEntry == "" || dedupMap[rawEntry] {
+			continue
+		}
+		dedupMap[rawEntry] = true
+		entries = append(entries, rawEntry)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("key list is empty")
+	}
+	return entries, nil
+}
+
+func parseKeyListEntries(rawEntries []string) ([]*KeyListEntry, error) {
+	var entries []*KeyListEntry
+	dedupMap := make(map[string]bool)
+	for _, rawEntry := range rawEntries {
+		entry := parseKeyListEntry(rawEntry)
+		if entry.Key == "" {
+			return nil, fmt.Errorf("entry %v does not contain a document key", rawEntry)
+		}
+		// "key" and "_default._default/key" are the same document
+		if dedupMap[entry.String()] {
+			continue
+		}
+		dedupMap[entry.String()] = true
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func parseKeyListEntry(rawEntry string) *KeyListEntry {
+	entry := &KeyListEntry{
+		ScopeName:      DefaultScopeCollectionName,
+		CollectionName: DefaultScopeCollectionName,
+		Key:            rawEntry,
+	}
+
+	delimIdx := strings.Index(rawEntry, keyListNamespaceDelimiter)
+	if delimIdx < 0 {
+		return entry
+	}
+	namespace := strings.Split(rawEntry[:delimIdx], keyListScopeCollectionDelimiter)
+	if len(namespace) != 2 || namespace[0] == "" || namespace[1] == "" {
+		return entry
+	}
+	entry.ScopeName = namespace[0]
+	entry.CollectionName = namespace[1]
+	entry.Key = rawEntry[delimIdx+1:]
+	return entry
+}
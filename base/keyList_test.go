@@ -0,0 +1,42 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeyList(t *testing.T) {
+	assert := assert.New(t)
+
+	entries, err := ParseKeyList(strings.NewReader("inventory.airline/airline_10\n\nplainKey\nnot/a/namespace\ninventory.airline/airline_10\n"))
+	assert.Nil(err)
+	assert.Equal(3, len(entries))
+	assert.Equal("inventory", entries[0].ScopeName)
+	assert.Equal("airline", entries[0].CollectionName)
+	assert.Equal("airline_10", entries[0].Key)
+	assert.Equal("_default._default/plainKey", entries[1].String())
+	assert.Equal("not/a/namespace", entries[2].Key)
+
+	entries, err = ParseKeyList(strings.NewReader(`["S1.col1/k1", "_default._default/a.b/c"]`))
+	assert.Nil(err)
+	assert.Equal(2, len(entries))
+	assert.Equal("S1.col1", entries[0].Namespace())
+	assert.Equal("a.b/c", entries[1].Key)
+
+	_, err = ParseKeyList(strings.NewReader("S1.col1/"))
+	assert.NotNil(err)
+
+	_, err = ParseKeyList(strings.NewReader("\n"))
+	assert.NotNil(err)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// KeySelector restricts the diff to the documents whose keys match a prefix, a regular expression,
+// or are listed in a keys file. A nil KeySelector selects every key
+type KeySelector struct {
+	prefix []byte
+	regex  *regexp.Regexp
+	// Non-nil only in keys-from-file mode
+	keys map[string]bool
+}
+
+// Returns nil if none of the selection criteria are specified
+// A trailing "*" in the prefix is accepted, i.e. "order::2026-10*"
+func NewKeySelector(prefix, pattern, keysFile string) (*KeySelector, error) {
+	if prefix == "" && pattern == "" && keysFile == "" {
+		return nil, nil
+	}
+
+	selector := &KeySelector{
+		prefix: []byte(strings.TrimSuffix(prefix, "*")),
+	}
+
+	if pattern != "" {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key regex %v - %v", pattern, err)
+		}
+		selector.regex = regex
+	}
+
+	if keysFile != "" {
+		keys, err := readKeysFile(keysFile)
+		if err != nil {
+			return nil, err
+		}
+		selector.keys = keys
+	}
+	return selector, nil
+}
+
+// Read the same way as the key list of verifyKeysFile, but the entries are plain keys that apply to every collection
+func readKeysFile(keysFile string) (map[string]bool, error) {
+	rawKeys, err := ReadKeyList(keysFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read keys file %v - %v", keysFile, err)
+	}
+
+	keys := make(map[string]bool)
+	for _, key := range rawKeys {
+		keys[key] = true
+	}
+	return keys, nil
+}
+
+func (k *KeySelector) Matches(key []byte) bool {
+	if k == nil {
+		return true
+	}
+	if len(k.prefix) > 0 && !bytes.HasPrefix(key, k.prefix) {
+		return false
+	}
+	if k.regex != nil && !k.regex.Match(key) {
+		return false
+	}
+	if k.keys != nil && !k.keys[string(key)] {
+		return false
+	}
+	return true
+}
+
+// In keys-from-file mode, there is no need to stream anything from DCP since the keys to verify are known upfront
+func (k *KeySelector) IsKeysFileMode() bool {
+	return k != nil && k.keys != nil
+}
+
+// Returns the sorted list of keys from the keys file that also satisfy the prefix and regex, if any
+func (k *KeySelector) Keys() []string {
+	if !k.IsKeysFileMode() {
+		return nil
+	}
+	var keys []string
+	for key := range k.keys {
+		if k.Matches([]byte(key)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (k *KeySelector) String() string {
+	if k == nil {
+		return "KeySelector{}"
+	}
+	var regexStr string
+	if k.regex != nil {
+		regexStr = k.regex.String()
+	}
+	return fmt.Sprintf("KeySelector{prefix: %s, regex: %s, keysFromFile: %d}", k.prefix, regexStr, len(k.keys))
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySelector(t *testing.T) {
+	assert := assert.New(t)
+
+	selector, err := NewKeySelector("", "", "")
+	assert.Nil(err)
+	assert.Nil(selector)
+	assert.True(selector.Matches([]byte("anything")))
+	assert.False(selector.IsKeysFileMode())
+
+	selector, err = NewKeySelector("order::2026-10*", "", "")
+	assert.Nil(err)
+	assert.True(selector.Matches([]byte("order::2026-10-01")))
+	assert.False(selector.Matches([]byte("order::2026-09-30")))
+
+	selector, err = NewKeySelector("order::", "::2026-1[01]-", "")
+	assert.Nil(err)
+	assert.True(selector.Matches([]byte("order::2026-11-01")))
+	assert.False(selector.Matches([]byte("order::2026-12-01")))
+	assert.False(selector.Matches([]byte("user::2026-11-01")))
+
+	_, err = NewKeySelector("", "[", "")
+	assert.NotNil(err)
+}
+
+func TestKeySelectorKeysFile(t *testing.T) {
+	assert := assert.New(t)
+	keysFile := filepath.Join(t.TempDir(), "keys.txt")
+
+	err := ioutil.WriteFile(keysFile, []byte("order::2\n\norder::1\nuser::1\n"), 0644)
+	assert.Nil(err)
+
+	selector, err := NewKeySelector("order::", "", keysFile)
+	assert.Nil(err)
+	assert.True(selector.IsKeysFileMode())
+	assert.Equal([]string{"order::1", "order::2"}, selector.Keys())
+	assert.False(selector.Matches([]byte("order::3")))
+}
+
+func TestKeySelectorKeysFileJSON(t *testing.T) {
+	assert := assert.New(t)
+	keysFile := filepath.Join(t.TempDir(), "keys.json")
+
+	err := ioutil.WriteFile(keysFile, []byte(`["order::2", " order::1 ", "order::2"]`), 0644)
+	assert.Nil(err)
+
+	selector, err := NewKeySelector("", "", keysFile)
+	assert.Nil(err)
+	assert.Equal([]string{"order::1", "order::2"}, selector.Keys())
+
+	err = ioutil.WriteFile(keysFile, []byte("\n \n"), 0644)
+	assert.Nil(err)
+	_, err = NewKeySelector("", "", keysFile)
+	assert.NotNil(err)
+}
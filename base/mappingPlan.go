@@ -0,0 +1,143 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// How the source collections of the replication map to target collections
+const (
+	MappingRuleImplicit  = "implicit"
+	MappingRuleExplicit  = "explicit"
+	MappingRuleMigration = "migration"
+)
+
+type MappingTarget struct {
+	Namespace string
+	ColId     uint32
+}
+
+type MappingPlanEntry struct {
+	SourceNamespace string
+	SourceColId     uint32
+	Targets         []*MappingTarget
+	Rule            string
+}
+
+// In migration mode, documents of the source default collection are routed by the first filter they pass
+// The differ refers to each filter by its index in the ordered list
+type MigrationFilter struct {
+	Index           int
+	Expression      string
+	TargetNamespace string
+	TargetColId     uint32
+}
+
+// The collection mapping as compiled by the differ, which the collection IDs of its outputs refer to
+type MappingPlan struct {
+	Rule             string
+	Entries          []*MappingPlanEntry
+	MigrationFilters []*MigrationFilter `json:",omitempty"`
+}
+
+func NewMappingPlan(rule string) *MappingPlan {
+	return &MappingPlan{Rule: rule}
+}
+
+func (p *MappingPlan) entry(srcNs string, srcColId uint32) *MappingPlanEntry {
+	for _, entry := range p.Entries {
+		if entry.SourceColId == srcColId {
+			return entry
+		}
+	}
+	entry := &MappingPlanEntry{SourceNamespace: srcNs, SourceColId: srcColId, Rule: p.Rule}
+	p.Entries = append(p.Entries, entry)
+	return entry
+}
+
+// Maps the source collection to the target collection, in place of any it was mapped to before
+func (p *MappingPlan) Map(srcNs string, srcColId uint32, tgtNs string, tgtColId uint32) {
+	p.entry(srcNs, srcColId).Targets = []*MappingTarget{{Namespace: tgtNs, ColId: tgtColId}}
+}
+
+// Appends a filter to the ordered list, and its target to the ones of the source default collection
+func (p *MappingPlan) AddMigrationFilter(expression, tgtNs string, tgtColId uint32) {
+	p.MigrationFilters = append(p.MigrationFilters, &MigrationFilter{
+		Index:           len(p.MigrationFilters),
+		Expression:      expression,
+		TargetNamespace: tgtNs,
+		TargetColId:     tgtColId,
+	})
+	entry := p.entry(DefaultScopeCollectionName+"."+DefaultScopeCollectionName, 0)
+	entry.Targets = append(entry.Targets, &MappingTarget{Namespace: tgtNs, ColId: tgtColId})
+}
+
+// Drops the source collections that are not in the compiled mapping of source to target collection IDs
+func (p *MappingPlan) Retain(srcToTgtColIds map[uint32][]uint32) {
+	var entries []*MappingPlanEntry
+	for _, entry := range p.Entries {
+		if _, exists := srcToTgtColIds[entry.SourceColId]; exists {
+			entries = append(entries, entry)
+		}
+	}
+	p.Entries = entries
+}
+
+func (p *MappingPlan) Write(fileName string) error {
+	sort.Slice(p.Entries, func(i, j int) bool { return p.Entries[i].SourceColId < p.Entries[j].SourceColId })
+	bytes, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, bytes, 0644)
+}
+
+func LoadMappingPlan(fileName string) (*MappingPlan, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	plan := &MappingPlan{}
+	if err = json.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("%v: %v", fileName, err)
+	}
+	return plan, nil
+}
+
+// Writes the plan as a human-readable table, one row per source collection and migration filter
+func (p *MappingPlan) WriteTable(w io.Writer) error {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Collection mapping (%v)\n", p.Rule)
+	fmt.Fprintf(writer, "  SOURCE\tSOURCE ID\tTARGET\tTARGET ID\tRULE\n")
+	for _, entry := range p.Entries {
+		var namespaces, colIds []string
+		for _, target := range entry.Targets {
+			namespaces = append(namespaces, target.Namespace)
+			colIds = append(colIds, fmt.Sprintf("%v", target.ColId))
+		}
+		fmt.Fprintf(writer, "  %v\t%v\t%v\t%v\t%v\n", entry.SourceNamespace, entry.SourceColId,
+			strings.Join(namespaces, ","), strings.Join(colIds, ","), entry.Rule)
+	}
+	if len(p.MigrationFilters) > 0 {
+		fmt.Fprintf(writer, "Migration filters, in the order they are applied\n")
+		fmt.Fprintf(writer, "  INDEX\tFILTER\tTARGET\tTARGET ID\n")
+		for _, filter := range p.MigrationFilters {
+			fmt.Fprintf(writer, "  %v\t%v\t%v\t%v\n", filter.Index, filter.Expression, filter.TargetNamespace, filter.TargetColId)
+		}
+	}
+	return writer.Flush()
+}
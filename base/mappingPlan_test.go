@@ -0,0 +1,51 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMappingPlan(t *testing.T) {
+	assert := assert.New(t)
+
+	plan := NewMappingPlan(MappingRuleExplicit)
+	plan.Map("S1.col2", 9, "S2.col1", 12)
+	plan.Map("S1.col1", 8, "S2.col1", 12)
+	// a later rule for the same source collection replaces the earlier one
+	plan.Map("S1.col1", 8, "S2.col2", 13)
+	plan.Retain(map[uint32][]uint32{8: {13}})
+	assert.Len(plan.Entries, 1)
+	assert.Equal("S2.col2", plan.Entries[0].Targets[0].Namespace)
+	assert.Equal(MappingRuleExplicit, plan.Entries[0].Rule)
+
+	fileName := filepath.Join(t.TempDir(), MappingPlanFileName)
+	assert.Nil(plan.Write(fileName))
+	loaded, err := LoadMappingPlan(fileName)
+	assert.Nil(err)
+	assert.Equal(plan, loaded)
+
+	plan = NewMappingPlan(MappingRuleMigration)
+	plan.AddMigrationFilter(`REGEXP_CONTAINS(META().id, "^a")`, "S.a", 8)
+	plan.AddMigrationFilter(`type = "b"`, "S.b", 9)
+	assert.Len(plan.Entries, 1)
+	assert.Equal("_default._default", plan.Entries[0].SourceNamespace)
+	assert.Len(plan.Entries[0].Targets, 2)
+	assert.Equal(1, plan.MigrationFilters[1].Index)
+
+	var table bytes.Buffer
+	assert.Nil(plan.WriteTable(&table))
+	assert.Contains(table.String(), "S.a,S.b")
+	assert.Contains(table.String(), `type = "b"`)
+}
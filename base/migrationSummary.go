@@ -0,0 +1,68 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"encoding/json"
+	"os"
+)
+
+type MigrationFilterSummary struct {
+	Index       int
+	Filter      string
+	TargetColId uint32
+	// source documents that pass the filter
+	SourceMatched int
+	// documents in the target collection, which may be the target of other filters as well
+	TargetItems int
+}
+
+// Accounts for the source documents in migration mode, where the source default collection is routed to
+// target collections by filters and the item counts of the buckets cannot be compared
+type MigrationSummary struct {
+	// in the order the filters are applied
+	Filters []*MigrationFilterSummary
+	// source documents that pass at least one filter
+	SourceMatched int
+	// source documents that pass more than one filter, and are replicated to each of their targets
+	SourceMultipleMatched int
+	// source documents that pass no filter, and are not replicated
+	SourceUnmatched int
+}
+
+// The number of copies of the source documents the filters route to their target collections
+func (s *MigrationSummary) ExpectedTargetItems() int {
+	var expected int
+	for _, filter := range s.Filters {
+		expected += filter.SourceMatched
+	}
+	return expected
+}
+
+// The number of documents the target collections of the filters hold, counting each collection once
+func (s *MigrationSummary) TargetItems() int {
+	var items int
+	counted := make(map[uint32]bool)
+	for _, filter := range s.Filters {
+		if !counted[filter.TargetColId] {
+			counted[filter.TargetColId] = true
+			items += filter.TargetItems
+		}
+	}
+	return items
+}
+
+func (s *MigrationSummary) Write(fileName string) error {
+	bytes, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, bytes, 0644)
+}
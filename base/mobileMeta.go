@@ -0,0 +1,157 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// What Sync Gateway recorded about a document in its _sync and _mou xattrs
+type MobileMeta struct {
+	// CAS of the document itself
+	Cas uint64
+	// Current revision of the rev tree, empty if the document has no _sync xattr
+	SyncRev string
+	// CAS of the last write Sync Gateway made or imported
+	SyncCas uint64
+	// CAS of the write that imported the document, and the CAS before it
+	ImportCas    uint64
+	PreImportCas uint64
+}
+
+// The latest write of the document is Sync Gateway importing it, which only changes its metadata
+func (m MobileMeta) IsImport() bool {
+	return m.ImportCas != 0 && m.ImportCas == m.Cas
+}
+
+// The document was written since Sync Gateway last saw it, and is yet to be imported
+func (m MobileMeta) IsImportPending() bool {
+	return m.SyncCas != 0 && m.SyncCas != m.Cas && !m.IsImport()
+}
+
+// The CAS of the document had Sync Gateway not imported it
+func (m MobileMeta) casBeforeImport() uint64 {
+	if m.IsImport() && m.PreImportCas != 0 {
+		return m.PreImportCas
+	}
+	return m.Cas
+}
+
+// Sync Gateway macro-expands CAS values into a quoted, little-endian hex string, i.e. "0x0000f8da4d881416"
+func ParseMacroExpandedCas(value []byte) (uint64, error) {
+	str := strings.TrimPrefix(strings.Trim(string(value), `"`), "0x")
+	if str == "" {
+		return 0, nil
+	}
+	if len(str) > 16 {
+		return 0, fmt.Errorf("invalid CAS %s", value)
+	}
+	bytes, err := hex.DecodeString(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CAS %s: %v", value, err)
+	}
+	padded := make([]byte, 8)
+	copy(padded, bytes)
+	return binary.LittleEndian.Uint64(padded), nil
+}
+
+// The current revision is a string, or an object holding it since Sync Gateway 4.0
+func ParseSyncRev(value []byte) (string, error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+	var rev string
+	if err := json.Unmarshal(value, &rev); err == nil {
+		return rev, nil
+	}
+	var revObj struct {
+		Rev string `json:"rev"`
+	}
+	if err := json.Unmarshal(value, &revObj); err != nil {
+		return "", fmt.Errorf("invalid _sync rev %s: %v", value, err)
+	}
+	return revObj.Rev, nil
+}
+
+// Returns the current revision and CAS recorded in a _sync xattr
+func ParseSyncXattr(value []byte) (string, uint64, error) {
+	var sync struct {
+		Rev json.RawMessage `json:"rev"`
+		Cas json.RawMessage `json:"cas"`
+	}
+	if err := json.Unmarshal(value, &sync); err != nil {
+		return "", 0, fmt.Errorf("invalid _sync xattr: %v", err)
+	}
+	rev, err := ParseSyncRev(sync.Rev)
+	if err != nil {
+		return "", 0, err
+	}
+	cas, err := ParseMacroExpandedCas(sync.Cas)
+	if err != nil {
+		return "", 0, err
+	}
+	return rev, cas, nil
+}
+
+// Returns the CAS before the import recorded in a _mou xattr
+func ParseMouPreImportCas(value []byte) (uint64, error) {
+	var mou struct {
+		PCas json.RawMessage `json:"pCas"`
+	}
+	if err := json.Unmarshal(value, &mou); err != nil {
+		return 0, fmt.Errorf("invalid _mou xattr: %v", err)
+	}
+	return ParseMacroExpandedCas(mou.PCas)
+}
+
+// How Sync Gateway metadata explains a mismatched document
+type MobileDivergence int
+
+const (
+	// Sync Gateway metadata does not explain the mismatch
+	MobileDivergenceNone MobileDivergence = iota
+	// Sync Gateway imported the document on one side only, so the sides only differ in metadata
+	MobileImportOnly MobileDivergence = iota
+	// Either side has a write Sync Gateway is yet to import
+	MobileImportPending MobileDivergence = iota
+	// The current revisions of the rev trees differ
+	MobileRevTreeMismatch MobileDivergence = iota
+)
+
+func (m MobileDivergence) String() string {
+	switch m {
+	case MobileImportOnly:
+		return "ImportOnly"
+	case MobileImportPending:
+		return "ImportPending"
+	case MobileRevTreeMismatch:
+		return "RevTreeMismatch"
+	default:
+		return "None"
+	}
+}
+
+// An import does not change the body, so a difference can only be caused by it if the bodies are the same
+func CompareMobileMeta(source, target MobileMeta, bodySame bool) MobileDivergence {
+	if bodySame && (source.IsImport() || target.IsImport()) && source.casBeforeImport() == target.casBeforeImport() {
+		return MobileImportOnly
+	}
+	if source.IsImportPending() || target.IsImportPending() {
+		return MobileImportPending
+	}
+	if source.SyncRev != "" && target.SyncRev != "" && source.SyncRev != target.SyncRev {
+		return MobileRevTreeMismatch
+	}
+	return MobileDivergenceNone
+}
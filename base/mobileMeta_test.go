@@ -0,0 +1,59 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMobileXattrs(t *testing.T) {
+	assert := assert.New(t)
+
+	cas, err := ParseMacroExpandedCas([]byte(`"0x0000f8da4d881416"`))
+	assert.Nil(err)
+	assert.Equal(uint64(0x1614884ddaf80000), cas)
+	_, err = ParseMacroExpandedCas([]byte(`"0xzz"`))
+	assert.NotNil(err)
+
+	rev, cas, err := ParseSyncXattr([]byte(`{"rev":"2-abc","cas":"0x0100000000000000","history":{}}`))
+	assert.Nil(err)
+	assert.Equal("2-abc", rev)
+	assert.Equal(uint64(1), cas)
+	rev, _, err = ParseSyncXattr([]byte(`{"rev":{"rev":"3-def","src":"x","ver":"0x01"}}`))
+	assert.Nil(err)
+	assert.Equal("3-def", rev)
+
+	cas, err = ParseMouPreImportCas([]byte(`{"cas":"0x0200000000000000","pCas":"0x0100000000000000","pRev":"1"}`))
+	assert.Nil(err)
+	assert.Equal(uint64(1), cas)
+}
+
+func TestCompareMobileMeta(t *testing.T) {
+	assert := assert.New(t)
+
+	// imported on the source only
+	source := MobileMeta{Cas: 20, SyncRev: "1-a", SyncCas: 20, ImportCas: 20, PreImportCas: 10}
+	target := MobileMeta{Cas: 10}
+	assert.True(source.IsImport())
+	assert.Equal(MobileImportOnly, CompareMobileMeta(source, target, true))
+	assert.Equal(MobileDivergenceNone, CompareMobileMeta(source, target, false))
+
+	// written on the target since it was last imported
+	target = MobileMeta{Cas: 30, SyncRev: "1-a", SyncCas: 20}
+	assert.True(target.IsImportPending())
+	assert.Equal(MobileImportPending, CompareMobileMeta(source, target, true))
+
+	target.Cas = 20
+	target.SyncRev = "2-b"
+	assert.Equal(MobileRevTreeMismatch, CompareMobileMeta(source, target, false))
+	assert.Equal("RevTreeMismatch", MobileRevTreeMismatch.String())
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// What a run found, counted from its output files
+// A stage that has not run, or did not get to write its outputs, is left nil
+type OutputSummary struct {
+	// Number of documents per category of the file differ, i.e. Mismatch or MissingFromTarget
+	FileDiffer map[string]int
+	// Number of documents with a different expiry on each side
+	TtlDrift *int
+	// Number of documents per category of the mutation differ, and per source collection ID within it
+	MutationDiffer map[string]map[string]int
+	// Number of documents that the mutation differ was unable to fetch
+	KeysWithError *int
+	Preflight     *PreflightSummary
+	// Only written by the file differ in migration mode
+	Migration *MigrationSummary
+}
+
+type PreflightSummary struct {
+	SourceItems        uint64
+	TargetItems        uint64
+	SuspiciousVbuckets string
+}
+
+func LoadOutputSummary(fileDifferDir, mutationDifferDir, preflightDir string) (*OutputSummary, error) {
+	summary := &OutputSummary{}
+	var err error
+	if summary.FileDiffer, err = loadFileDifferSummary(fileDifferDir); err != nil {
+		return nil, err
+	}
+	if summary.TtlDrift, err = countJsonEntries(filepath.Join(fileDifferDir, TtlDriftReportFileName), 2); err != nil {
+		return nil, err
+	}
+	if summary.MutationDiffer, err = loadMutationDifferSummary(mutationDifferDir); err != nil {
+		return nil, err
+	}
+	if summary.KeysWithError, err = countJsonEntries(filepath.Join(mutationDifferDir, DiffErrorKeysFileName), 1); err != nil {
+		return nil, err
+	}
+
+	migrationFileName := filepath.Join(fileDifferDir, MigrationSummaryFileName)
+	data, err := os.ReadFile(migrationFileName)
+	if err == nil {
+		summary.Migration = &MigrationSummary{}
+		if err = json.Unmarshal(data, summary.Migration); err != nil {
+			return nil, fmt.Errorf("%v: %v", migrationFileName, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	preflightFileName := filepath.Join(preflightDir, PreflightReportFileName)
+	data, err = os.ReadFile(preflightFileName)
+	if err == nil {
+		summary.Preflight = &PreflightSummary{}
+		if err = json.Unmarshal(data, summary.Preflight); err != nil {
+			return nil, fmt.Errorf("%v: %v", preflightFileName, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// Each file differ worker appends the diff of every vbucket it has compared to its own diffDetails file,
+// as one JSON object per vbucket
+func loadFileDifferSummary(fileDifferDir string) (map[string]int, error) {
+	fileNames, err := filepath.Glob(filepath.Join(fileDifferDir, DiffDetailsFileName+FileNameDelimiter+"*"))
+	if err != nil || len(fileNames) == 0 {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, fileName := range fileNames {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var vbDiff map[string][]json.RawMessage
+			err = decoder.Decode(&vbDiff)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("%v: %v", fileName, err)
+			}
+			for category, entries := range vbDiff {
+				counts[category] += len(entries)
+			}
+		}
+	}
+	return counts, nil
+}
+
+// The mutation differ output maps each category to the source collection IDs, and each of these to the keys
+func loadMutationDifferSummary(mutationDifferDir string) (map[string]map[string]int, error) {
+	fileName := filepath.Join(mutationDifferDir, MutationDiffFileName)
+	data, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var diffs map[string]map[string]map[string]json.RawMessage
+	if err = json.Unmarshal(data, &diffs); err != nil {
+		return nil, fmt.Errorf("%v: %v", fileName, err)
+	}
+	counts := make(map[string]map[string]int)
+	for category, colIdDiffs := range diffs {
+		counts[category] = make(map[string]int)
+		for colId, keys := range colIdDiffs {
+			counts[category][colId] = len(keys)
+		}
+	}
+	return counts, nil
+}
+
+// Counts the entries of a JSON array (depth 1), or of the arrays held by a JSON object (depth 2)
+func countJsonEntries(fileName string, depth int) (*int, error) {
+	data, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var count int
+	if depth == 1 {
+		var entries []json.RawMessage
+		err = json.Unmarshal(data, &entries)
+		count = len(entries)
+	} else {
+		var entries map[string][]json.RawMessage
+		err = json.Unmarshal(data, &entries)
+		for _, values := range entries {
+			count += len(values)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", fileName, err)
+	}
+	return &count, nil
+}
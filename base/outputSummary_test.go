@@ -0,0 +1,82 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadOutputSummary(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	fileDifferDir := filepath.Join(dir, FileDifferDir)
+	mutationDifferDir := filepath.Join(dir, MutationDifferDir)
+	preflightDir := filepath.Join(dir, PreflightDir)
+
+	// nothing has run yet
+	summary, err := LoadOutputSummary(fileDifferDir, mutationDifferDir, preflightDir)
+	assert.Nil(err)
+	assert.Nil(summary.FileDiffer)
+	assert.Nil(summary.TtlDrift)
+	assert.Nil(summary.MutationDiffer)
+	assert.Nil(summary.KeysWithError)
+	assert.Nil(summary.Preflight)
+	assert.Nil(summary.Migration)
+
+	assert.Nil(os.MkdirAll(fileDifferDir, 0777))
+	assert.Nil(os.MkdirAll(mutationDifferDir, 0777))
+	assert.Nil(os.MkdirAll(preflightDir, 0777))
+	writeFile := func(name, content string) {
+		assert.Nil(os.WriteFile(name, []byte(content), 0644))
+	}
+	writeFile(filepath.Join(fileDifferDir, DiffDetailsFileName+"_0"),
+		`{"Mismatch":[{"Key":"a"}],"MissingFromSource":[],"MissingFromTarget":[{"Key":"b"},{"Key":"c"}]}{"Mismatch":[{"Key":"d"}],"MissingFromSource":null,"MissingFromTarget":[]}`)
+	writeFile(filepath.Join(fileDifferDir, DiffDetailsFileName+"_1"), `{"Mismatch":[{"Key":"e"}],"MissingFromSource":[{"Key":"f"}],"MissingFromTarget":[]}`)
+	writeFile(filepath.Join(fileDifferDir, TtlDriftReportFileName), `{"0":[{"Key":"a"}],"8":[{"Key":"g"},{"Key":"h"}]}`)
+	writeFile(filepath.Join(mutationDifferDir, MutationDiffFileName),
+		`{"Mismatch":{"0":{"a":[]}},"MissingFromSource":{},"MissingFromTarget":{"0":{"b":{}},"8":{"c":{},"i":{}}}}`)
+	writeFile(filepath.Join(mutationDifferDir, DiffErrorKeysFileName), `null`)
+	writeFile(filepath.Join(preflightDir, PreflightReportFileName), `{"SourceItems":10,"TargetItems":7,"SuspiciousVbuckets":"1,5-6"}`)
+	migration := &MigrationSummary{
+		Filters: []*MigrationFilterSummary{
+			{Index: 0, Filter: `type="a"`, TargetColId: 8, SourceMatched: 3, TargetItems: 4},
+			{Index: 1, Filter: `type="a" OR type="b"`, TargetColId: 9, SourceMatched: 5, TargetItems: 5},
+			{Index: 2, Filter: `type="c"`, TargetColId: 8, SourceMatched: 1, TargetItems: 4},
+		},
+		SourceMatched:         6,
+		SourceMultipleMatched: 3,
+		SourceUnmatched:       2,
+	}
+	assert.Nil(migration.Write(filepath.Join(fileDifferDir, MigrationSummaryFileName)))
+
+	summary, err = LoadOutputSummary(fileDifferDir, mutationDifferDir, preflightDir)
+	assert.Nil(err)
+	assert.Equal(map[string]int{"Mismatch": 3, "MissingFromSource": 1, "MissingFromTarget": 2}, summary.FileDiffer)
+	assert.Equal(3, *summary.TtlDrift)
+	assert.Equal(map[string]map[string]int{
+		"Mismatch":          {"0": 1},
+		"MissingFromSource": {},
+		"MissingFromTarget": {"0": 1, "8": 2},
+	}, summary.MutationDiffer)
+	assert.Equal(0, *summary.KeysWithError)
+	assert.Equal(&PreflightSummary{SourceItems: 10, TargetItems: 7, SuspiciousVbuckets: "1,5-6"}, summary.Preflight)
+	assert.Equal(migration, summary.Migration)
+	assert.Equal(9, summary.Migration.ExpectedTargetItems())
+	assert.Equal(9, summary.Migration.TargetItems())
+
+	writeFile(filepath.Join(fileDifferDir, DiffDetailsFileName+"_1"), `{"Mismatch":[`)
+	_, err = LoadOutputSummary(fileDifferDir, mutationDifferDir, preflightDir)
+	assert.NotNil(err)
+}
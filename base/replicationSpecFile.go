@@ -0,0 +1,203 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The bucket default of versionPruningWindowHrs
+const DefaultVersionPruningWindowHrs = 720
+
+const (
+	MobileCompatibilityOff    = 0
+	MobileCompatibilityActive = 1
+)
+
+// Replication settings given in a file instead of being read from metakv
+// The keys are those of the replication settings REST API, so that the output of
+// GET /settings/replications/<replicationId> can be used as is. Keys that the differ
+// does not need, such as the other replication settings, are ignored with a warning
+// versionPruningWindowHrs is a bucket setting and is not part of that output, so it
+// is added by hand if the buckets do not use the default
+type ReplicationSpecFile struct {
+	FilterExpression           string
+	FilterExpiration           bool
+	FilterDeletion             bool
+	FilterBypassExpiry         bool
+	FilterBinary               bool
+	MobileCompatible           int
+	CollectionsExplicitMapping bool
+	CollectionsMigrationMode   bool
+	ColMappingRules            map[string]interface{}
+	VersionPruningWindowHrs    int
+}
+
+// The keys of the replication settings that the differ uses
+var replicationSpecFileKeys = []string{"filterExpression", "filterExpiration", "filterDeletion", "filterBypassExpiry", "filterBinary",
+	"mobile", "collectionsExplicitMapping", "collectionsMigrationMode", "colMappingRules", "versionPruningWindowHrs"}
+
+// Keys that are ignored are returned as warnings, like those of the config file
+func LoadReplicationSpecFile(path string) (*ReplicationSpecFile, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	specFile, warnings, err := ParseReplicationSpecFile(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %v", path, err)
+	}
+	for i := range warnings {
+		warnings[i] = fmt.Sprintf("%v: %v", path, warnings[i])
+	}
+	return specFile, warnings, nil
+}
+
+func ParseReplicationSpecFile(data []byte) (*ReplicationSpecFile, []string, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse replication settings - %v", err)
+	}
+	specFile, err := parseReplicationSpecFileValues(values)
+	if err != nil {
+		return nil, nil, err
+	}
+	return specFile, unusedSpecFileKeyWarnings(values), nil
+}
+
+// A key that only differs from a used one by case is most likely a typo, so it is warned about on its own.
+// The other replication settings are expected in the output of the REST API, and are listed together
+func unusedSpecFileKeyWarnings(values map[string]json.RawMessage) []string {
+	var warnings []string
+	var unusedKeys []string
+	for key := range values {
+		var used, misspelt bool
+		for _, knownKey := range replicationSpecFileKeys {
+			if key == knownKey {
+				used = true
+			} else if strings.EqualFold(key, knownKey) {
+				misspelt = true
+				warnings = append(warnings, fmt.Sprintf("unknown key %v is ignored. Did you mean %v?", key, knownKey))
+			}
+		}
+		if !used && !misspelt {
+			unusedKeys = append(unusedKeys, key)
+		}
+	}
+	sort.Strings(warnings)
+	if len(unusedKeys) > 0 {
+		sort.Strings(unusedKeys)
+		warnings = append(warnings, fmt.Sprintf("keys %v are not used by the differ and are ignored", unusedKeys))
+	}
+	return warnings
+}
+
+func parseReplicationSpecFileValues(values map[string]json.RawMessage) (*ReplicationSpecFile, error) {
+
+	specFile := &ReplicationSpecFile{VersionPruningWindowHrs: DefaultVersionPruningWindowHrs}
+	var err error
+	for key, dest := range map[string]*bool{
+		"filterExpiration":           &specFile.FilterExpiration,
+		"filterDeletion":             &specFile.FilterDeletion,
+		"filterBypassExpiry":         &specFile.FilterBypassExpiry,
+		"filterBinary":               &specFile.FilterBinary,
+		"collectionsExplicitMapping": &specFile.CollectionsExplicitMapping,
+		"collectionsMigrationMode":   &specFile.CollectionsMigrationMode,
+	} {
+		if raw, ok := values[key]; ok {
+			if *dest, err = parseSpecFileBool(raw); err != nil {
+				return nil, fmt.Errorf("%v: %v", key, err)
+			}
+		}
+	}
+
+	if raw, ok := values["filterExpression"]; ok {
+		if err = json.Unmarshal(raw, &specFile.FilterExpression); err != nil {
+			return nil, fmt.Errorf("filterExpression: %v", err)
+		}
+	}
+	if raw, ok := values["mobile"]; ok {
+		if specFile.MobileCompatible, err = parseSpecFileMobile(raw); err != nil {
+			return nil, fmt.Errorf("mobile: %v", err)
+		}
+	}
+	if raw, ok := values["colMappingRules"]; ok {
+		if specFile.ColMappingRules, err = parseSpecFileRules(raw); err != nil {
+			return nil, fmt.Errorf("colMappingRules: %v", err)
+		}
+	}
+	if raw, ok := values["versionPruningWindowHrs"]; ok {
+		if err = json.Unmarshal(raw, &specFile.VersionPruningWindowHrs); err != nil {
+			return nil, fmt.Errorf("versionPruningWindowHrs: %v", err)
+		}
+		if specFile.VersionPruningWindowHrs < 0 {
+			return nil, fmt.Errorf("versionPruningWindowHrs cannot be negative")
+		}
+	}
+
+	if len(specFile.ColMappingRules) > 0 && !specFile.CollectionsExplicitMapping && !specFile.CollectionsMigrationMode {
+		return nil, fmt.Errorf("colMappingRules requires collectionsExplicitMapping or collectionsMigrationMode")
+	}
+	return specFile, nil
+}
+
+// The REST API returns booleans, but takes them as form values, so both are accepted
+func parseSpecFileBool(raw json.RawMessage) (bool, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return false, err
+	}
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(v)
+	}
+	return false, fmt.Errorf("expected a boolean, got %s", raw)
+}
+
+func parseSpecFileMobile(raw json.RawMessage) (int, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return 0, err
+	}
+	switch v := value.(type) {
+	case float64:
+		if v == MobileCompatibilityOff || v == MobileCompatibilityActive {
+			return int(v), nil
+		}
+	case string:
+		switch strings.ToLower(v) {
+		case "off":
+			return MobileCompatibilityOff, nil
+		case "active":
+			return MobileCompatibilityActive, nil
+		}
+	}
+	return 0, fmt.Errorf("expected \"Off\" or \"Active\", got %s", raw)
+}
+
+// The rules are an object, or the same object as a JSON string when copied from a REST request
+func parseSpecFileRules(raw json.RawMessage) (map[string]interface{}, error) {
+	var encoded string
+	if err := json.Unmarshal(raw, &encoded); err == nil {
+		raw = json.RawMessage(encoded)
+	}
+	var rules map[string]interface{}
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReplicationSpecFile(t *testing.T) {
+	assert := assert.New(t)
+
+	// as returned by GET /settings/replications/<replicationId>
+	specFile, warnings, err := ParseReplicationSpecFile([]byte(`{"checkpointInterval":600,"compressionType":"Auto","filterExpression":"REGEXP_CONTAINS(META().id, \"^a\")",
+		"filterDeletion":true,"filterExpiration":false,"filterBypassExpiry":true,"mobile":"Active",
+		"collectionsExplicitMapping":true,"collectionsMigrationMode":false,"colMappingRules":{"S1.col1":"S2.col2"}}`))
+	assert.Nil(err)
+	assert.Equal(`REGEXP_CONTAINS(META().id, "^a")`, specFile.FilterExpression)
+	assert.True(specFile.FilterDeletion)
+	assert.False(specFile.FilterExpiration)
+	assert.True(specFile.FilterBypassExpiry)
+	assert.Equal(MobileCompatibilityActive, specFile.MobileCompatible)
+	assert.True(specFile.CollectionsExplicitMapping)
+	assert.Equal(map[string]interface{}{"S1.col1": "S2.col2"}, specFile.ColMappingRules)
+	assert.Equal(DefaultVersionPruningWindowHrs, specFile.VersionPruningWindowHrs)
+	assert.Equal([]string{"keys [checkpointInterval compressionType] are not used by the differ and are ignored"}, warnings)
+
+	// as given to the REST API
+	specFile, warnings, err = ParseReplicationSpecFile([]byte(`{"collectionsMigrationMode":"true","colMappingRules":"{\"REGEXP_CONTAINS(type, \\\"a\\\")\":\"S1.col1\"}","mobile":0,"versionPruningWindowHrs":24}`))
+	assert.Nil(err)
+	assert.True(specFile.CollectionsMigrationMode)
+	assert.Equal(map[string]interface{}{`REGEXP_CONTAINS(type, "a")`: "S1.col1"}, specFile.ColMappingRules)
+	assert.Equal(MobileCompatibilityOff, specFile.MobileCompatible)
+	assert.Equal(24, specFile.VersionPruningWindowHrs)
+	assert.Len(warnings, 0)
+
+	specFile, warnings, err = ParseReplicationSpecFile([]byte(`{"FilterExpression":"a"}`))
+	assert.Nil(err)
+	assert.Equal("", specFile.FilterExpression)
+	assert.Equal([]string{"unknown key FilterExpression is ignored. Did you mean filterExpression?"}, warnings)
+
+	for _, invalid := range []string{
+		`[]`,
+		`{"filterDeletion":1}`,
+		`{"mobile":"Passive"}`,
+		`{"versionPruningWindowHrs":-1}`,
+		`{"colMappingRules":["S1"]}`,
+		`{"colMappingRules":{"S1":"S2"}}`,
+	} {
+		_, _, err = ParseReplicationSpecFile([]byte(invalid))
+		assert.NotNil(err, invalid)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parses a comma separated list of vbucket numbers and inclusive ranges, i.e. "0-15,100,512-1023"
+// The returned list is sorted and has no duplicates. Returns nil if the list is empty
+func ParseVbucketList(list string) ([]uint16, error) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return nil, nil
+	}
+
+	vbnoMap := make(map[uint16]bool)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var low, high uint16
+		var err error
+		if idx := strings.Index(entry, "-"); idx >= 0 {
+			low, err = parseVbno(entry[:idx])
+			if err != nil {
+				return nil, err
+			}
+			high, err = parseVbno(entry[idx+1:])
+			if err != nil {
+				return nil, err
+			}
+			if high < low {
+				return nil, fmt.Errorf("invalid vbucket range %v", entry)
+			}
+		} else {
+			low, err = parseVbno(entry)
+			if err != nil {
+				return nil, err
+			}
+			high = low
+		}
+
+		for vbno := uint32(low); vbno <= uint32(high); vbno++ {
+			vbnoMap[uint16(vbno)] = true
+		}
+	}
+
+	vbnos := make([]uint16, 0, len(vbnoMap))
+	for vbno := range vbnoMap {
+		vbnos = append(vbnos, vbno)
+	}
+	sort.Slice(vbnos, func(i, j int) bool { return vbnos[i] < vbnos[j] })
+	return vbnos, nil
+}
+
+func parseVbno(str string) (uint16, error) {
+	vbno, err := strconv.ParseUint(strings.TrimSpace(str), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid vbucket number %v", str)
+	}
+	return uint16(vbno), nil
+}
+
+// Returns the given vbuckets, or all of them if none were given
+func SelectedVbuckets(vbnos []uint16, numberOfVbuckets uint16) []uint16 {
+	if len(vbnos) > 0 {
+		return vbnos
+	}
+	allVbnos := make([]uint16, numberOfVbuckets)
+	for i := range allVbnos {
+		allVbnos[i] = uint16(i)
+	}
+	return allVbnos
+}
+
+// The reverse of ParseVbucketList. Consecutive vbuckets are collapsed into ranges
+func FormatVbucketList(vbnos []uint16) string {
+	sorted := make([]uint16, len(vbnos))
+	copy(sorted, vbnos)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var entries []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && uint32(sorted[j+1]) <= uint32(sorted[j])+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			entries = append(entries, strconv.Itoa(int(sorted[i])))
+		} else {
+			entries = append(entries, fmt.Sprintf("%v-%v", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVbucketList(t *testing.T) {
+	assert := assert.New(t)
+
+	vbnos, err := ParseVbucketList("")
+	assert.Nil(err)
+	assert.Nil(vbnos)
+
+	vbnos, err = ParseVbucketList("5, 0-2,2,1023")
+	assert.Nil(err)
+	assert.Equal([]uint16{0, 1, 2, 5, 1023}, vbnos)
+
+	vbnos, err = ParseVbucketList("0-65535")
+	assert.Nil(err)
+	assert.Len(vbnos, 65536)
+
+	_, err = ParseVbucketList("3-1")
+	assert.NotNil(err)
+
+	_, err = ParseVbucketList("abc")
+	assert.NotNil(err)
+
+	_, err = ParseVbucketList("1-")
+	assert.NotNil(err)
+}
+
+func TestSelectedVbuckets(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]uint16{0, 1, 2, 3}, SelectedVbuckets(nil, 4))
+	assert.Equal([]uint16{2}, SelectedVbuckets([]uint16{2}, 4))
+}
+
+func TestFormatVbucketList(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", FormatVbucketList(nil))
+	assert.Equal("0-2,5,1023", FormatVbucketList([]uint16{5, 1, 0, 2, 1023}))
+	assert.Equal("3", FormatVbucketList([]uint16{3, 3}))
+
+	vbnos, err := ParseVbucketList(FormatVbucketList([]uint16{7, 8, 9, 100}))
+	assert.Nil(err)
+	assert.Equal([]uint16{7, 8, 9, 100}, vbnos)
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+// The HLV of a document: its current version, and the previous and merged versions it has seen, by source
+// Every version of a source up to the one recorded has been seen
+type VersionVector struct {
+	CvSrc string
+	CvVer uint64
+	CvCas uint64
+	Pv    map[string]uint64 `json:",omitempty"`
+	Mv    map[string]uint64 `json:",omitempty"`
+}
+
+// Drops the previous versions older than the given version, as the pruning window of the bucket does
+func (v *VersionVector) Prune(before uint64) {
+	for src, ver := range v.Pv {
+		if ver < before {
+			delete(v.Pv, src)
+		}
+	}
+}
+
+// The latest version seen of each source
+func (v *VersionVector) versions() map[string]uint64 {
+	versions := make(map[string]uint64)
+	for _, entries := range []map[string]uint64{v.Pv, v.Mv, {v.CvSrc: v.CvVer}} {
+		for src, ver := range entries {
+			if ver > versions[src] {
+				versions[src] = ver
+			}
+		}
+	}
+	return versions
+}
+
+// How the versions of a document on both sides relate to one another
+type Causality int
+
+const (
+	// Either side has no HLV to compare
+	CausalityHlvMissing Causality = iota
+	// The source has seen every version the target has, and more, i.e. replication has yet to catch up
+	CausalitySourceDominates Causality = iota
+	CausalityTargetDominates Causality = iota
+	// Both sides have versions the other has not seen, i.e. a true conflict in a bidirectional setup
+	CausalityConcurrent Causality = iota
+	// Both sides have seen the same versions once pruned, so the documents differ in something other than their HLV
+	CausalityIdenticalAfterPruning Causality = iota
+)
+
+func (c Causality) String() string {
+	switch c {
+	case CausalitySourceDominates:
+		return "SourceDominates"
+	case CausalityTargetDominates:
+		return "TargetDominates"
+	case CausalityConcurrent:
+		return "Concurrent"
+	case CausalityIdenticalAfterPruning:
+		return "IdenticalAfterPruning"
+	default:
+		return "HlvMissing"
+	}
+}
+
+var Causalities = []Causality{CausalityHlvMissing, CausalitySourceDominates, CausalityTargetDominates, CausalityConcurrent, CausalityIdenticalAfterPruning}
+
+// Compares the version vectors of both sides, which are expected to be pruned already
+func CompareVersionVectors(source, target *VersionVector) Causality {
+	if source == nil || target == nil || source.CvSrc == "" || target.CvSrc == "" {
+		return CausalityHlvMissing
+	}
+
+	sourceVersions := source.versions()
+	targetVersions := target.versions()
+	var sourceAhead, targetAhead bool
+	for src, ver := range sourceVersions {
+		if ver > targetVersions[src] {
+			sourceAhead = true
+		}
+	}
+	for src, ver := range targetVersions {
+		if ver > sourceVersions[src] {
+			targetAhead = true
+		}
+	}
+
+	switch {
+	case sourceAhead && targetAhead:
+		return CausalityConcurrent
+	case sourceAhead:
+		return CausalitySourceDominates
+	case targetAhead:
+		return CausalityTargetDominates
+	default:
+		return CausalityIdenticalAfterPruning
+	}
+}
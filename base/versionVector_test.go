@@ -0,0 +1,46 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersionVectors(t *testing.T) {
+	assert := assert.New(t)
+
+	// the source was written again after the target received its last version
+	source := &VersionVector{CvSrc: "a", CvVer: 30, Pv: map[string]uint64{"a": 10}}
+	target := &VersionVector{CvSrc: "a", CvVer: 10}
+	assert.Equal(CausalitySourceDominates, CompareVersionVectors(source, target))
+	assert.Equal(CausalityTargetDominates, CompareVersionVectors(target, source))
+
+	// the target was written independently
+	target = &VersionVector{CvSrc: "b", CvVer: 20, Pv: map[string]uint64{"a": 10}}
+	assert.Equal(CausalityConcurrent, CompareVersionVectors(source, target))
+
+	// the target merged both
+	target = &VersionVector{CvSrc: "b", CvVer: 40, Mv: map[string]uint64{"a": 30, "b": 20}}
+	assert.Equal(CausalityTargetDominates, CompareVersionVectors(source, target))
+
+	// only an old previous version tells them apart
+	source = &VersionVector{CvSrc: "a", CvVer: 30, Pv: map[string]uint64{"b": 5}}
+	target = &VersionVector{CvSrc: "a", CvVer: 30}
+	assert.Equal(CausalitySourceDominates, CompareVersionVectors(source, target))
+	source.Prune(10)
+	assert.Empty(source.Pv)
+	assert.Equal(CausalityIdenticalAfterPruning, CompareVersionVectors(source, target))
+
+	assert.Equal(CausalityHlvMissing, CompareVersionVectors(source, nil))
+	assert.Equal(CausalityHlvMissing, CompareVersionVectors(&VersionVector{}, target))
+	assert.Equal("IdenticalAfterPruning", CausalityIdenticalAfterPruning.String())
+}
@@ -0,0 +1,362 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/couchbase/xdcrDiffer/base"
+	"github.com/couchbase/xdcrDiffer/dcp"
+)
+
+const checkpointCommand = "checkpoint"
+
+const (
+	checkpointActionShow     = "show"
+	checkpointActionDiff     = "diff"
+	checkpointActionReset    = "reset"
+	checkpointActionValidate = "validate"
+	checkpointActionConvert  = "convert"
+)
+
+type checkpointCommandOptions struct {
+	// source or target, i.e. which of the checkpoint files written with the cluster name prefix to work on
+	clusterName string
+}
+
+var checkpointOptions checkpointCommandOptions = checkpointCommandOptions{}
+
+func checkpointUsage() {
+	fmt.Fprintf(os.Stderr, "Usage : %s checkpoint <action> [OPTIONS] \n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Actions:\n")
+	fmt.Fprintf(os.Stderr, "  %-9s shows the seqno of each vbucket in oldCheckpointFileName, and how far behind the current high seqno it is if the cluster options are given\n", checkpointActionShow)
+	fmt.Fprintf(os.Stderr, "  %-9s shows the vbuckets that differ between oldCheckpointFileName and newCheckpointFileName\n", checkpointActionDiff)
+	fmt.Fprintf(os.Stderr, "  %-9s resets the vbuckets given in -vbuckets to seqno 0 and writes the result to newCheckpointFileName\n", checkpointActionReset)
+	fmt.Fprintf(os.Stderr, "  %-9s checks the vbuuids and seqnos in oldCheckpointFileName against the failover logs of the cluster\n", checkpointActionValidate)
+	fmt.Fprintf(os.Stderr, "  %-9s writes oldCheckpointFileName of -cluster as newCheckpointFileName of the other cluster\n", checkpointActionConvert)
+	fmt.Fprintf(os.Stderr, "newCheckpointFileName defaults to oldCheckpointFileName for reset and convert. The previous file is kept in the checkpoint history\n")
+	flag.PrintDefaults()
+}
+
+func checkpointMain(args []string) {
+	if len(args) == 0 {
+		checkpointUsage()
+		os.Exit(1)
+	}
+	action := args[0]
+
+	registerOptions()
+	flag.StringVar(&checkpointOptions.clusterName, "cluster", base.SourceClusterName,
+		"checkpoint of which cluster to work on, source or target")
+	flag.Usage = checkpointUsage
+	flag.CommandLine.Parse(args[1:])
+
+	if options.yamlConfigFilePath != "" {
+		err := UnmarshalYaml(options.yamlConfigFilePath)
+		if err != nil {
+			fmt.Printf("Error while parsing yaml: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := resolveCredentials(); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	base.SetupTimeoutSeconds = options.setupTimeout
+
+	if checkpointOptions.clusterName != base.SourceClusterName && checkpointOptions.clusterName != base.TargetClusterName {
+		fmt.Printf("Invalid cluster %v. Accepted values are %v and %v\n", checkpointOptions.clusterName, base.SourceClusterName, base.TargetClusterName)
+		os.Exit(1)
+	}
+
+	if options.oldCheckpointFileName == "" {
+		fmt.Printf("oldCheckpointFileName is required for checkpoint %v\n", action)
+		os.Exit(1)
+	}
+
+	// restricts the vbuckets to show, diff or validate, and specifies the ones to reset
+	vbnos, err := base.ParseVbucketList(options.vbuckets)
+	if err != nil {
+		fmt.Printf("Invalid vbuckets %v. err=%v\n", options.vbuckets, err)
+		os.Exit(1)
+	}
+
+	switch action {
+	case checkpointActionShow:
+		err = showCheckpoint(vbnos)
+	case checkpointActionDiff:
+		err = diffCheckpoints(vbnos)
+	case checkpointActionReset:
+		err = resetCheckpoint(vbnos)
+	case checkpointActionValidate:
+		err = validateCheckpoint(vbnos)
+	case checkpointActionConvert:
+		err = convertCheckpoint()
+	default:
+		fmt.Printf("Unknown checkpoint action %v\n", action)
+		checkpointUsage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Printf("Error running checkpoint %v. err=%v\n", action, err)
+		os.Exit(1)
+	}
+}
+
+func checkpointFileName(clusterName, fileName string) string {
+	return dcp.GetCheckpointFileName(options.checkpointFileDir, clusterName, fileName)
+}
+
+func otherClusterName(clusterName string) string {
+	if clusterName == base.SourceClusterName {
+		return base.TargetClusterName
+	}
+	return base.SourceClusterName
+}
+
+// Returns all the vbuckets in the checkpoint if none were specified
+func selectVbnos(checkpointDoc *dcp.CheckpointDoc, vbnos []uint16) []uint16 {
+	if len(vbnos) == 0 {
+		return checkpointDoc.SortedVbnos()
+	}
+	return vbnos
+}
+
+func showCheckpoint(vbnos []uint16) error {
+	fileName := checkpointFileName(checkpointOptions.clusterName, options.oldCheckpointFileName)
+	checkpointDoc, err := dcp.LoadCheckpointDoc(fileName)
+	if err != nil {
+		return err
+	}
+
+	// The high seqnos can only be shown if the cluster can be reached
+	var states map[uint16]*dcp.VbucketState
+	if options.sourceUrl != "" {
+		states, err = getVbucketStates(checkpointOptions.clusterName)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Checkpoint %v\n", fileName)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if states != nil {
+		fmt.Fprintf(writer, "vb\tvbuuid\tseqno\thighSeqno\tremaining\tpurgeSeqno\tfiltered\tfailedFilter\n")
+	} else {
+		fmt.Fprintf(writer, "vb\tvbuuid\tseqno\tpurgeSeqno\tfiltered\tfailedFilter\n")
+	}
+
+	var totalSeqno, totalHighSeqno, totalRemaining uint64
+	for _, vbno := range selectVbnos(checkpointDoc, vbnos) {
+		checkpoint, exists := checkpointDoc.Checkpoints[vbno]
+		if !exists {
+			fmt.Fprintf(writer, "%v\tmissing\n", vbno)
+			continue
+		}
+		totalSeqno += checkpoint.Seqno
+		if states == nil {
+			fmt.Fprintf(writer, "%v\t%v\t%v\t%v\t%v\t%v\n", vbno, checkpoint.Vbuuid, checkpoint.Seqno, checkpoint.PurgeSeqno, checkpoint.FilteredCnt, checkpoint.FailedFilterCnt)
+			continue
+		}
+
+		var highSeqno, remaining uint64
+		if state, found := states[vbno]; found {
+			highSeqno = state.HighSeqno
+		}
+		if highSeqno > checkpoint.Seqno {
+			remaining = highSeqno - checkpoint.Seqno
+		}
+		totalHighSeqno += highSeqno
+		totalRemaining += remaining
+		fmt.Fprintf(writer, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", vbno, checkpoint.Vbuuid, checkpoint.Seqno, highSeqno, remaining, checkpoint.PurgeSeqno, checkpoint.FilteredCnt, checkpoint.FailedFilterCnt)
+	}
+
+	if states != nil {
+		fmt.Fprintf(writer, "total\t\t%v\t%v\t%v\n", totalSeqno, totalHighSeqno, totalRemaining)
+	} else {
+		fmt.Fprintf(writer, "total\t\t%v\n", totalSeqno)
+	}
+	return writer.Flush()
+}
+
+func diffCheckpoints(vbnos []uint16) error {
+	if options.newCheckpointFileName == "" {
+		return fmt.Errorf("newCheckpointFileName is required to diff against")
+	}
+
+	oldFileName := checkpointFileName(checkpointOptions.clusterName, options.oldCheckpointFileName)
+	oldDoc, err := dcp.LoadCheckpointDoc(oldFileName)
+	if err != nil {
+		return err
+	}
+	newFileName := checkpointFileName(checkpointOptions.clusterName, options.newCheckpointFileName)
+	newDoc, err := dcp.LoadCheckpointDoc(newFileName)
+	if err != nil {
+		return err
+	}
+
+	selected := make(map[uint16]bool)
+	for _, vbno := range vbnos {
+		selected[vbno] = true
+	}
+
+	fmt.Printf("Differences between %v and %v\n", oldFileName, newFileName)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(writer, "vb\toldVbuuid\toldSeqno\tnewVbuuid\tnewSeqno\tseqnoChange\n")
+	var count int
+	for _, delta := range dcp.DiffCheckpointDocs(oldDoc, newDoc) {
+		if len(selected) > 0 && !selected[delta.Vbno] {
+			continue
+		}
+		count++
+		oldVbuuid, oldSeqno := "missing", "missing"
+		newVbuuid, newSeqno := "missing", "missing"
+		var change int64
+		if delta.Old != nil {
+			oldVbuuid, oldSeqno = fmt.Sprintf("%v", delta.Old.Vbuuid), fmt.Sprintf("%v", delta.Old.Seqno)
+			change -= int64(delta.Old.Seqno)
+		}
+		if delta.New != nil {
+			newVbuuid, newSeqno = fmt.Sprintf("%v", delta.New.Vbuuid), fmt.Sprintf("%v", delta.New.Seqno)
+			change += int64(delta.New.Seqno)
+		}
+		fmt.Fprintf(writer, "%v\t%v\t%v\t%v\t%v\t%+d\n", delta.Vbno, oldVbuuid, oldSeqno, newVbuuid, newSeqno, change)
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v vbuckets differ\n", count)
+	return nil
+}
+
+func resetCheckpoint(vbnos []uint16) error {
+	if len(vbnos) == 0 {
+		return fmt.Errorf("vbuckets to reset need to be specified")
+	}
+
+	oldFileName := checkpointFileName(checkpointOptions.clusterName, options.oldCheckpointFileName)
+	checkpointDoc, err := dcp.LoadCheckpointDoc(oldFileName)
+	if err != nil {
+		return err
+	}
+
+	err = checkpointDoc.ResetVbuckets(vbnos)
+	if err != nil {
+		return err
+	}
+
+	newFileName := oldFileName
+	if options.newCheckpointFileName != "" {
+		newFileName = checkpointFileName(checkpointOptions.clusterName, options.newCheckpointFileName)
+	}
+	err = dcp.SaveCheckpointDoc(newFileName, checkpointDoc, int(options.checkpointHistory))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Reset %v vbuckets %v to seqno 0 and saved to %v\n", len(vbnos), vbnos, newFileName)
+	return nil
+}
+
+func validateCheckpoint(vbnos []uint16) error {
+	fileName := checkpointFileName(checkpointOptions.clusterName, options.oldCheckpointFileName)
+	checkpointDoc, err := dcp.LoadCheckpointDoc(fileName)
+	if err != nil {
+		return err
+	}
+
+	states, err := getVbucketStates(checkpointOptions.clusterName)
+	if err != nil {
+		return err
+	}
+
+	var invalidVbnos []uint16
+	selectedVbnos := selectVbnos(checkpointDoc, vbnos)
+	for _, vbno := range selectedVbnos {
+		checkpoint, exists := checkpointDoc.Checkpoints[vbno]
+		if !exists {
+			fmt.Printf("vb %v: missing from checkpoint\n", vbno)
+			invalidVbnos = append(invalidVbnos, vbno)
+			continue
+		}
+		err = dcp.ValidateCheckpoint(checkpoint, states[vbno])
+		if err != nil {
+			fmt.Printf("vb %v: %v\n", vbno, err)
+			invalidVbnos = append(invalidVbnos, vbno)
+		}
+	}
+
+	if len(invalidVbnos) > 0 {
+		return fmt.Errorf("%v of %v vbuckets in %v cannot be resumed and would be rolled back: %v", len(invalidVbnos),
+			len(selectedVbnos), fileName, invalidVbnos)
+	}
+	fmt.Printf("All %v vbuckets in %v can be resumed\n", len(selectedVbnos), fileName)
+	return nil
+}
+
+func convertCheckpoint() error {
+	oldFileName := checkpointFileName(checkpointOptions.clusterName, options.oldCheckpointFileName)
+	checkpointDoc, err := dcp.LoadCheckpointDoc(oldFileName)
+	if err != nil {
+		return err
+	}
+
+	// The recorded file sizes are of the files of the cluster the checkpoint was taken on
+	checkpointDoc.FileSizes = nil
+
+	newName := options.newCheckpointFileName
+	if newName == "" {
+		newName = options.oldCheckpointFileName
+	}
+	newFileName := checkpointFileName(otherClusterName(checkpointOptions.clusterName), newName)
+	err = dcp.SaveCheckpointDoc(newFileName, checkpointDoc, int(options.checkpointHistory))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Converted %v to %v. The vbuuids are still those of the %v cluster, run checkpoint %v to check them against the %v cluster\n",
+		oldFileName, newFileName, checkpointOptions.clusterName, checkpointActionValidate, otherClusterName(checkpointOptions.clusterName))
+	return nil
+}
+
+func getVbucketStates(clusterName string) (map[uint16]*dcp.VbucketState, error) {
+	legacyMode := len(options.targetUsername) > 0
+	difftool, err := NewDiffTool(legacyMode)
+	if err != nil {
+		return nil, err
+	}
+
+	return difftool.newInspectionDcpDriver(clusterName).GetVbucketStates()
+}
+
+// The returned dcp driver is only used to reach the cluster and is never started
+func (difftool *xdcrDiffTool) newInspectionDcpDriver(clusterName string) *dcp.DcpDriver {
+	url, bucketName, ref, fileDir := options.sourceUrl, difftool.specifiedSpec.SourceBucketName, difftool.selfRef, options.sourceFileDir
+	capabilities, numberOfVbuckets := difftool.srcCapabilities, difftool.vbInfo.sourceNoOfVbuckets
+	if clusterName == base.TargetClusterName {
+		url, bucketName, ref, fileDir = difftool.specifiedRef.HostName_, difftool.specifiedSpec.TargetBucketName, difftool.specifiedRef, options.targetFileDir
+		capabilities, numberOfVbuckets = difftool.tgtCapabilities, difftool.vbInfo.targetNoOfVbuckets
+	}
+
+	return dcp.NewDcpDriver(difftool.logger, clusterName, url, bucketName, ref, fileDir, options.checkpointFileDir, "", "",
+		1, 1, int(options.numberOfBins), int(options.sourceDcpHandlerChanSize), time.Duration(options.bucketOpTimeout)*time.Second,
+		int(options.maxNumOfGetStatsRetry), time.Duration(options.getStatsRetryInterval)*time.Second,
+		time.Duration(options.getStatsMaxBackoff)*time.Second, int(options.checkpointInterval), make(chan error, 1), &sync.WaitGroup{},
+		true, nil, nil, capabilities, nil, nil, difftool.utils, options.bucketBufferCapacity, nil, 0, 0, nil,
+		numberOfVbuckets, difftool.vbInfo.isVariableVB, nil, nil, int(options.checkpointHistory), nil, 0, true, base.HashAlgorithmXxh3)
+}
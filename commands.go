@@ -0,0 +1,489 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/couchbase/xdcrDiffer/base"
+	"github.com/couchbase/xdcrDiffer/utils"
+	"golang.org/x/term"
+)
+
+const (
+	runCommand      = "run"
+	captureCommand  = "capture"
+	fileDiffCommand = "filediff"
+	verifyCommand   = "verify"
+	reportCommand   = "report"
+)
+
+const defaultOutputFileDir = "outputs"
+const defaultLogFileName = "xdcrDiffer.log"
+const logFileConsoleOnly = "-"
+
+// Set for the differ process started by a command, which has already prepared the outputs
+const commandSubprocessEnv = "XDCRDIFFER_SUBPROCESS"
+
+// The passwords are handed to the differ process on this file descriptor, the first of cmd.ExtraFiles
+const subprocessCredentialsFile = "/dev/fd/3"
+
+// When installed with Couchbase Server, the outputs must not be written into the installation
+const couchbaseInstallDir = "/opt/couchbase"
+
+type commandOptions struct {
+	// directory holding all the outputs. The output directories that are not given are placed under it
+	outputFileDir string
+	// removes the outputs of a previous run before starting
+	clearBeforeRun bool
+	// file that the output is written to on top of the console
+	logFile string
+}
+
+var cmdOptions commandOptions = commandOptions{}
+
+// The flags of each command, by the stage they apply to
+var (
+	connectionFlags = []string{"sourceUrl", "sourceUsername", "sourcePassword", "sourceBucketName", "remoteClusterName",
+		"targetUrl", "targetUsername", "targetPassword", "targetBucketName", "credentialsFile", "passwordPrompt",
+		"sourceClientCertFile", "sourceClientKeyFile", "targetClientCertFile", "targetClientKeyFile", "replicationSpecFile",
+		"enforceTLS", "setupTimeout", "debugMode", "yamlConfigFilePath"}
+	outputFlags = []string{"outputFileDir", "logFile", "sourceFileDir", "targetFileDir", "checkpointFileDir",
+		"fileDifferDir", "mutationDifferDir", "preflightDir"}
+	selectionFlags = []string{"collectionsToInclude", "collectionsToExclude", "keyPrefix", "keyRegex", "keysFile",
+		"diffWindowStart", "diffWindowEnd", "vbuckets", "fileContaingXattrKeysForNoComapre", "compareType", "mobileMode", "numberOfBins"}
+	captureFlags = []string{"clearBeforeRun", "numberOfSourceDcpClients", "numberOfWorkersPerSourceDcpClient",
+		"numberOfTargetDcpClients", "numberOfWorkersPerTargetDcpClient", "completeByDuration", "completeBySeqno",
+		"oldCheckpointFileName", "newCheckpointFileName", "checkpointInterval", "checkpointHistory",
+		"sourceDcpHandlerChanSize", "targetDcpHandlerChanSize", "bucketOpTimeout", "maxNumOfGetStatsRetry",
+		"getStatsRetryInterval", "getStatsMaxBackoff", "delayBetweenSourceAndTarget", "bucketBufferCapacity",
+		"numOfFiltersInFilterPool", "memoryBudgetMB", "hashAlgorithm", "preflightCheck", "preflightOnly", "preflightRestrict"}
+	fileDiffFlags = []string{"numberOfWorkersForFileDiffer", "numberOfFileDesc", "tombstonePolicy", "expiryGraceSecs"}
+	verifyFlags   = []string{"numberOfWorkersForMutationDiffer", "mutationDifferBatchSize", "mutationDifferTimeout",
+		"maxNumOfSendBatchRetry", "sendBatchRetryInterval", "sendBatchMaxBackoff", "mutationRetries",
+		"mutationRetriesWaitSecs", "verifyKeysFile"}
+	liveFlags   = []string{"liveMode", "liveSettleSecs", "liveWindowSecs", "liveWindowsToKeep", "liveMetricsPort", "liveMetricsHost", "liveMaxPendingKeys"}
+	reportFlags = []string{"yamlConfigFilePath", "outputFileDir", "fileDifferDir", "mutationDifferDir", "preflightDir"}
+)
+
+type command struct {
+	name        string
+	description string
+	flagGroups  [][]string
+	// Chooses the stages to run and checks the options that only apply to the command. nil if the command does not run the differ
+	setup func() error
+	// Used in place of the differ
+	run func() error
+}
+
+var commands = map[string]*command{
+	runCommand: {
+		name:        runCommand,
+		description: "streams both buckets, diffs the captured mutations and verifies the differences, like runDiffer.sh",
+		flagGroups:  [][]string{connectionFlags, outputFlags, selectionFlags, captureFlags, fileDiffFlags, verifyFlags, liveFlags},
+		setup:       func() error { return nil },
+	},
+	captureCommand: {
+		name:        captureCommand,
+		description: "only streams both buckets into sourceFileDir and targetFileDir",
+		flagGroups:  [][]string{connectionFlags, outputFlags, selectionFlags, captureFlags},
+		setup: func() error {
+			options.runFileDiffer = false
+			options.runMutationDiffer = false
+			return nil
+		},
+	},
+	fileDiffCommand: {
+		name:        fileDiffCommand,
+		description: "only diffs the mutations captured by a previous capture or run",
+		flagGroups:  [][]string{connectionFlags, outputFlags, selectionFlags, fileDiffFlags},
+		setup: func() error {
+			options.runDataGeneration = false
+			options.runMutationDiffer = false
+			for _, dir := range []string{options.sourceFileDir, options.targetFileDir} {
+				if _, err := os.Stat(dir); err != nil {
+					return fmt.Errorf("no captured mutations in %v, run %v first: %v", dir, captureCommand, err)
+				}
+			}
+			return nil
+		},
+	},
+	verifyCommand: {
+		name:        verifyCommand,
+		description: "only fetches the documents found to differ by a previous filediff or run, or the ones in keysFile or verifyKeysFile, to verify them",
+		flagGroups:  [][]string{connectionFlags, outputFlags, selectionFlags, verifyFlags},
+		setup: func() error {
+			options.runDataGeneration = false
+			options.runFileDiffer = false
+			if options.verifyKeysFile != "" || options.keysFile != "" {
+				return nil
+			}
+			for _, isSource := range []bool{true, false} {
+				fileName := utils.DiffKeysFileName(isSource, options.fileDifferDir, base.DiffKeysFileName)
+				if _, err := os.Stat(fileName); err != nil {
+					return fmt.Errorf("no differences to verify in %v, run %v first or give keysFile or verifyKeysFile: %v", fileName, fileDiffCommand, err)
+				}
+			}
+			return nil
+		},
+	},
+	reportCommand: {
+		name:        reportCommand,
+		description: "summarizes the outputs of a previous run, without contacting the clusters",
+		flagGroups:  [][]string{reportFlags},
+		run:         printReport,
+	},
+}
+
+func registerCommandOptions() {
+	flag.StringVar(&cmdOptions.outputFileDir, "outputFileDir", defaultOutputFileDir,
+		"Directory holding all the outputs. The output directories that are not given are placed under it")
+	flag.BoolVar(&cmdOptions.clearBeforeRun, "clearBeforeRun", false,
+		"Remove the outputs of a previous run before starting. Leave it off to resume from a checkpoint")
+	flag.StringVar(&cmdOptions.logFile, "logFile", "",
+		"File that the output is also written to. Defaults to "+defaultLogFileName+" under outputFileDir, - for the console only")
+
+	// the yaml config file can set these too
+	buildConfigSchema()
+}
+
+func (c *command) flags() map[string]bool {
+	flags := make(map[string]bool)
+	for _, group := range c.flagGroups {
+		for _, name := range group {
+			flags[name] = true
+		}
+	}
+	return flags
+}
+
+func commandsUsage() {
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %v\n", name, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "  %-10s inspects and edits checkpoint files\n", checkpointCommand)
+	fmt.Fprintf(os.Stderr, "  %-10s prints the JSON schema of the yaml config file\n", configSchemaCommand)
+	fmt.Fprintf(os.Stderr, "Run %s <command> -h for the options of each command\n", os.Args[0])
+}
+
+func (c *command) usage() {
+	fmt.Fprintf(os.Stderr, "Usage : %s %s [OPTIONS] \n", os.Args[0], c.name)
+	fmt.Fprintf(os.Stderr, "The %v command %v\n", c.name, c.description)
+
+	// only the flags of the command are listed
+	flags := c.flags()
+	commandFlags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	commandFlags.SetOutput(os.Stderr)
+	flag.VisitAll(func(f *flag.Flag) {
+		if flags[f.Name] {
+			commandFlags.Var(f.Value, f.Name, f.Usage)
+			commandFlags.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	commandFlags.PrintDefaults()
+}
+
+func commandMain(c *command, args []string) {
+	registerOptions()
+	registerCommandOptions()
+	flag.Usage = c.usage
+	flag.CommandLine.Parse(args)
+
+	if flag.NArg() > 0 {
+		fmt.Printf("Unexpected arguments %v\n", flag.Args())
+		c.usage()
+		os.Exit(1)
+	}
+	// The yaml config file is shared by all the commands, so only the command line is checked
+	flags := c.flags()
+	var unknownFlags []string
+	flag.Visit(func(f *flag.Flag) {
+		if !flags[f.Name] {
+			unknownFlags = append(unknownFlags, "-"+f.Name)
+		}
+	})
+	if len(unknownFlags) > 0 {
+		fmt.Printf("%v does not take %v. See %s %v -h\n", c.name, strings.Join(unknownFlags, ", "), os.Args[0], c.name)
+		os.Exit(1)
+	}
+
+	if c.setup == nil {
+		if options.yamlConfigFilePath != "" {
+			if err := UnmarshalYaml(options.yamlConfigFilePath); err != nil {
+				fmt.Printf("Error while parsing yaml: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		setOutputDirs()
+		if err := c.run(); err != nil {
+			fmt.Printf("Error running %v. err=%v\n", c.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	loadOptions()
+	setOutputDirs()
+	setNumberOfFileDesc()
+	if err := c.setup(); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	if os.Getenv(commandSubprocessEnv) == "" {
+		if err := prepareOutputs(c); err != nil {
+			fmt.Printf("Unable to prepare outputs: %v\n", err)
+			os.Exit(1)
+		}
+		if cmdOptions.logFile != logFileConsoleOnly {
+			os.Exit(runSubprocess(c, args))
+		}
+	}
+	runDiffTool()
+}
+
+// Places the output directories that are not given, on the command line or in the yaml config file, under outputFileDir
+func setOutputDirs() {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for name, dir := range map[string]*string{
+		"sourceFileDir":     &options.sourceFileDir,
+		"targetFileDir":     &options.targetFileDir,
+		"checkpointFileDir": &options.checkpointFileDir,
+		"fileDifferDir":     &options.fileDifferDir,
+		"mutationDifferDir": &options.mutationDifferDir,
+		"preflightDir":      &options.preflightDir,
+	} {
+		if !given[name] {
+			*dir = filepath.Join(cmdOptions.outputFileDir, *dir)
+		}
+	}
+	if cmdOptions.logFile == "" {
+		cmdOptions.logFile = filepath.Join(cmdOptions.outputFileDir, defaultLogFileName)
+	}
+}
+
+// Uses 3/4 of the file descriptors that the process is allowed to open, unless given
+func setNumberOfFileDesc() {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		given = given || f.Name == "numberOfFileDesc"
+	})
+	var limit syscall.Rlimit
+	if given || syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit) != nil || limit.Cur <= 4 {
+		return
+	}
+	options.numberOfFileDesc = uint64(limit.Cur) / 4 * 3
+}
+
+func prepareOutputs(c *command) error {
+	if executable, err := os.Executable(); err == nil && strings.HasPrefix(executable, couchbaseInstallDir) {
+		outputFileDir, err := filepath.Abs(cmdOptions.outputFileDir)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(outputFileDir, couchbaseInstallDir) {
+			return fmt.Errorf("outputFileDir %v should not be under %v", outputFileDir, couchbaseInstallDir)
+		}
+	}
+
+	if cmdOptions.clearBeforeRun {
+		fmt.Printf("Cleaning up before run...\n")
+		for _, output := range []string{options.sourceFileDir, options.targetFileDir, options.checkpointFileDir,
+			options.fileDifferDir, options.mutationDifferDir, options.preflightDir, cmdOptions.logFile} {
+			if output == logFileConsoleOnly {
+				continue
+			}
+			if err := os.RemoveAll(output); err != nil {
+				return err
+			}
+		}
+	}
+	return os.MkdirAll(cmdOptions.outputFileDir, 0777)
+}
+
+// Runs the differ in a process of its own, which is where its output is written to the log file from
+// Returns the exit code of the differ
+func runSubprocess(c *command, args []string) int {
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Printf("Unable to find the differ executable: %v\n", err)
+		return 1
+	}
+	// The passwords have been resolved already, possibly by prompting for them, and are handed over on a pipe
+	// so that they show up in neither the process list nor the environment
+	args = append(append([]string{c.name}, args...), "-credentialsFile", subprocessCredentialsFile, "-passwordPrompt=false")
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(), commandSubprocessEnv+"=1")
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if cmdOptions.logFile != logFileConsoleOnly {
+		logFile, err := os.OpenFile(cmdOptions.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Printf("Unable to open log file %v: %v\n", cmdOptions.logFile, err)
+			return 1
+		}
+		defer logFile.Close()
+		cmd.Stdout, cmd.Stderr = io.MultiWriter(os.Stdout, logFile), io.MultiWriter(os.Stderr, logFile)
+	}
+
+	credentialsReader, credentialsWriter, err := os.Pipe()
+	if err != nil {
+		fmt.Printf("Unable to create pipe: %v\n", err)
+		return 1
+	}
+	cmd.ExtraFiles = []*os.File{credentialsReader}
+	// The differ reads the terminal when the keys to verify are given on stdin, which it can only do from the
+	// foreground process group. It then gets the interrupts from the terminal itself, and they are not forwarded
+	// from here, as it would otherwise get them twice. Otherwise it is moved to a process group of its own, and the
+	// interrupts are forwarded so that they also reach it when only this process is signalled
+	forwardInterrupts := !term.IsTerminal(int(os.Stdin.Fd()))
+	if forwardInterrupts {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	if err = cmd.Start(); err != nil {
+		fmt.Printf("Unable to start the differ: %v\n", err)
+		return 1
+	}
+	credentialsReader.Close()
+	for key, value := range map[string]string{
+		"sourceUsername": options.sourceUsername,
+		"sourcePassword": options.sourcePassword,
+		"targetUsername": options.targetUsername,
+		"targetPassword": options.targetPassword,
+	} {
+		if value != "" {
+			fmt.Fprintf(credentialsWriter, "%v=%v\n", key, value)
+		}
+	}
+	credentialsWriter.Close()
+
+	go func() {
+		for interrupt := range interrupts {
+			if forwardInterrupts {
+				cmd.Process.Signal(interrupt)
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		fmt.Printf("Error running the differ: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printReport() error {
+	summary, err := base.LoadOutputSummary(options.fileDifferDir, options.mutationDifferDir, options.preflightDir)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if summary.Preflight != nil {
+		fmt.Fprintf(writer, "Preflight check (%v)\n", options.preflightDir)
+		fmt.Fprintf(writer, "  source items\t%v\n", summary.Preflight.SourceItems)
+		fmt.Fprintf(writer, "  target items\t%v\n", summary.Preflight.TargetItems)
+		if summary.Preflight.SuspiciousVbuckets != "" {
+			fmt.Fprintf(writer, "  suspicious vbuckets\t%v\n", summary.Preflight.SuspiciousVbuckets)
+		}
+	}
+
+	fmt.Fprintf(writer, "File differ (%v)\n", options.fileDifferDir)
+	if summary.FileDiffer == nil {
+		fmt.Fprintf(writer, "  not run\n")
+	}
+	for _, category := range sortedKeys(summary.FileDiffer) {
+		fmt.Fprintf(writer, "  %v\t%v\n", category, summary.FileDiffer[category])
+	}
+	if summary.TtlDrift != nil {
+		fmt.Fprintf(writer, "  TtlDrift\t%v\n", *summary.TtlDrift)
+	}
+	if summary.Migration != nil {
+		fmt.Fprintf(writer, "  source documents passing a migration filter\t%v\n", summary.Migration.SourceMatched)
+		fmt.Fprintf(writer, "    passing more than one\t%v\n", summary.Migration.SourceMultipleMatched)
+		fmt.Fprintf(writer, "  source documents passing no migration filter\t%v\n", summary.Migration.SourceUnmatched)
+		for _, filter := range summary.Migration.Filters {
+			fmt.Fprintf(writer, "    filter %v -> collection %v\t%v source, %v target\n", filter.Index, filter.TargetColId, filter.SourceMatched, filter.TargetItems)
+		}
+	}
+
+	fmt.Fprintf(writer, "Mutation differ (%v)\n", options.mutationDifferDir)
+	if summary.MutationDiffer == nil {
+		fmt.Fprintf(writer, "  not run\n")
+	}
+	for _, category := range sortedKeys(summary.MutationDiffer) {
+		var total int
+		colIds := sortedKeys(summary.MutationDiffer[category])
+		for _, colId := range colIds {
+			total += summary.MutationDiffer[category][colId]
+		}
+		fmt.Fprintf(writer, "  %v\t%v\n", category, total)
+		// the default collection only is not worth breaking down
+		if len(colIds) > 1 || len(colIds) == 1 && colIds[0] != "0" {
+			for _, colId := range colIds {
+				fmt.Fprintf(writer, "    collection %v\t%v\n", colId, summary.MutationDiffer[category][colId])
+			}
+		}
+	}
+	if summary.KeysWithError != nil {
+		fmt.Fprintf(writer, "  KeysWithError\t%v\n", *summary.KeysWithError)
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+
+	// explains the collection IDs above
+	plan, err := base.LoadMappingPlan(options.mutationDifferDir + base.FileDirDelimiter + base.MappingPlanFileName)
+	if os.IsNotExist(err) {
+		plan, err = base.LoadMappingPlan(options.fileDifferDir + base.FileDirDelimiter + base.MappingPlanFileName)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return plan.WriteTable(os.Stdout)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
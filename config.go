@@ -0,0 +1,141 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/couchbase/xdcrDiffer/base"
+)
+
+const configSchemaCommand = "configSchema"
+
+// Keys of the yaml config file that are named differently from their command line flags
+var configKeyToFlag = map[string]string{
+	"mutationDifferRetries":         "mutationRetries",
+	"mutationDifferRetriesWaitSecs": "mutationRetriesWaitSecs",
+}
+
+// Keys of the yaml config file that have no command line flag when the differ is run without a command
+var configOnlyKeys = base.ConfigSchema{
+	"outputFileDir": {Type: base.ConfigString,
+		Description: "Directory holding all the outputs. Other keys can refer to it as ${outputFileDir}"},
+	"clearBeforeRun": {Type: base.ConfigBool,
+		Description: "Used by the run and capture commands. Whether to clear the existing outputs before running the tool. Set to false when resuming from a previous run"},
+	"logFile": {Type: base.ConfigString,
+		Description: "Used by the commands. File that the output is also written to"},
+}
+
+// Command line flags that cannot be set from the yaml config file
+var configExcludedFlags = map[string]bool{
+	"yamlConfigFilePath": true,
+}
+
+// Keys that can also be read from a file or an environment variable, instead of being kept in plain text
+var configSecretKeys = map[string]bool{
+	"sourcePassword": true,
+	"targetPassword": true,
+}
+
+// Built from the command line flags by registerOptions, so that every option can be set in the yaml config file
+var configSchema base.ConfigSchema
+
+func buildConfigSchema() {
+	flagToConfigKey := make(map[string]string)
+	for key, flagName := range configKeyToFlag {
+		flagToConfigKey[flagName] = key
+	}
+
+	configSchema = make(base.ConfigSchema)
+	for key, configKey := range configOnlyKeys {
+		configSchema[key] = configKey
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if configExcludedFlags[f.Name] {
+			return
+		}
+		key := f.Name
+		if configKeyName, ok := flagToConfigKey[f.Name]; ok {
+			key = configKeyName
+		}
+
+		var valueType base.ConfigValueType
+		switch f.Value.(flag.Getter).Get().(type) {
+		case bool:
+			valueType = base.ConfigBool
+		case int:
+			valueType = base.ConfigInt
+		case uint64:
+			valueType = base.ConfigUint
+		default:
+			valueType = base.ConfigString
+		}
+		configSchema[key] = &base.ConfigKey{
+			Type:        valueType,
+			Description: strings.TrimSpace(f.Usage),
+			Default:     f.DefValue,
+			Secret:      configSecretKeys[key],
+		}
+	})
+}
+
+func configFlagName(key string) string {
+	if flagName, ok := configKeyToFlag[key]; ok {
+		return flagName
+	}
+	return key
+}
+
+// Sets the options from the yaml config file
+// Flags given explicitly on the command line take precedence over the config file,
+// which in turn takes precedence over the flag defaults
+func UnmarshalYaml(path string) error {
+	values, warnings, err := base.LoadConfig(path, configSchema)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", warning)
+	}
+
+	setOnCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	for key, value := range values {
+		if _, ok := configOnlyKeys[key]; ok && flag.Lookup(key) == nil {
+			continue
+		}
+		flagName := configFlagName(key)
+		if setOnCommandLine[flagName] {
+			fmt.Fprintf(os.Stderr, "%v:%d: %v is overridden by the command line\n", path, value.Line, key)
+			continue
+		}
+		if err := flag.Set(flagName, value.Value); err != nil {
+			return fmt.Errorf("%v:%d: %v", path, value.Line, err)
+		}
+	}
+	return nil
+}
+
+func configSchemaMain() {
+	registerOptions()
+	registerCommandOptions()
+	bytes, err := configSchema.JSONSchema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to generate config schema: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(bytes))
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/couchbase/xdcrDiffer/base"
+	"golang.org/x/term"
+)
+
+// Fills in the credentials that are not given on the command line or in the yaml config file,
+// first from the credentials file and then, if asked to, by prompting for the passwords
+func resolveCredentials() error {
+	if options.credentialsFile != "" {
+		if err := loadCredentialsFile(options.credentialsFile); err != nil {
+			return fmt.Errorf("unable to load credentials: %v", err)
+		}
+	}
+
+	if options.passwordPrompt {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("passwordPrompt requires stdin to be a terminal")
+		}
+		if options.sourceUsername != "" && options.sourcePassword == "" && options.sourceClientCertFile == "" {
+			if err := promptPassword(base.SourceClusterName, options.sourceUsername, &options.sourcePassword); err != nil {
+				return err
+			}
+		}
+		if options.targetUsername != "" && options.targetPassword == "" {
+			if err := promptPassword(base.TargetClusterName, options.targetUsername, &options.targetPassword); err != nil {
+				return err
+			}
+		}
+	}
+
+	if (options.sourceClientCertFile == "") != (options.sourceClientKeyFile == "") {
+		return fmt.Errorf("sourceClientCertFile and sourceClientKeyFile need to be given together")
+	}
+	if (options.targetClientCertFile == "") != (options.targetClientKeyFile == "") {
+		return fmt.Errorf("targetClientCertFile and targetClientKeyFile need to be given together")
+	}
+	if options.targetClientCertFile != "" && options.targetUsername != "" {
+		// a target given by username is contacted without TLS, which client certificates require
+		return fmt.Errorf("targetClientCertFile is used with the remote cluster reference given by remoteClusterName, and cannot be combined with targetUsername")
+	}
+	return nil
+}
+
+func loadCredentialsFile(path string) error {
+	if path == base.CredentialsStdin {
+		if options.verifyKeysFile == base.KeyListStdin {
+			return fmt.Errorf("credentialsFile and verifyKeysFile cannot both be read from stdin")
+		}
+		if options.keysFile == base.KeyListStdin {
+			return fmt.Errorf("credentialsFile and keysFile cannot both be read from stdin")
+		}
+	}
+	if path != base.CredentialsStdin {
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+			fmt.Fprintf(os.Stderr, "Warning: credentials file %v is accessible by other users (%v)\n", path, info.Mode().Perm())
+		}
+	}
+
+	credentials, err := base.LoadCredentials(path)
+	if err != nil {
+		return err
+	}
+	for key, option := range map[string]*string{
+		"sourceUsername": &options.sourceUsername,
+		"sourcePassword": &options.sourcePassword,
+		"targetUsername": &options.targetUsername,
+		"targetPassword": &options.targetPassword,
+	} {
+		if value, ok := credentials[key]; ok && *option == "" {
+			*option = value
+		}
+	}
+	return nil
+}
+
+func promptPassword(clusterName, username string, password *string) error {
+	fmt.Fprintf(os.Stderr, "Password for %v cluster user %v: ", clusterName, username)
+	bytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("unable to read the %v password: %v", clusterName, err)
+	}
+	*password = string(bytes)
+	return nil
+}
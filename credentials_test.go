@@ -0,0 +1,30 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/couchbase/xdcrDiffer/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCredentialsFileStdinConflict(t *testing.T) {
+	assert := assert.New(t)
+	defer func(saved inputOptions) { options = saved }(options)
+
+	// stdin is only read once, so the key lists cannot come from it as well
+	options.verifyKeysFile = base.KeyListStdin
+	assert.NotNil(loadCredentialsFile(base.CredentialsStdin))
+
+	options.verifyKeysFile = ""
+	options.keysFile = base.KeyListStdin
+	assert.NotNil(loadCredentialsFile(base.CredentialsStdin))
+}
@@ -1,5 +1,12 @@
 package dcp
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
 type Checkpoint struct {
 	Vbuuid             uint64
 	Seqno              uint64
@@ -7,6 +14,9 @@ type Checkpoint struct {
 	SnapshotEndSeqno   uint64
 	FilteredCnt        uint64
 	FailedFilterCnt    uint64
+	// Tombstones up to this seqno had been purged when the checkpoint was taken
+	// Checkpoints written by older versions do not have this
+	PurgeSeqno uint64 `json:",omitempty"`
 }
 
 // vbucket timestamp required by dcp
@@ -19,4 +29,44 @@ type VBTS struct {
 
 type CheckpointDoc struct {
 	Checkpoints map[uint16]*Checkpoint
+	// Size of each bucket file once all the mutations up to the checkpointed seqnos have been flushed
+	// Checkpoints written by older versions do not have this
+	FileSizes map[string]int64
+	// SHA-256 of the document with this field left empty
+	// Checkpoints written by older versions do not have this
+	Checksum string
+}
+
+func (doc *CheckpointDoc) computeChecksum() (string, error) {
+	docCopy := *doc
+	docCopy.Checksum = ""
+	value, err := json.Marshal(&docCopy)
+	if err != nil {
+		return "", err
+	}
+	checksum := sha256.Sum256(value)
+	return hex.EncodeToString(checksum[:]), nil
+}
+
+func (doc *CheckpointDoc) SetChecksum() error {
+	checksum, err := doc.computeChecksum()
+	if err != nil {
+		return err
+	}
+	doc.Checksum = checksum
+	return nil
+}
+
+func (doc *CheckpointDoc) VerifyChecksum() error {
+	if doc.Checksum == "" {
+		return nil
+	}
+	checksum, err := doc.computeChecksum()
+	if err != nil {
+		return err
+	}
+	if checksum != doc.Checksum {
+		return fmt.Errorf("checksum mismatch. expected=%v, actual=%v", doc.Checksum, checksum)
+	}
+	return nil
 }
@@ -0,0 +1,292 @@
+package dcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocbcore/v10"
+	"github.com/couchbase/xdcrDiffer/base"
+	"github.com/couchbase/xdcrDiffer/utils"
+)
+
+// State of a vbucket on the cluster, used to check checkpoints against
+type VbucketState struct {
+	Vbuuid    uint64
+	HighSeqno uint64
+	// newest entry first
+	FailoverLog []gocbcore.FailoverEntry
+}
+
+type CheckpointDelta struct {
+	Vbno uint16
+	// nil if the vbucket is missing from the respective checkpoint
+	Old *Checkpoint
+	New *Checkpoint
+}
+
+func GetCheckpointFileName(checkpointFileDir, clusterName, fileName string) string {
+	return checkpointFileDir + base.FileDirDelimiter + clusterName + base.FileNameDelimiter + fileName
+}
+
+// Loads a checkpoint file and verifies its checksum
+func LoadCheckpointDoc(fileName string) (*CheckpointDoc, error) {
+	checkpointFileBytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpointDoc := &CheckpointDoc{}
+	err = json.Unmarshal(checkpointFileBytes, checkpointDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkpointDoc.VerifyChecksum()
+	if err != nil {
+		return nil, err
+	}
+	return checkpointDoc, nil
+}
+
+// Recomputes the checksum and atomically replaces the checkpoint file, keeping the given number of previous ones
+func SaveCheckpointDoc(fileName string, checkpointDoc *CheckpointDoc, history int) error {
+	err := checkpointDoc.SetChecksum()
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(checkpointDoc)
+	if err != nil {
+		return err
+	}
+
+	return writeCheckpointFile(fileName, value, history)
+}
+
+func (doc *CheckpointDoc) SortedVbnos() []uint16 {
+	vbnos := make([]uint16, 0, len(doc.Checkpoints))
+	for vbno := range doc.Checkpoints {
+		vbnos = append(vbnos, vbno)
+	}
+	sort.Slice(vbnos, func(i, j int) bool { return vbnos[i] < vbnos[j] })
+	return vbnos
+}
+
+// Makes the given vbuckets stream again from seqno 0 when the checkpoint is resumed from
+// The files of these vbuckets are recorded as empty so that what was streamed before is discarded on resume
+func (doc *CheckpointDoc) ResetVbuckets(vbnos []uint16) error {
+	resetMap := make(map[uint16]bool)
+	for _, vbno := range vbnos {
+		if _, exists := doc.Checkpoints[vbno]; !exists {
+			return fmt.Errorf("vbucket %v does not exist in checkpoint", vbno)
+		}
+		resetMap[vbno] = true
+	}
+
+	for vbno := range resetMap {
+		doc.Checkpoints[vbno] = &Checkpoint{}
+	}
+
+	for fileName := range doc.FileSizes {
+		vbno, err := getVbnoFromFileName(fileName)
+		if err != nil {
+			return err
+		}
+		if resetMap[vbno] {
+			doc.FileSizes[fileName] = 0
+		}
+	}
+	return nil
+}
+
+// File names are in the format of diffTool_<vbno>_<bucketIndex>
+func getVbnoFromFileName(fileName string) (uint16, error) {
+	parts := strings.Split(fileName, base.FileNameDelimiter)
+	if len(parts) != 3 || parts[0] != base.FileNamePrefix {
+		return 0, fmt.Errorf("unexpected file name %v in checkpoint", fileName)
+	}
+	vbno, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected file name %v in checkpoint", fileName)
+	}
+	return uint16(vbno), nil
+}
+
+// Returns the vbuckets whose checkpoints differ, in vbucket order
+func DiffCheckpointDocs(oldDoc, newDoc *CheckpointDoc) []*CheckpointDelta {
+	vbnoMap := make(map[uint16]bool)
+	for vbno := range oldDoc.Checkpoints {
+		vbnoMap[vbno] = true
+	}
+	for vbno := range newDoc.Checkpoints {
+		vbnoMap[vbno] = true
+	}
+
+	var deltas []*CheckpointDelta
+	for vbno := range vbnoMap {
+		oldCkpt := oldDoc.Checkpoints[vbno]
+		newCkpt := newDoc.Checkpoints[vbno]
+		if oldCkpt != nil && newCkpt != nil && *oldCkpt == *newCkpt {
+			continue
+		}
+		deltas = append(deltas, &CheckpointDelta{
+			Vbno: vbno,
+			Old:  oldCkpt,
+			New:  newCkpt,
+		})
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].Vbno < deltas[j].Vbno })
+	return deltas
+}
+
+// Checks whether a stream resumed from the checkpoint would carry on from where it left off
+// instead of being rolled back by the server
+func ValidateCheckpoint(checkpoint *Checkpoint, state *VbucketState) error {
+	if checkpoint.Seqno == 0 {
+		// streams from the beginning regardless of vbuuid
+		return nil
+	}
+	if state == nil {
+		return fmt.Errorf("vbucket state is not available")
+	}
+	if checkpoint.Seqno > state.HighSeqno {
+		return fmt.Errorf("seqno %v is beyond current high seqno %v", checkpoint.Seqno, state.HighSeqno)
+	}
+
+	for i, entry := range state.FailoverLog {
+		if uint64(entry.VbUUID) != checkpoint.Vbuuid {
+			continue
+		}
+		if checkpoint.Seqno < uint64(entry.SeqNo) {
+			return fmt.Errorf("seqno %v precedes the start of vbuuid %v at seqno %v", checkpoint.Seqno, checkpoint.Vbuuid, entry.SeqNo)
+		}
+		// the branch ends where the next newer entry starts
+		if i > 0 && checkpoint.Seqno > uint64(state.FailoverLog[i-1].SeqNo) {
+			return fmt.Errorf("seqno %v is beyond seqno %v where vbuuid %v was failed over from", checkpoint.Seqno,
+				state.FailoverLog[i-1].SeqNo, checkpoint.Vbuuid)
+		}
+		return nil
+	}
+	return fmt.Errorf("vbuuid %v is not in failover log", checkpoint.Vbuuid)
+}
+
+// Connects to the cluster and retrieves the current state of every vbucket without streaming anything
+func (d *DcpDriver) GetVbucketStates() (map[uint16]*VbucketState, error) {
+	cm := d.checkpointManager
+	closeFunc, err := d.connectForInspection()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFunc()
+
+	statsMap, err := cm.getStatsWithRetry(base.VbucketSeqnoStatName)
+	if err != nil {
+		return nil, err
+	}
+	highSeqnoMap := make(map[uint16]uint64)
+	vbuuidMap := make(map[uint16]uint64)
+	err = utils.ParseHighSeqnoStat(statsMap, highSeqnoMap, vbuuidMap, true, int(d.numberOfVbuckets))
+	if err != nil {
+		return nil, err
+	}
+
+	failoverLogs, err := d.getFailoverLogs()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[uint16]*VbucketState)
+	for vbno, highSeqno := range highSeqnoMap {
+		states[vbno] = &VbucketState{
+			Vbuuid:      vbuuidMap[vbno],
+			HighSeqno:   highSeqno,
+			FailoverLog: failoverLogs[vbno],
+		}
+	}
+	return states, nil
+}
+
+// Sets up the cluster and bucket connections of the checkpoint manager without starting any stream
+// The returned function closes them
+func (d *DcpDriver) connectForInspection() (func(), error) {
+	cm := d.checkpointManager
+	err := d.populateCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cm.initializeCluster()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cm.initializeBucket()
+	if err != nil {
+		cm.cluster.Close(nil)
+		return nil, err
+	}
+
+	return func() {
+		cm.agent.Close()
+		cm.cluster.Close(nil)
+	}, nil
+}
+
+func (d *DcpDriver) getFailoverLogs() (map[uint16][]gocbcore.FailoverEntry, error) {
+	cm := d.checkpointManager
+	auth, bucketConnStr, err := initializeBucketWithSecurity(d, cm.kvVbMap, cm.kvSSLPortMap, true)
+	if err != nil {
+		return nil, err
+	}
+
+	feed, err := NewGocbcoreDCPFeed(d.Name+"FailoverLogs", []string{bucketConnStr}, d.bucketName, auth, d.capabilities.HasCollectionSupport(), d.ref, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	defer feed.dcpAgent.Close()
+
+	failoverLogs := make(map[uint16][]gocbcore.FailoverEntry)
+	errMap := make(map[uint16]error)
+	var mtx sync.Mutex
+	var waitGroup sync.WaitGroup
+	var vbno uint16
+	for vbno = 0; vbno < d.numberOfVbuckets; vbno++ {
+		curVbno := vbno
+		waitGroup.Add(1)
+		_, enqErr := feed.dcpAgent.GetFailoverLog(curVbno, func(entries []gocbcore.FailoverEntry, cbErr error) {
+			defer waitGroup.Done()
+			mtx.Lock()
+			defer mtx.Unlock()
+			if cbErr != nil {
+				errMap[curVbno] = cbErr
+				return
+			}
+			failoverLogs[curVbno] = entries
+		})
+		if enqErr != nil {
+			waitGroup.Done()
+			mtx.Lock()
+			errMap[curVbno] = enqErr
+			mtx.Unlock()
+		}
+	}
+
+	doneChan := make(chan bool)
+	go utils.WaitForWaitGroup(&waitGroup, doneChan)
+	select {
+	case <-doneChan:
+	case <-time.After(cm.bucketOpTimeout):
+		return nil, fmt.Errorf("%v timed out getting failover logs", d.Name)
+	}
+
+	if len(errMap) > 0 {
+		return nil, fmt.Errorf("%v unable to get failover logs. errs=%v", d.Name, errMap)
+	}
+	return failoverLogs, nil
+}
@@ -1,11 +1,10 @@
 package dcp
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -46,6 +45,18 @@ type CheckpointManager struct {
 	logOnceCount          uint64
 	lastRemainingMap      map[uint16]uint64
 
+	// number of previous checkpoints to keep alongside the latest one
+	checkpointHistory int
+	// Held for read while a mutation is being processed, and for write while the seqnos are captured and the
+	// bucket files flushed, so that a checkpoint always matches what is in the files
+	persistLock sync.RWMutex
+	// sizes of the files in the checkpoint that was resumed from. Carried over for the vbuckets that are not streamed
+	loadedFileSizes map[string]int64
+	// seqnos up to which tombstones have been purged, as of the last checkpoint. Only read and replaced while holding persistLock
+	purgeSeqnoMap map[uint16]uint64
+	// high seqnos of the vbuckets when the checkpoint manager started
+	highSeqnoMap map[uint16]uint64
+
 	kvSSLPortMap     xdcrBase.SSLPortMap
 	kvVbMap          map[string][]uint16
 	gocbcoreDcpFeed  *GocbcoreDCPFeed
@@ -55,7 +66,7 @@ type CheckpointManager struct {
 
 func NewCheckpointManager(dcpDriver *DcpDriver, checkpointFileDir, oldCheckpointFileName, newCheckpointFileName, clusterName string,
 	bucketOpTimeout time.Duration, maxNumOfGetStatsRetry int, getStatsRetryInterval, getStatsMaxBackoff time.Duration,
-	checkpointInterval int, startVbtsDoneChan chan bool, logger *xdcrLog.CommonLogger, completeBySeqno bool, numberOfVbuckets uint16) *CheckpointManager {
+	checkpointInterval int, startVbtsDoneChan chan bool, logger *xdcrLog.CommonLogger, completeBySeqno bool, numberOfVbuckets uint16, checkpointHistory int) *CheckpointManager {
 	cm := &CheckpointManager{
 		dcpDriver:             dcpDriver,
 		clusterName:           clusterName,
@@ -71,6 +82,7 @@ func NewCheckpointManager(dcpDriver *DcpDriver, checkpointFileDir, oldCheckpoint
 		getStatsRetryInterval: getStatsRetryInterval,
 		getStatsMaxBackoff:    getStatsMaxBackoff,
 		checkpointInterval:    checkpointInterval,
+		checkpointHistory:     checkpointHistory,
 		startVbtsDoneChan:     startVbtsDoneChan,
 		logger:                logger,
 		completeBySeqno:       completeBySeqno,
@@ -79,11 +91,11 @@ func NewCheckpointManager(dcpDriver *DcpDriver, checkpointFileDir, oldCheckpoint
 
 	if checkpointFileDir != "" {
 		if oldCheckpointFileName != "" {
-			cm.oldCheckpointFileName = checkpointFileDir + base.FileDirDelimiter + clusterName + base.FileNameDelimiter + oldCheckpointFileName
+			cm.oldCheckpointFileName = GetCheckpointFileName(checkpointFileDir, clusterName, oldCheckpointFileName)
 		}
 
 		if newCheckpointFileName != "" {
-			cm.newCheckpointFileName = checkpointFileDir + base.FileDirDelimiter + clusterName + base.FileNameDelimiter + newCheckpointFileName
+			cm.newCheckpointFileName = GetCheckpointFileName(checkpointFileDir, clusterName, newCheckpointFileName)
 		}
 	}
 
@@ -112,6 +124,9 @@ func (cm *CheckpointManager) OutputEndSeqnoMapDiff() map[uint16]uint64 {
 	diffMap := make(map[uint16]uint64)
 
 	for vb, curSeqno := range currentSeqnoMap {
+		if !cm.dcpDriver.isVbSelected(vb) {
+			continue
+		}
 		endSeqno, ok := endSeqnoMap[vb]
 		if ok {
 			diff := endSeqno - curSeqno
@@ -158,6 +173,7 @@ func (cm *CheckpointManager) Stop() error {
 	defer cm.logger.Infof("CheckpointManager stopped\n")
 
 	if cm.isStarted() {
+		cm.refreshPurgeSeqnos()
 		err := cm.SaveCheckpoint()
 		if err != nil {
 			cm.logger.Errorf("%v error saving checkpoint. err=%v\n", cm.clusterName, err)
@@ -175,26 +191,21 @@ func (cm *CheckpointManager) periodicalCheckpointing() {
 	ticker := time.NewTicker(time.Duration(cm.checkpointInterval) * time.Second)
 	defer ticker.Stop()
 
-	// periodical checkpointing iteration
-	// it is appended to checkpoint file Name to make file Name unique
-	iter := 0
-
 	for {
 		select {
 		case <-ticker.C:
-			cm.checkpointOnce(iter)
-			iter++
+			cm.checkpointOnce()
 		case <-cm.finChan:
 			return
 		}
 	}
 }
 
-func (cm *CheckpointManager) checkpointOnce(iter int) error {
-	checkpointFileName := cm.newCheckpointFileName + base.FileNameDelimiter + fmt.Sprintf("%v", iter)
-	err := cm.saveCheckpoint(checkpointFileName)
+// The previous checkpoints are rotated into the history files
+func (cm *CheckpointManager) checkpointOnce() error {
+	err := cm.saveCheckpoint(cm.newCheckpointFileName)
 	if err != nil {
-		cm.logger.Errorf("%v error saving checkpoint %v. err=%v\n", cm.clusterName, checkpointFileName, err)
+		cm.logger.Errorf("%v error saving checkpoint %v. err=%v\n", cm.clusterName, cm.newCheckpointFileName, err)
 	}
 	return err
 }
@@ -298,7 +309,7 @@ func (cm *CheckpointManager) initializeCluster() error {
 }
 
 func (cm *CheckpointManager) getVbuuidsAndHighSeqnos() error {
-	statsMap, err := cm.getStatsWithRetry()
+	statsMap, err := cm.getStatsWithRetry(base.VbucketSeqnoStatName)
 	if err != nil {
 		cm.logger.Errorf("getting stats returned error: %v", err)
 		return err
@@ -310,6 +321,12 @@ func (cm *CheckpointManager) getVbuuidsAndHighSeqnos() error {
 	if err != nil {
 		return err
 	}
+	purgeSeqnoMap := make(map[uint16]uint64)
+	err = utils.ParseVbucketPurgeSeqnoStat(statsMap, purgeSeqnoMap, int(cm.numberOfVbuckets))
+	if err != nil {
+		return err
+	}
+	cm.purgeSeqnoMap = purgeSeqnoMap
 
 	var sum uint64
 	for _, seqno := range endSeqnoMap {
@@ -318,6 +335,7 @@ func (cm *CheckpointManager) getVbuuidsAndHighSeqnos() error {
 	cm.logger.Infof("%v total mutations=%v\n", cm.clusterName, sum)
 
 	cm.vbuuidMap = vbuuidMap
+	cm.highSeqnoMap = endSeqnoMap
 
 	if cm.dcpDriver.completeBySeqno {
 		cm.endSeqnoMap = endSeqnoMap
@@ -340,7 +358,7 @@ func (cm *CheckpointManager) getVbuuidsAndHighSeqnos() error {
 }
 
 // get stats is likely to time out. add retry
-func (cm *CheckpointManager) getStatsWithRetry() (map[string]map[string]string, error) {
+func (cm *CheckpointManager) getStatsWithRetry(statsKey string) (map[string]map[string]string, error) {
 	var statsMap = make(map[string]map[string]string)
 	var err error
 
@@ -372,6 +390,9 @@ func (cm *CheckpointManager) getStatsWithRetry() (map[string]map[string]string,
 					cm.logger.Errorf("Errors map for stats: %v", errMap)
 					err = fmt.Errorf(xdcrBase.FlattenErrorMap(errMap))
 				}
+				if statsKey != base.VbucketSeqnoStatName {
+					return
+				}
 				// Make sure we get all the vbuuid and seqno
 				vbuuidMap := make(map[uint16]uint64)
 				endSeqnoMap := make(map[uint16]uint64)
@@ -386,7 +407,7 @@ func (cm *CheckpointManager) getStatsWithRetry() (map[string]map[string]string,
 
 		waitGroup.Add(1)
 		_, enqErr := cm.agent.Stats(gocbcore.StatsOptions{
-			Key:           base.VbucketSeqnoStatName,
+			Key:           statsKey,
 			Deadline:      time.Now().Add(cm.bucketOpTimeout),
 			RetryStrategy: &base.RetryStrategy{},
 		}, callback)
@@ -422,6 +443,15 @@ func (cm *CheckpointManager) setStartVBTS() error {
 			return err
 		}
 
+		// Nothing has been streamed yet so the files can be safely brought back to where the checkpoint was taken
+		cm.loadedFileSizes = checkpointDoc.FileSizes
+		if checkpointDoc.FileSizes != nil {
+			err = cm.dcpDriver.fileHandler.Truncate(checkpointDoc.FileSizes)
+			if err != nil {
+				return fmt.Errorf("%v unable to truncate files to checkpoint. err=%v", cm.clusterName, err)
+			}
+		}
+
 		for vbno, checkpoint := range checkpointDoc.Checkpoints {
 			cm.startVBTS[vbno] = &VBTS{
 				Checkpoint: checkpoint,
@@ -445,11 +475,39 @@ func (cm *CheckpointManager) setStartVBTS() error {
 		var vbno uint16
 		for vbno = 0; vbno < cm.numberOfVbuckets; vbno++ {
 			// if we are not loading checkpoints, it is ok to leave all fields in Checkpoint with default values, 0
+			checkpoint := &Checkpoint{}
+			// In live mode, only the documents that change from now on are rechecked
+			if cm.dcpDriver.changeObserver != nil {
+				highSeqno := cm.highSeqnoMap[vbno]
+				checkpoint = &Checkpoint{
+					Vbuuid:             cm.vbuuidMap[vbno],
+					Seqno:              highSeqno,
+					SnapshotStartSeqno: highSeqno,
+					SnapshotEndSeqno:   highSeqno,
+				}
+				cm.seqnoMap[vbno].setSeqno(highSeqno)
+				sum += highSeqno
+			}
 			cm.startVBTS[vbno] = &VBTS{
-				Checkpoint: &Checkpoint{},
+				Checkpoint: checkpoint,
 				EndSeqno:   cm.endSeqnoMap[vbno],
 			}
 		}
+
+		// When only some of the vbuckets are streamed, the files are kept across runs for the other vbuckets.
+		// The files of the streamed ones need to be started over
+		if len(cm.dcpDriver.vbnos) < int(cm.numberOfVbuckets) {
+			err := cm.dcpDriver.fileHandler.Empty()
+			if err != nil {
+				return fmt.Errorf("%v unable to empty files of vbuckets %v. err=%v", cm.clusterName, cm.dcpDriver.vbnos, err)
+			}
+			// The other vbuckets are checkpointed at seqno 0, so their files are checkpointed as empty too.
+			// Otherwise whatever they hold would be kept and streamed again once they are resumed from the checkpoint
+			cm.loadedFileSizes = make(map[string]int64)
+			for _, fileName := range cm.dcpDriver.fileHandler.UnselectedFileNames() {
+				cm.loadedFileSizes[fileName] = 0
+			}
+		}
 	}
 
 	cm.logger.Infof("%v starting from %v filtered %v unableToFilter %v\n", cm.clusterName, sum, totalFiltered, totalFailedFilter)
@@ -463,22 +521,36 @@ func (cm *CheckpointManager) GetStartVBTS(vbno uint16) *VBTS {
 	return cm.startVBTS[vbno]
 }
 
+// If the checkpoint file cannot be used, falls back to the most recent usable one from its history
 func (cm *CheckpointManager) loadCheckpoints() (*CheckpointDoc, error) {
-	checkpointFileBytes, err := ioutil.ReadFile(cm.oldCheckpointFileName)
-	if err != nil {
-		cm.logger.Errorf("Error opening checkpoint file. err=%v\n", err)
-		return nil, err
+	checkpointDoc, err := cm.loadCheckpointFile(cm.oldCheckpointFileName)
+	if err == nil {
+		return checkpointDoc, nil
 	}
 
-	checkpointDoc := &CheckpointDoc{}
-	err = json.Unmarshal(checkpointFileBytes, checkpointDoc)
+	for i := 1; i <= cm.checkpointHistory; i++ {
+		historyFileName := getCheckpointHistoryFileName(cm.oldCheckpointFileName, i)
+		if _, statErr := os.Stat(historyFileName); statErr != nil {
+			break
+		}
+		historyDoc, historyErr := cm.loadCheckpointFile(historyFileName)
+		if historyErr == nil {
+			cm.logger.Warnf("%v unable to use checkpoint %v. Resuming from %v instead\n", cm.clusterName, cm.oldCheckpointFileName, historyFileName)
+			return historyDoc, nil
+		}
+	}
+	return nil, err
+}
+
+func (cm *CheckpointManager) loadCheckpointFile(fileName string) (*CheckpointDoc, error) {
+	checkpointDoc, err := LoadCheckpointDoc(fileName)
 	if err != nil {
-		cm.logger.Errorf("Error unmarshalling checkpoint file. err=%v\n", err)
+		cm.logger.Errorf("Error loading checkpoint file %v. err=%v\n", fileName, err)
 		return nil, err
 	}
 
 	if len(checkpointDoc.Checkpoints) < int(cm.numberOfVbuckets) {
-		return nil, fmt.Errorf("checkpoint file %v has less than %v vbuckets", cm.oldCheckpointFileName, cm.numberOfVbuckets)
+		return nil, fmt.Errorf("checkpoint file %v has less than %v vbuckets", fileName, cm.numberOfVbuckets)
 	}
 
 	return checkpointDoc, nil
@@ -497,18 +569,60 @@ func (cm *CheckpointManager) saveCheckpoint(checkpointFileName string) error {
 	cm.logger.Infof("%v starting to save checkpoint %v\n", cm.clusterName, checkpointFileName)
 	defer cm.logger.Infof("%v completed saving checkpoint %v\n", cm.clusterName, checkpointFileName)
 
-	// delete existing file if exists
-	os.Remove(checkpointFileName)
+	checkpointDoc, total, totalFiltered, totalFailedFilter, err := cm.captureCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	err = SaveCheckpointDoc(checkpointFileName, checkpointDoc, cm.checkpointHistory)
+	if err != nil {
+		return err
+	}
+
+	cm.logger.Infof("----------------------------------------------------------------\n")
+	cm.logger.Infof("%v saved checkpoints to %v. totalMutationsChecked=%v filtered=%v filterErr=%v\n",
+		cm.clusterName, checkpointFileName, total, totalFiltered, totalFailedFilter)
+	return nil
+}
+
+// Compaction keeps purging tombstones while the vbuckets are streamed, so the purge seqnos are fetched again
+// once streaming has ended. The ones fetched at start are kept if the stats cannot be retrieved
+func (cm *CheckpointManager) refreshPurgeSeqnos() {
+	statsMap, err := cm.getStatsWithRetry(base.VbucketSeqnoStatName)
+	if err == nil {
+		purgeSeqnoMap := make(map[uint16]uint64)
+		err = utils.ParseVbucketPurgeSeqnoStat(statsMap, purgeSeqnoMap, int(cm.numberOfVbuckets))
+		if err == nil {
+			cm.persistLock.Lock()
+			cm.purgeSeqnoMap = purgeSeqnoMap
+			cm.persistLock.Unlock()
+			return
+		}
+	}
+	cm.logger.Warnf("%v unable to refresh purge seqnos. Checkpointing the last known ones. err=%v\n", cm.clusterName, err)
+}
 
-	checkpointDoc := &CheckpointDoc{
+// Captures the seqnos and flushes the bucket files while no mutation is being processed
+func (cm *CheckpointManager) captureCheckpoint() (checkpointDoc *CheckpointDoc, total, totalFiltered, totalFailedFilter uint64, err error) {
+	cm.persistLock.Lock()
+	defer cm.persistLock.Unlock()
+
+	checkpointDoc = &CheckpointDoc{
 		Checkpoints: make(map[uint16]*Checkpoint),
 	}
 
 	var vbno uint16
-	var total uint64
-	var totalFiltered uint64
-	var totalFailedFilter uint64
 	for vbno = 0; vbno < cm.numberOfVbuckets; vbno++ {
+		if !cm.dcpDriver.isVbSelected(vbno) {
+			// not streamed, so whatever it was resumed from still holds
+			checkpoint := *cm.startVBTS[vbno].Checkpoint
+			total += checkpoint.Seqno
+			totalFiltered += checkpoint.FilteredCnt
+			totalFailedFilter += checkpoint.FailedFilterCnt
+			checkpointDoc.Checkpoints[vbno] = &checkpoint
+			continue
+		}
+
 		vbuuid := cm.vbuuidMap[vbno]
 		seqno := cm.seqnoMap[vbno].getSeqno()
 		total += seqno
@@ -534,33 +648,77 @@ func (cm *CheckpointManager) saveCheckpoint(checkpointFileName string) error {
 			SnapshotEndSeqno:   snapshotEndSeqno,
 			FilteredCnt:        filteredCnt,
 			FailedFilterCnt:    failedFilterCnt,
+			PurgeSeqno:         cm.purgeSeqnoMap[vbno],
 		}
 	}
 
-	value, err := json.Marshal(checkpointDoc)
+	checkpointDoc.FileSizes, err = cm.dcpDriver.fileHandler.Flush()
+	if err != nil {
+		return
+	}
+	for fileName, size := range cm.loadedFileSizes {
+		if _, exists := checkpointDoc.FileSizes[fileName]; !exists {
+			checkpointDoc.FileSizes[fileName] = size
+		}
+	}
+	return
+}
+
+func getCheckpointHistoryFileName(checkpointFileName string, index int) string {
+	return fmt.Sprintf("%v.%v", checkpointFileName, index)
+}
+
+// Writes to a temporary file first and renames it over the checkpoint file so that the checkpoint file is
+// never left partially written. The previous checkpoint file is hard linked into the history beforehand
+func writeCheckpointFile(checkpointFileName string, value []byte, history int) error {
+	tempFileName := checkpointFileName + base.CheckpointTempFileSuffix
+	tempFile, err := os.OpenFile(tempFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, base.FileModeReadWrite)
 	if err != nil {
 		return err
 	}
 
-	checkpointFile, err := os.OpenFile(checkpointFileName, os.O_RDWR|os.O_CREATE, base.FileModeReadWrite)
+	numOfBytes, err := tempFile.Write(value)
+	if err == nil && numOfBytes != len(value) {
+		err = fmt.Errorf("Incomplete write. expected=%v, actual=%v", len(value), numOfBytes)
+	}
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		os.Remove(tempFileName)
 		return err
 	}
 
-	defer checkpointFile.Close()
+	if history > 0 {
+		if _, statErr := os.Stat(checkpointFileName); statErr == nil {
+			for i := history - 1; i >= 1; i-- {
+				os.Rename(getCheckpointHistoryFileName(checkpointFileName, i), getCheckpointHistoryFileName(checkpointFileName, i+1))
+			}
+			firstHistoryFileName := getCheckpointHistoryFileName(checkpointFileName, 1)
+			os.Remove(firstHistoryFileName)
+			err = os.Link(checkpointFileName, firstHistoryFileName)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-	numOfBytes, err := checkpointFile.Write(value)
+	err = os.Rename(tempFileName, checkpointFileName)
 	if err != nil {
 		return err
 	}
-	if numOfBytes != len(value) {
-		return fmt.Errorf("Incomplete write. expected=%v, actual=%v", len(value), numOfBytes)
-	}
 
-	cm.logger.Infof("----------------------------------------------------------------\n")
-	cm.logger.Infof("%v saved checkpoints to %v. totalMutationsChecked=%v filtered=%v filterErr=%v\n",
-		cm.clusterName, checkpointFileName, total, totalFiltered, totalFailedFilter)
-	return nil
+	// sync the directory so that the rename itself survives a crash
+	dir, err := os.Open(filepath.Dir(checkpointFileName))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
 }
 
 // Returns false if mutation is filtered (should not be recorded into bucket)
@@ -579,7 +737,7 @@ func (cm *CheckpointManager) RecordFilterEvent(vbno uint16, filterResult base.Fi
 // no need to lock seqoMap since
 //  1. MutationProcessedEvent on a Vbno are serialized
 //  2. checkpointManager reads seqnoMap when it saves checkpoints.
-//     This is done after all DcpHandlers are stopped and MutationProcessedEvent cease to happen
+//     This is done while holding persistLock, which the DcpHandlers hold while processing a mutation
 func (cm *CheckpointManager) HandleMutationEvent(mut *Mutation, filterResult base.FilterResultType) bool {
 	if cm.dcpDriver.completeBySeqno {
 		endSeqno := cm.endSeqnoMap[mut.Vbno]
@@ -621,23 +779,18 @@ func (cm *CheckpointManager) initializeBucket() (err error) {
 		return
 	}
 
-	useTLS, x509Provider, authProvider, err := getAgentConfigs(auth, cm.dcpDriver.ref)
+	securityConfig, err := getSecurityConfig(auth, cm.dcpDriver.ref)
 	if err != nil {
-		cm.logger.Errorf("getAgentConfigs had err %v", err)
+		cm.logger.Errorf("getSecurityConfig had err %v\n", err)
 		return
 	}
 
 	agentConfig := &gocbcore.AgentConfig{
-		SeedConfig: gocbcore.SeedConfig{MemdAddrs: []string{bucketConnStr}},
-		BucketName: cm.dcpDriver.bucketName,
-		UserAgent:  "xdcrDifferCheckpointMgr",
-		SecurityConfig: gocbcore.SecurityConfig{
-			UseTLS:            useTLS,
-			TLSRootCAProvider: x509Provider,
-			Auth:              authProvider,
-			AuthMechanisms:    base.ScramShaAuth,
-		},
-		IoConfig: gocbcore.IoConfig{UseCollections: cm.dcpDriver.capabilities.HasCollectionSupport()},
+		SeedConfig:     gocbcore.SeedConfig{MemdAddrs: []string{bucketConnStr}},
+		BucketName:     cm.dcpDriver.bucketName,
+		UserAgent:      "xdcrDifferCheckpointMgr",
+		SecurityConfig: securityConfig,
+		IoConfig:       gocbcore.IoConfig{UseCollections: cm.dcpDriver.capabilities.HasCollectionSupport()},
 	}
 
 	agent, err := gocbcore.CreateAgent(agentConfig)
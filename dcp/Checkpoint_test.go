@@ -0,0 +1,75 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package dcp
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/xdcrDiffer/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpointChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := &CheckpointDoc{
+		Checkpoints: map[uint16]*Checkpoint{0: {Vbuuid: 1, Seqno: 10}, 1: {Vbuuid: 2, Seqno: 20}},
+		FileSizes:   map[string]int64{"diffTool_0_0": 100},
+	}
+	// written by older versions
+	assert.Nil(doc.VerifyChecksum())
+
+	assert.Nil(doc.SetChecksum())
+	assert.NotEqual("", doc.Checksum)
+	assert.Nil(doc.VerifyChecksum())
+
+	doc.Checkpoints[1].Seqno = 21
+	assert.NotNil(doc.VerifyChecksum())
+}
+
+func TestSaveCheckpointDoc(t *testing.T) {
+	assert := assert.New(t)
+
+	fileName := filepath.Join(t.TempDir(), "source_checkpoint")
+	for seqno := uint64(1); seqno <= 3; seqno++ {
+		doc := &CheckpointDoc{Checkpoints: map[uint16]*Checkpoint{0: {Seqno: seqno}}}
+		assert.Nil(SaveCheckpointDoc(fileName, doc, 1))
+	}
+
+	doc, err := LoadCheckpointDoc(fileName)
+	assert.Nil(err)
+	assert.Equal(uint64(3), doc.Checkpoints[0].Seqno)
+	// only the one before is kept in the history, and nothing is left of the temporary file
+	doc, err = LoadCheckpointDoc(getCheckpointHistoryFileName(fileName, 1))
+	assert.Nil(err)
+	assert.Equal(uint64(2), doc.Checkpoints[0].Seqno)
+	_, err = os.Stat(getCheckpointHistoryFileName(fileName, 2))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(fileName + base.CheckpointTempFileSuffix)
+	assert.True(os.IsNotExist(err))
+
+	// a failed write leaves the checkpoint file as it was
+	assert.Nil(os.Mkdir(fileName+base.CheckpointTempFileSuffix, 0777))
+	assert.NotNil(SaveCheckpointDoc(fileName, &CheckpointDoc{Checkpoints: map[uint16]*Checkpoint{0: {Seqno: 4}}}, 1))
+	doc, err = LoadCheckpointDoc(fileName)
+	assert.Nil(err)
+	assert.Equal(uint64(3), doc.Checkpoints[0].Seqno)
+
+	// a checkpoint that was modified after it was written is rejected
+	doc.Checkpoints[0].Seqno = 5
+	value, err := json.Marshal(doc)
+	assert.Nil(err)
+	assert.Nil(os.WriteFile(fileName, value, 0644))
+	_, err = LoadCheckpointDoc(fileName)
+	assert.NotNil(err)
+}
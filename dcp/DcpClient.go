@@ -224,8 +224,8 @@ func initializeClusterWithSecurity(dcpDriver *DcpDriver) (*gocb.Cluster, error)
 
 	useCouchbaseSecureStr := dcpDriver.ref.HttpAuthMech() == xdcrBase.HttpAuthMechHttps
 
-	// If it is a source cluster, use cbauth username/pw and not client certs
-	if dcpDriver.Name != base.SourceClusterName && len(dcpDriver.ref.ClientCertificate()) > 0 && len(dcpDriver.ref.ClientKey()) > 0 {
+	// The source reference only carries client certs if given by sourceClientCertFile. Otherwise use cbauth username/pw
+	if len(dcpDriver.ref.ClientCertificate()) > 0 && len(dcpDriver.ref.ClientKey()) > 0 {
 		tlsCert, err := tls.X509KeyPair(dcpDriver.ref.ClientCertificate(), dcpDriver.ref.ClientKey())
 		if err != nil {
 			dcpDriver.logger.Errorf("error generating tlsCert from the cluster reference: %v\n", err)
@@ -242,6 +242,14 @@ func initializeClusterWithSecurity(dcpDriver *DcpDriver) (*gocb.Cluster, error)
 
 	cccpString := utils.PopulateCCCPConnectString(dcpDriver.url)
 	if useCouchbaseSecureStr {
+		// verify the cluster against the root CAs of the reference rather than the system ones
+		certPool, err := base.NewRootCAPool(dcpDriver.ref.Certificates())
+		if err != nil {
+			dcpDriver.logger.Errorf("error setting up the root CAs of the cluster reference: %v\n", err)
+			return nil, err
+		}
+		clusterOpts.SecurityConfig = gocb.SecurityConfig{TLSRootCAs: certPool}
+
 		cccpString = strings.TrimPrefix(cccpString, base.CouchbasePrefix)
 		cccpString = fmt.Sprintf("%v%v", base.CouchbaseSecurePrefix, cccpString)
 	}
@@ -261,7 +269,8 @@ func (c *DcpClient) initializeBucket() (err error) {
 		return err
 	}
 
-	c.gocbcoreDcpFeed, err = NewGocbcoreDCPFeed(c.Name, []string{bucketConnStr}, c.dcpDriver.bucketName, auth, c.capabilities.HasCollectionSupport(), c.dcpDriver.ref)
+	c.gocbcoreDcpFeed, err = NewGocbcoreDCPFeed(c.Name, []string{bucketConnStr}, c.dcpDriver.bucketName, auth, c.capabilities.HasCollectionSupport(), c.dcpDriver.ref,
+		c.dcpDriver.dcpBufferSize(), c.dcpDriver.noValue)
 	return
 }
 
@@ -280,7 +289,7 @@ func initializeBucketWithSecurity(dcpDriver *DcpDriver, kvVbMap map[string][]uin
 
 	useSecurePrefix := dcpDriver.ref.HttpAuthMech() == xdcrBase.HttpAuthMechHttps
 
-	if dcpDriver.Name != base.SourceClusterName && len(dcpDriver.ref.ClientKey()) > 0 && len(dcpDriver.ref.ClientCertificate()) > 0 {
+	if len(dcpDriver.ref.ClientKey()) > 0 && len(dcpDriver.ref.ClientCertificate()) > 0 {
 		auth = &base.CertificateAuth{
 			// For client cert auth, no pw or username given
 			PasswordAuth:     base.PasswordAuth{},
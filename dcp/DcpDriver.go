@@ -65,12 +65,29 @@ type DcpDriver struct {
 	xattrKeysForNoCompare map[string]bool
 	numberOfVbuckets      uint16
 	fileHandler           *fh.FileHandler
+	keySelector           *base.KeySelector
+	changeObserver        ChangeObserver
+
+	// the vbuckets to stream. The other vbuckets are left as they are in the checkpoint and in the files
+	vbnos         []uint16
+	selectedVbnos map[uint16]bool
+
+	// bounds the bytes of mutations the handlers of both clusters hold together, nil for no limit
+	memoryBudget *base.ByteBudget
+	// document bodies are not needed, so only keys, metadata and xattrs are streamed
+	noValue bool
+	// digests the document bodies written to the files
+	hashAlgorithm base.HashAlgorithm
 
 	// various counters
 	totalNumReceivedFromDCP                uint64
 	totalSysOrUnsubbedEventReceivedFromDCP uint64
 }
 
+// In live mode, every document change that passes the filters is handed over to be rechecked,
+// instead of being written to the files for the file differ
+type ChangeObserver func(colId uint32, key []byte)
+
 type VBStateWithLock struct {
 	vbState VBState
 	lock    sync.RWMutex
@@ -92,7 +109,7 @@ const (
 	DriverStateStopped DriverState = iota
 )
 
-func NewDcpDriver(logger *xdcrLog.CommonLogger, name, url, bucketName string, ref *metadata.RemoteClusterReference, fileDir, checkpointFileDir, oldCheckpointFileName, newCheckpointFileName string, numberOfClients, numberOfWorkers, numberOfBins, dcpHandlerChanSize int, bucketOpTimeout time.Duration, maxNumOfGetStatsRetry int, getStatsRetryInterval, getStatsMaxBackoff time.Duration, checkpointInterval int, errChan chan error, waitGroup *sync.WaitGroup, completeBySeqno bool, fdPool fdp.FdPoolIface, filter xdcrParts.Filter, capabilities metadata.Capability, collectionIds []uint32, colMigrationFilters []string, utils xdcrUtils.UtilsIface, bufferCap int, migrationMapping metadata.CollectionNamespaceMapping, mobileCompat int, expDelMode xdcrBase.FilterExpDelType, xattrKeysForNoCompare map[string]bool, numberOfVbuckets uint16, isVariableVB bool) *DcpDriver {
+func NewDcpDriver(logger *xdcrLog.CommonLogger, name, url, bucketName string, ref *metadata.RemoteClusterReference, fileDir, checkpointFileDir, oldCheckpointFileName, newCheckpointFileName string, numberOfClients, numberOfWorkers, numberOfBins, dcpHandlerChanSize int, bucketOpTimeout time.Duration, maxNumOfGetStatsRetry int, getStatsRetryInterval, getStatsMaxBackoff time.Duration, checkpointInterval int, errChan chan error, waitGroup *sync.WaitGroup, completeBySeqno bool, fdPool fdp.FdPoolIface, filter xdcrParts.Filter, capabilities metadata.Capability, collectionIds []uint32, colMigrationFilters []string, utils xdcrUtils.UtilsIface, bufferCap int, migrationMapping metadata.CollectionNamespaceMapping, mobileCompat int, expDelMode xdcrBase.FilterExpDelType, xattrKeysForNoCompare map[string]bool, numberOfVbuckets uint16, isVariableVB bool, keySelector *base.KeySelector, changeObserver ChangeObserver, checkpointHistory int, vbnos []uint16, memoryBudget *base.ByteBudget, noValue bool, hashAlgorithm base.HashAlgorithm) *DcpDriver {
 	dcpDriver := &DcpDriver{
 		Name:                  name,
 		url:                   url,
@@ -122,20 +139,34 @@ func NewDcpDriver(logger *xdcrLog.CommonLogger, name, url, bucketName string, re
 		expDelMode:            expDelMode,
 		xattrKeysForNoCompare: xattrKeysForNoCompare,
 		numberOfVbuckets:      numberOfVbuckets,
+		keySelector:           keySelector,
+		changeObserver:        changeObserver,
+		vbnos:                 base.SelectedVbuckets(vbnos, numberOfVbuckets),
+		selectedVbnos:         make(map[uint16]bool),
+		memoryBudget:          memoryBudget,
+		noValue:               noValue,
+		hashAlgorithm:         hashAlgorithm,
 	}
 	requiresVBRemapping := isVariableVB && numberOfVbuckets != base.TraditionalNumberOfVbuckets
-	dcpDriver.fileHandler = fh.NewFileHandler(fileDir, fdPool, numberOfVbuckets, numberOfBins, bufferCap, requiresVBRemapping, logger)
+	dcpDriver.fileHandler = fh.NewFileHandler(fileDir, fdPool, numberOfVbuckets, numberOfBins, bufferCap, requiresVBRemapping, logger, vbnos, hashAlgorithm)
+	for _, vbno := range dcpDriver.vbnos {
+		dcpDriver.selectedVbnos[vbno] = true
+	}
 	var vbno uint16
 	for vbno = 0; vbno < dcpDriver.numberOfVbuckets; vbno++ {
 		dcpDriver.vbStateMap[vbno] = &VBStateWithLock{
 			vbState: VBStateNormal,
 		}
+		if !dcpDriver.selectedVbnos[vbno] {
+			// nothing to stream
+			dcpDriver.vbStateMap[vbno].vbState = VBStateCompleted
+		}
 	}
 
 	dcpDriver.checkpointManager = NewCheckpointManager(dcpDriver, checkpointFileDir, oldCheckpointFileName,
 		newCheckpointFileName, name, bucketOpTimeout, maxNumOfGetStatsRetry,
 		getStatsRetryInterval, getStatsMaxBackoff, checkpointInterval, dcpDriver.startVbtsDoneChan, logger,
-		completeBySeqno, dcpDriver.numberOfVbuckets)
+		completeBySeqno, dcpDriver.numberOfVbuckets, checkpointHistory)
 
 	base.TagHttpPrefix(&dcpDriver.url)
 
@@ -238,14 +269,15 @@ func (d *DcpDriver) Stop() error {
 
 	d.childWaitGroup.Wait()
 
-	// close all the open files
-	d.fileHandler.Close()
-
+	// the last checkpoint flushes the files, so it needs to be saved before they are closed
 	err := d.checkpointManager.Stop()
 	if err != nil {
 		d.logger.Errorf("%v error stopping checkpoint manager. err=%v\n", d.Name, err)
 	}
 
+	// close all the open files
+	d.fileHandler.Close()
+
 	d.state = DriverStateStopped
 
 	return nil
@@ -264,13 +296,13 @@ func (d *DcpDriver) initializeDcpClients() {
 	d.stateLock.Lock()
 	defer d.stateLock.Unlock()
 
-	loadDistribution := utils.BalanceLoad(d.numberOfClients, int(d.numberOfVbuckets))
+	loadDistribution := utils.BalanceLoad(d.numberOfClients, len(d.vbnos))
 	for i := 0; i < d.numberOfClients; i++ {
 		lowIndex := loadDistribution[i][0]
 		highIndex := loadDistribution[i][1]
 		vbList := make([]uint16, highIndex-lowIndex)
 		for j := lowIndex; j < highIndex; j++ {
-			vbList[j-lowIndex] = uint16(j)
+			vbList[j-lowIndex] = d.vbnos[j]
 		}
 
 		d.childWaitGroup.Add(1)
@@ -347,6 +379,10 @@ func (d *DcpDriver) handleVbucketCompletion(vbno uint16, err error, reason strin
 	}
 }
 
+func (d *DcpDriver) isVbSelected(vbno uint16) bool {
+	return d.selectedVbnos[vbno]
+}
+
 func (d *DcpDriver) getVbState(vbno uint16) VBState {
 	vbStateWithLock := d.vbStateMap[vbno]
 	vbStateWithLock.lock.RLock()
@@ -368,3 +404,21 @@ func (d *DcpDriver) IncrementDocReceived() {
 func (d *DcpDriver) IncrementSysOrUnsubbedEventReceived() {
 	atomic.AddUint64(&d.totalSysOrUnsubbedEventReceivedFromDCP, 1)
 }
+
+// gocbcore only acknowledges a DCP mutation once the handler callback returns, so a handler waiting for the
+// budget holds up the acknowledgements of its connection. The buffers of the clients of both clusters are
+// sized to fit in the budget together, so that the servers stop sending soon after the budget is used up
+func (d *DcpDriver) dcpBufferSize() int {
+	if d.memoryBudget == nil {
+		// gocbcore default
+		return 0
+	}
+	bufferSize := d.memoryBudget.Limit() / uint64(2*d.numberOfClients)
+	if bufferSize < base.MinDcpBufferSize {
+		return base.MinDcpBufferSize
+	}
+	if bufferSize > base.MaxDcpBufferSize {
+		return base.MaxDcpBufferSize
+	}
+	return int(bufferSize)
+}
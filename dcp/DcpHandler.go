@@ -10,7 +10,6 @@
 package dcp
 
 import (
-	"crypto/sha512"
 	"encoding/binary"
 	"fmt"
 	"sort"
@@ -53,6 +52,11 @@ type DcpHandler struct {
 	expDelMode                    xdcrBase.FilterExpDelType
 	xattrIterator                 *xdcrBase.XattrIterator
 	fileHandler                   *fh.FileHandler
+	keySelector                   *base.KeySelector
+	changeObserver                ChangeObserver
+
+	// bounds the bytes of mutations queued in the dataChans of all handlers, nil for no limit
+	memoryBudget *base.ByteBudget
 }
 
 func NewDcpHandler(dcpClient *DcpClient, index int, vbList []uint16, numberOfBins, dataChanSize int, incReceivedCounter, incSysOrUnsubbedEvtReceived func(), colMigrationFilters []string, utils xdcrUtils.UtilsIface, migrationMapping metadata.CollectionNamespaceMapping, fileHandler *fh.FileHandler) (*DcpHandler, error) {
@@ -79,6 +83,9 @@ func NewDcpHandler(dcpClient *DcpClient, index int, vbList []uint16, numberOfBin
 		expDelMode:                    dcpClient.dcpDriver.expDelMode,
 		xattrIterator:                 &xdcrBase.XattrIterator{},
 		fileHandler:                   fileHandler,
+		keySelector:                   dcpClient.dcpDriver.keySelector,
+		changeObserver:                dcpClient.dcpDriver.changeObserver,
+		memoryBudget:                  dcpClient.dcpDriver.memoryBudget,
 	}, nil
 }
 
@@ -132,17 +139,36 @@ func (dh *DcpHandler) processData() {
 			goto done
 		case mut := <-dh.dataChan:
 			dh.processMutation(mut)
+			if dh.memoryBudget != nil {
+				dh.memoryBudget.Release(mut.Size())
+			}
 		}
 	}
 done:
+	// the budget is shared with the handlers that are still running
+	if dh.memoryBudget != nil {
+		for {
+			select {
+			case mut := <-dh.dataChan:
+				dh.memoryBudget.Release(mut.Size())
+			default:
+				return
+			}
+		}
+	}
 }
 
 func (dh *DcpHandler) processMutation(mut *Mutation) {
 	var matched bool
 	var replicationFilterResult base.FilterResultType
 
+	// A checkpoint cannot be taken until the mutation has either been written to its bucket or skipped
+	checkpointManager := dh.dcpClient.dcpDriver.checkpointManager
+	checkpointManager.persistLock.RLock()
+	defer checkpointManager.persistLock.RUnlock()
+
 	replicationFilterResult = dh.replicationFilter(mut, matched, replicationFilterResult)
-	valid := dh.dcpClient.dcpDriver.checkpointManager.HandleMutationEvent(mut, replicationFilterResult)
+	valid := checkpointManager.HandleMutationEvent(mut, replicationFilterResult)
 	if !valid {
 		// if mutation is out of range, ignore it
 		return
@@ -158,12 +184,19 @@ func (dh *DcpHandler) processMutation(mut *Mutation) {
 		return
 	}
 
+	// Documents outside of the user-specified key range are not part of the diff
+	if !dh.keySelector.Matches(mut.Key) {
+		return
+	}
+
 	var filterIdsMatched []uint8
 	if dh.colMigrationFiltersOn && dh.isSource {
 		dh.checkColMigrationDataCloned(mut)
 
 		filterIdsMatched = dh.checkColMigrationFilters(mut)
-		if len(filterIdsMatched) == 0 {
+		// Mutations that pass no filter are not replicated. They are still recorded, without filter IDs, so that the
+		// file differ can count the documents whose latest version passes no filter
+		if len(filterIdsMatched) == 0 && (!mut.IsMutation() || dh.changeObserver != nil) {
 			return
 		}
 	}
@@ -172,17 +205,24 @@ func (dh *DcpHandler) processMutation(mut *Mutation) {
 		mut.ColFiltersMatched = filterIdsMatched
 	}
 
+	if dh.changeObserver != nil {
+		dh.changeObserver(mut.ColId, mut.Key)
+		return
+	}
+
 	bucket, err := dh.fileHandler.GetBucket(mut.Key, mut.Vbno)
 	if err != nil {
 		dh.logger.Errorf("failed to write mutation for document %s with cas %v revID %v. err=%v", mut.Key, mut.Cas, mut.RevId, err)
 		return
 	}
 
+	mut.NoValue = dh.dcpClient.dcpDriver.noValue
+	mut.HashAlgorithm = dh.dcpClient.dcpDriver.hashAlgorithm
 	ret, err := mut.Serialize()
 	if err != nil {
 		dh.logger.Errorf("error in Serializing the mutation pertaining to the document with the key:%v ,err:%v\n", mut.Key, err)
-	} else {
-		bucket.Write(ret)
+	} else if err = bucket.Write(ret); err != nil {
+		dh.logger.Errorf("error in writing the mutation pertaining to the document with the key:%v ,err:%v\n", mut.Key, err)
 	}
 }
 
@@ -202,11 +242,19 @@ func (dh *DcpHandler) replicationFilter(mut *Mutation, matched bool, filterResul
 	return filterResult
 }
 
+// Blocking here holds up the DCP buffer acknowledgement of the mutation, which throttles the producer
 func (dh *DcpHandler) writeToDataChan(mut *Mutation) {
+	if dh.memoryBudget != nil && !dh.memoryBudget.Acquire(mut.Size(), dh.finChan) {
+		// dh is stopping
+		return
+	}
 	select {
 	case dh.dataChan <- mut:
 	// provides an alternative exit path when dh stops
 	case <-dh.finChan:
+		if dh.memoryBudget != nil {
+			dh.memoryBudget.Release(mut.Size())
+		}
 	}
 }
 
@@ -316,6 +364,9 @@ type Mutation struct {
 	ColFiltersMatched     []uint8 // Given a ordered list of filters, this list contains indexes of the ordered list of filter that matched
 	XattrIterator         *xdcrBase.XattrIterator
 	XattrKeysForNoCompare map[string]bool
+	// The stream carries no document bodies, only xattrs, so no body hash is recorded
+	NoValue       bool
+	HashAlgorithm base.HashAlgorithm
 }
 
 func CreateMutation(vbno uint16, key []byte, seqno, revId, cas uint64, flags, expiry uint32, opCode gomemcached.CommandCode, value []byte, datatype uint8, collectionId uint32, xattrIterator *xdcrBase.XattrIterator, xattrKeysForNoCompare map[string]bool) *Mutation {
@@ -336,6 +387,11 @@ func CreateMutation(vbno uint16, key []byte, seqno, revId, cas uint64, flags, ex
 	}
 }
 
+// Approximate number of bytes held by the mutation until it is serialized
+func (m *Mutation) Size() uint64 {
+	return uint64(len(m.Key) + len(m.Value))
+}
+
 func (m *Mutation) IsExpiration() bool {
 	return m.OpCode == gomemcached.UPR_EXPIRATION
 }
@@ -388,18 +444,28 @@ func (m *Mutation) ToUprEvent() *xdcrBase.WrappedUprEvent {
 //	Expiry   - 4 bytes
 //	opType   - 2 byte
 //	Datatype - 2 byte
-//	hash     - 64 bytes
+//	importCas - 8 bytes
+//	pRev     - 8 bytes
+//	preImportCas - 8 bytes
+//	syncCas  - 8 bytes
+//	hlvLen   - 8 bytes
+//	hlv      - length specified by hlvLen
+//	syncRevLen - 2 bytes
+//	syncRev  - length specified by syncRevLen, 0 if the document has no _sync xattr
+//	hashLen  - 2 bytes
+//	hash     - length specified by hashLen, which depends on the hash algorithm. 0 if the stream carries no document bodies
 //	collectionId - 4 bytes
 //	colFiltersLen - 2 byte (number of collection migration filters)
 //	(per col filter) - 2 byte
-
-// Darshan:TODO accomodate SGW xattr change from "import" to "_mou" when MB-60897 is checked-in
+//
+// The Sync Gateway metadata is read from the _sync and _mou xattrs
 func (mut *Mutation) Serialize() ([]byte, error) {
-	var bodyHash [64]byte
+	var bodyHash []byte
 	var xattrSize uint32
 	var xattr []byte
 	var bodyWithoutXattr, trimmedXattrPlusBody, hlv []byte
-	var importCas, pRev uint64
+	var importCas, pRev, preImportCas, syncCas uint64
+	var syncRev string
 	var err error
 	if mut.Datatype&xdcrBase.XattrDataType > 0 {
 		var KVsToBeExcluded map[string][]byte
@@ -420,15 +486,29 @@ func (mut *Mutation) Serialize() ([]byte, error) {
 			if err != nil {
 				return nil, err
 			}
+			preImportCas, err = base.ParseMouPreImportCas(mou)
+			if err != nil {
+				return nil, err
+			}
 		}
-		bodyHash = sha512.Sum512(trimmedXattrPlusBody)
-	} else {
-		bodyHash = sha512.Sum512(mut.Value)
+		if syncXattr, ok := KVsToBeExcluded[xdcrBase.XATTR_MOBILE]; ok {
+			syncRev, syncCas, err = base.ParseSyncXattr(syncXattr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !mut.NoValue {
+			bodyHash = mut.HashAlgorithm.Sum(trimmedXattrPlusBody)
+		}
+	} else if !mut.NoValue {
+		bodyHash = mut.HashAlgorithm.Sum(mut.Value)
 	}
 
+	hashLen := len(bodyHash)
 	hlvLen := uint64(len(hlv))
 	keyLen := len(mut.Key)
-	ret := make([]byte, base.GetFixedSizeMutationLen(keyLen, hlvLen, mut.ColFiltersMatched))
+	syncRevLen := len(syncRev)
+	ret := make([]byte, base.GetFixedSizeMutationLen(keyLen, hlvLen, hashLen, syncRevLen, mut.ColFiltersMatched))
 
 	pos := 0
 	binary.BigEndian.PutUint16(ret[pos:pos+2], uint16(keyLen))
@@ -453,12 +533,22 @@ func (mut *Mutation) Serialize() ([]byte, error) {
 	pos += 8
 	binary.BigEndian.PutUint64(ret[pos:pos+8], pRev)
 	pos += 8
+	binary.BigEndian.PutUint64(ret[pos:pos+8], preImportCas)
+	pos += 8
+	binary.BigEndian.PutUint64(ret[pos:pos+8], syncCas)
+	pos += 8
 	binary.BigEndian.PutUint64(ret[pos:pos+8], hlvLen)
 	pos += 8
 	copy(ret[pos:pos+int(hlvLen)], hlv)
 	pos += int(hlvLen)
-	copy(ret[pos:], bodyHash[:])
-	pos += 64
+	binary.BigEndian.PutUint16(ret[pos:pos+2], uint16(syncRevLen))
+	pos += 2
+	copy(ret[pos:pos+syncRevLen], syncRev)
+	pos += syncRevLen
+	binary.BigEndian.PutUint16(ret[pos:pos+2], uint16(hashLen))
+	pos += 2
+	copy(ret[pos:pos+hashLen], bodyHash)
+	pos += hashLen
 	binary.BigEndian.PutUint32(ret[pos:pos+4], mut.ColId)
 	pos += 4
 	binary.BigEndian.PutUint16(ret[pos:pos+2], uint16(len(mut.ColFiltersMatched)))
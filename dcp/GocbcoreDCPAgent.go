@@ -1,9 +1,7 @@
 package dcp
 
 import (
-	"crypto/x509"
 	"fmt"
-	"reflect"
 	"time"
 
 	gocbcore "github.com/couchbase/gocbcore/v10"
@@ -16,6 +14,10 @@ import (
 type GocbcoreDCPFeed struct {
 	base.GocbcoreAgentCommon
 	dcpAgent *gocbcore.DCPAgent
+	// flow control buffer size per connection. 0 for the gocbcore default
+	bufferSize int
+	// stream keys, metadata and xattrs only
+	noValue bool
 }
 
 func (f *GocbcoreDCPFeed) setupDCPAgent(auth interface{}, collections bool, ref *metadata.RemoteClusterReference) error {
@@ -32,6 +34,7 @@ func (f *GocbcoreDCPFeed) setupDCPAgent(auth interface{}, collections bool, ref
 	}
 
 	dcpFeedParams := NewDCPFeedParams()
+	dcpFeedParams.NoValue = f.noValue
 
 	flags := memd.DcpOpenFlagProducer
 	if dcpFeedParams.IncludeXAttrs {
@@ -67,64 +70,27 @@ func NewDCPFeedParams() *DCPFeedParams {
 }
 
 func (f *GocbcoreDCPFeed) setupDCPAgentConfig(authMech interface{}, collections bool, ref *metadata.RemoteClusterReference) (*gocbcore.DCPAgentConfig, bool, error) {
-	useTLS, x509Provider, auth, err := getAgentConfigs(authMech, ref)
+	securityConfig, err := getSecurityConfig(authMech, ref)
 	if err != nil {
 		return nil, false, err
 	}
-	if auth == nil {
-		panic("Nil auth")
-	}
 	return &gocbcore.DCPAgentConfig{
-		UserAgent:  f.Name,
-		BucketName: f.BucketName,
-		SecurityConfig: gocbcore.SecurityConfig{
-			UseTLS:            useTLS,
-			TLSRootCAProvider: x509Provider,
-			Auth:              auth,
-			AuthMechanisms:    base.ScramShaAuth,
-		},
+		UserAgent:      f.Name,
+		BucketName:     f.BucketName,
+		SecurityConfig: securityConfig,
 		KVConfig: gocbcore.KVConfig{
 			ConnectTimeout: f.SetupTimeout,
 		},
 		CompressionConfig: gocbcore.CompressionConfig{Enabled: true},
 		IoConfig:          gocbcore.IoConfig{UseCollections: collections},
 		HTTPConfig:        gocbcore.HTTPConfig{ConnectTimeout: f.SetupTimeout},
-	}, useTLS, nil
+		DCPConfig:         gocbcore.DCPConfig{BufferSize: f.bufferSize},
+	}, securityConfig.UseTLS, nil
 }
 
-func getAgentConfigs(authMech interface{}, ref *metadata.RemoteClusterReference) (bool, func() *x509.CertPool, gocbcore.AuthProvider, error) {
-	certPool := x509.NewCertPool()
-	var useTLS bool
-
-	if ref.HttpAuthMech() == xdcrBase.HttpAuthMechHttps {
-		// https means we need to at a min return a root CA
-		ok := certPool.AppendCertsFromPEM(ref.Certificates())
-		if !ok {
-			return false, nil, nil, fmt.Errorf("Invalid rootCA %s", ref.Certificates())
-		}
-		useTLS = true
-	}
-	x509Provider := func() *x509.CertPool {
-		return certPool
-	}
-
-	var auth gocbcore.AuthProvider
-	if pw, ok := authMech.(*base.PasswordAuth); ok {
-		auth = gocbcore.PasswordAuthProvider{
-			Username: pw.Username,
-			Password: pw.Password,
-		}
-	} else if cert, ok := authMech.(*base.CertificateAuth); ok {
-		// The base.CertificateAuth should implement the methods for a provider
-		auth = cert
-		ok = certPool.AppendCertsFromPEM(cert.CertificateBytes)
-		if !ok {
-			return useTLS, nil, nil, fmt.Errorf("Invalid client cert %s", cert.CertificateBytes)
-		}
-	} else {
-		panic(fmt.Sprintf("unknown authmech: %v", reflect.TypeOf(authMech)))
-	}
-	return useTLS, x509Provider, auth, nil
+// The cluster is contacted over TLS if the reference is, and is verified against the root CAs of the reference
+func getSecurityConfig(authMech interface{}, ref *metadata.RemoteClusterReference) (gocbcore.SecurityConfig, error) {
+	return base.NewSecurityConfig(ref.HttpAuthMech() == xdcrBase.HttpAuthMechHttps, ref.Certificates(), authMech)
 }
 
 func (f *GocbcoreDCPFeed) setupGocbcoreDCPAgent(config *gocbcore.DCPAgentConfig, flags memd.DcpOpenFlag, secure bool) (err error) {
@@ -163,7 +129,7 @@ func (f *GocbcoreDCPFeed) setupGocbcoreDCPAgent(config *gocbcore.DCPAgentConfig,
 	return
 }
 
-func NewGocbcoreDCPFeed(id string, servers []string, bucketName string, auth interface{}, collections bool, ref *metadata.RemoteClusterReference) (*GocbcoreDCPFeed, error) {
+func NewGocbcoreDCPFeed(id string, servers []string, bucketName string, auth interface{}, collections bool, ref *metadata.RemoteClusterReference, bufferSize int, noValue bool) (*GocbcoreDCPFeed, error) {
 	gocbcoreDcpFeed := &GocbcoreDCPFeed{
 		GocbcoreAgentCommon: base.GocbcoreAgentCommon{
 			Name:         id,
@@ -171,7 +137,9 @@ func NewGocbcoreDCPFeed(id string, servers []string, bucketName string, auth int
 			BucketName:   bucketName,
 			SetupTimeout: time.Duration(base.SetupTimeoutSeconds) * time.Second,
 		},
-		dcpAgent: nil,
+		dcpAgent:   nil,
+		bufferSize: bufferSize,
+		noValue:    noValue,
 	}
 
 	if auth == nil {
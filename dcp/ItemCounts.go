@@ -0,0 +1,60 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package dcp
+
+import (
+	"github.com/couchbase/xdcrDiffer/base"
+	"github.com/couchbase/xdcrDiffer/utils"
+)
+
+// Number of items, excluding tombstones, as reported by KV stats
+type ItemCounts struct {
+	Vbuckets map[uint16]uint64
+	// nil if the bucket does not support collections
+	Collections map[uint32]uint64
+}
+
+// Connects to the cluster and retrieves the item counts of every vbucket and collection without streaming anything
+func (d *DcpDriver) GetItemCounts() (*ItemCounts, error) {
+	cm := d.checkpointManager
+	closeFunc, err := d.connectForInspection()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFunc()
+
+	statsMap, err := cm.getStatsWithRetry(base.VbucketDetailsStatName)
+	if err != nil {
+		return nil, err
+	}
+	itemCounts := &ItemCounts{
+		Vbuckets: make(map[uint16]uint64),
+	}
+	err = utils.ParseVbucketItemCountStat(statsMap, itemCounts.Vbuckets, int(d.numberOfVbuckets))
+	if err != nil {
+		return nil, err
+	}
+
+	if !d.capabilities.HasCollectionSupport() {
+		return itemCounts, nil
+	}
+
+	statsMap, err = cm.getStatsWithRetry(base.CollectionsStatName)
+	if err != nil {
+		return nil, err
+	}
+	itemCounts.Collections = make(map[uint32]uint64)
+	err = utils.ParseCollectionItemCountStat(statsMap, itemCounts.Collections)
+	if err != nil {
+		return nil, err
+	}
+	cm.logger.Infof("%v item counts retrieved for %v vbuckets and %v collections\n", d.Name, len(itemCounts.Vbuckets), len(itemCounts.Collections))
+	return itemCounts, nil
+}
@@ -1,9 +1,7 @@
 package differ
 
 import (
-	"crypto/x509"
 	"fmt"
-	"reflect"
 	"time"
 
 	"github.com/couchbase/gocbcore/v10"
@@ -35,50 +33,20 @@ func (a *GocbcoreAgent) setupAgent(auth interface{}, batchSize int, capability m
 }
 
 func (a *GocbcoreAgent) setupAgentConfig(authIn interface{}, capability metadata.Capability, batchSize int, reference *metadata.RemoteClusterReference) (*gocbcore.AgentConfig, error) {
-	var auth gocbcore.AuthProvider
-	var useTLS bool
-	certPool := x509.NewCertPool()
-
 	if authIn == nil {
 		panic("authIn is nil")
 	}
 
-	if reference.HttpAuthMech() == xdcrBase.HttpAuthMechHttps {
-		useTLS = true
-		ok := certPool.AppendCertsFromPEM(reference.Certificates())
-		if !ok {
-			return nil, fmt.Errorf("Invalid rootCA from gocbcoreagent")
-		}
-	}
-	x509Provider := func() *x509.CertPool {
-		return certPool
-	}
-
-	if pwAuth, ok := authIn.(*base.PasswordAuth); ok {
-		auth = gocbcore.PasswordAuthProvider{
-			Username: pwAuth.Username,
-			Password: pwAuth.Password,
-		}
-	} else if certAuth, ok := authIn.(*base.CertificateAuth); ok {
-		auth = certAuth
-		ok = certPool.AppendCertsFromPEM(certAuth.CertificateBytes)
-		if !ok {
-			return nil, fmt.Errorf("setupAgent invalid clientCert %s", certAuth.CertificateBytes)
-		}
-	} else {
-		panic(fmt.Sprintf("Unknown type: %v\n", reflect.TypeOf(authIn)))
+	securityConfig, err := base.NewSecurityConfig(reference.HttpAuthMech() == xdcrBase.HttpAuthMechHttps, reference.Certificates(), authIn)
+	if err != nil {
+		return nil, fmt.Errorf("setupAgentConfig - %v", err)
 	}
 
 	return &gocbcore.AgentConfig{
-		SeedConfig: gocbcore.SeedConfig{MemdAddrs: a.Servers},
-		BucketName: a.BucketName,
-		UserAgent:  a.Name,
-		SecurityConfig: gocbcore.SecurityConfig{
-			UseTLS:            useTLS,
-			TLSRootCAProvider: x509Provider,
-			Auth:              auth,
-			AuthMechanisms:    base.ScramShaAuth,
-		},
+		SeedConfig:     gocbcore.SeedConfig{MemdAddrs: a.Servers},
+		BucketName:     a.BucketName,
+		UserAgent:      a.Name,
+		SecurityConfig: securityConfig,
 		KVConfig: gocbcore.KVConfig{
 			ConnectTimeout: a.SetupTimeout,
 			MaxQueueSize:   batchSize * 50, // Give SDK some breathing room
@@ -161,6 +129,24 @@ func (a *GocbcoreAgent) GetHlv(key string, callbackFunc func(result *gocbcore.Lo
 				Path:  xdcrBase.XATTR_PREVIOUSREV,
 				Value: nil,
 			},
+			{
+				Op:    memd.SubDocOpType(memd.CmdSubDocGet),
+				Flags: memd.SubdocFlag(xdcrBase.SUBDOC_FLAG_XATTR),
+				Path:  base.XattrPreImportCasPath,
+				Value: nil,
+			},
+			{
+				Op:    memd.SubDocOpType(memd.CmdSubDocGet),
+				Flags: memd.SubdocFlag(xdcrBase.SUBDOC_FLAG_XATTR),
+				Path:  base.XattrSyncRevPath,
+				Value: nil,
+			},
+			{
+				Op:    memd.SubDocOpType(memd.CmdSubDocGet),
+				Flags: memd.SubdocFlag(xdcrBase.SUBDOC_FLAG_XATTR),
+				Path:  base.XattrSyncCasPath,
+				Value: nil,
+			},
 		},
 		RetryStrategy: nil,
 		CollectionID:  colId,
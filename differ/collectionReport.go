@@ -0,0 +1,61 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// The entries a CollectionReport keeps for one source collection
+type collectionEntries[E any] interface {
+	*E
+	merge(other *E)
+	sort()
+}
+
+// A report of mismatched documents, keyed by source collection ID
+type CollectionReport[E any, PE collectionEntries[E]] map[uint32]*E
+
+// Returns the entries of the collection, adding them if there are none yet
+func (r CollectionReport[E, PE]) get(srcColId uint32) *E {
+	entries, exists := r[srcColId]
+	if !exists {
+		entries = new(E)
+		r[srcColId] = entries
+	}
+	return entries
+}
+
+func (r CollectionReport[E, PE]) Merge(other CollectionReport[E, PE]) {
+	for srcColId, entries := range other {
+		PE(r.get(srcColId)).merge(entries)
+	}
+}
+
+// Returns the entries of all collections together, to count them by
+func (r CollectionReport[E, PE]) Total() *E {
+	total := new(E)
+	for _, entries := range r {
+		PE(total).merge(entries)
+	}
+	return total
+}
+
+func (r CollectionReport[E, PE]) Write(fileName string) error {
+	for _, entries := range r {
+		PE(entries).sort()
+	}
+	bytes, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, bytes, 0644)
+}
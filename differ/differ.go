@@ -10,7 +10,6 @@
 package differ
 
 import (
-	"crypto/sha512"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
@@ -20,12 +19,14 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/couchbase/gomemcached"
 	xdcrBase "github.com/couchbase/goxdcr/v8/base"
 	crMeta "github.com/couchbase/goxdcr/v8/crMeta"
 	hlv "github.com/couchbase/goxdcr/v8/hlv"
 	xdcrLog "github.com/couchbase/goxdcr/v8/log"
+	"github.com/couchbase/xdcrDiffer/base"
 	fdp "github.com/couchbase/xdcrDiffer/fileDescriptorPool"
 	"github.com/couchbase/xdcrDiffer/utils"
 )
@@ -46,6 +47,9 @@ type FilesDiffer struct {
 	MissingFromFile1     []*oneEntry
 	MissingFromFile2     []*oneEntry
 	BothExistButMismatch []*entryPair
+	// Only populated with the report tombstone policy
+	TombstoneOnlyInFile1 []*tombstoneEntry
+	TombstoneOnlyInFile2 []*tombstoneEntry
 
 	fdPool *fdp.FdPool
 
@@ -55,10 +59,42 @@ type FilesDiffer struct {
 
 	file1ItemCount int
 	file2ItemCount int
+	// tombstones that are not reported as missing because of the tombstone policy
+	file1OnlyTombstoneCount int
+	file2OnlyTombstoneCount int
 
 	// For 1->N,  it is possible for doc is mapped to multiple filter IDs
 	duplicatedHintMap DuplicatedHintMap
 	logger            *xdcrLog.CommonLogger
+
+	// In migration mode, the source documents that pass each filter, and the live documents of each target collection
+	filterMatchedCount []int
+	file2ColItemCount  map[uint32]int
+	// source documents whose latest version passes no filter
+	filterUnmatchedCount int
+
+	// If set, only documents modified within the window on either side are reported
+	casWindow *base.CasWindow
+	// How a tombstone in one file for a document that is absent from the other file is reported. Strict if empty
+	tombstonePolicy string
+	// Documents that expire before this long after the files were captured are not reported as missing. 0 disables it
+	expiryGrace time.Duration
+	// When the files were last written to
+	captureTime time.Time
+	// documents that are not reported because they are expiring
+	expiringCount int
+	// documents whose expiry differs between the two files
+	TtlDrift TtlDriftReport
+	// Decides which versions are the same, and which side wins a mismatch. Empty if unknown
+	conflictResolutionType string
+	// the side XDCR would keep for each mismatch
+	Winners WinnerReport
+	// how the HLVs of each mismatch relate
+	HlvCausality HlvCausalityReport
+	// Explain mismatches by the Sync Gateway metadata of the documents
+	mobileMode bool
+	// mismatches explained by Sync Gateway metadata
+	Mobile MobileReport
 }
 
 type DuplicatedHintMap map[string][]uint8
@@ -96,10 +132,13 @@ func (d DuplicatedHintMap) ToIntMap() map[string][]int {
 type FileAttributes struct {
 	name          string
 	actorId       hlv.DocumentSourceId
+	hashAlgorithm base.HashAlgorithm
 	entries       map[uint32]map[string]*oneEntry
 	sortedEntries map[uint32][]*oneEntry
 	readOp        fdp.FileOp
 	closeOp       func() error
+	// Tombstones up to this seqno had been purged from the vbucket when it was streamed. 0 if unknown
+	purgeSeqno uint64
 }
 
 func NewFileAttribute(fileName string) *FileAttributes {
@@ -118,10 +157,13 @@ type oneEntry struct {
 	Seqno             uint64
 	Xattr             []byte
 	XattrSize         uint32
-	BodyHash          [sha512.Size]byte
+	BodyHash          []byte // nil if the body was not streamed
+	HashAlgorithm     base.HashAlgorithm
 	ColId             uint32
 	ColMigrFilterLen  uint8
 	ColFiltersMatched []uint8
+	// Sync Gateway metadata, zero if the document has none
+	Mobile base.MobileMeta
 }
 
 func (oneEntry *oneEntry) String() string {
@@ -133,22 +175,41 @@ func (oneEntry *oneEntry) String() string {
 		panic(fmt.Sprintf("Coding Error: docMeta can never be nil. entry for document %s has docMeta to be nil", oneEntry.Key))
 	}
 	return fmt.Sprintf("<Key>: %v <Seqno>: %v <RevId>: %v <Cas>: %v <Flags>: %v <Expiry>: %v <OpCode>: %v <DataType>: %v <Hash>: %s <colId>: %v",
-		oneEntry.Key, oneEntry.Seqno, docMeta.RevSeq, docMeta.Cas, docMeta.Flags, docMeta.Expiry, docMeta.Opcode, docMeta.DataType, hex.EncodeToString(oneEntry.BodyHash[:]), oneEntry.ColId)
+		oneEntry.Key, oneEntry.Seqno, docMeta.RevSeq, docMeta.Cas, docMeta.Flags, docMeta.Expiry, docMeta.Opcode, docMeta.DataType, oneEntry.bodyHashString(), oneEntry.ColId)
+}
+
+func (oneEntry *oneEntry) bodyHashString() string {
+	if oneEntry.BodyHash == nil {
+		return "absent"
+	}
+	return fmt.Sprintf("%v:%v", oneEntry.HashAlgorithm, hex.EncodeToString(oneEntry.BodyHash))
 }
 
 type entryPair [2]*oneEntry
 
-type ByKeyName []*oneEntry
+// A tombstone in one file for a document that is absent from the other file
+type tombstoneEntry struct {
+	*oneEntry
+	// Tombstones up to this seqno had been purged from the vbucket on the other side. 0 if unknown
+	OtherSidePurgeSeqno uint64
+}
 
-func shaCompare(a, b [sha512.Size]byte) bool {
-	for i := 0; i < sha512.Size; i++ {
-		if a[i] != b[i] {
-			return false
-		}
+func newTombstoneEntry(entry *oneEntry, otherSidePurgeSeqno uint64) *tombstoneEntry {
+	return &tombstoneEntry{
+		oneEntry:            entry,
+		OtherSidePurgeSeqno: otherSidePurgeSeqno,
 	}
-	return true
 }
 
+func (t *tombstoneEntry) String() string {
+	if t.OtherSidePurgeSeqno > 0 {
+		return fmt.Sprintf("%v <other side purged up to seqno %v>", t.oneEntry.String(), t.OtherSidePurgeSeqno)
+	}
+	return t.oneEntry.String()
+}
+
+type ByKeyName []*oneEntry
+
 // Note Expiry is not used for conflict resolution
 // Returns a boolean to showcase if the values all match
 // For int return val:
@@ -175,13 +236,50 @@ func (entry oneEntry) Diff(other oneEntry) (int, bool) {
 	}
 	match, err = entry.CrMeta.Diff(other.CrMeta, xdcrBase.GetHLVPruneFunction(entry.CrMeta.GetDocumentMetadata().Cas, sourcePruningWindow.get()), xdcrBase.GetHLVPruneFunction(other.CrMeta.GetDocumentMetadata().Cas, targetPruningWindow.get()))
 	if err != nil { // error is returned by the Diff method only if either of the HLVs are nil
-		if entry.CrMeta.GetHLV() == nil && other.CrMeta.GetHLV() == nil { // if both the HLVs are nil return true
-			return 0, true
+		if entry.CrMeta.GetHLV() == nil && other.CrMeta.GetHLV() == nil { // if both the HLVs are nil the metadata matches
+			match = true
 		} else { // if only one them if nil => its a programming error(should not happen)
 			panic(fmt.Sprintf("Programming error - found one of HLVs to be nil. SourceHlv: %v, TargetHlv: %v", entry.CrMeta.GetHLV(), other.CrMeta.GetHLV()))
 		}
 	}
-	return 0, match
+	return 0, match && entry.sameBody(&other)
+}
+
+// Bodies can only be compared if both sides streamed them and digested them the same way, otherwise they are taken to be the same
+func (entry *oneEntry) sameBody(other *oneEntry) bool {
+	if entry.BodyHash == nil || other.BodyHash == nil || entry.HashAlgorithm != other.HashAlgorithm {
+		return true
+	}
+	return entry.HashAlgorithm.Equal(entry.BodyHash, other.BodyHash)
+}
+
+// Under revId based conflict resolution, versions are compared by their revId rather than their CAS or HLV
+func (entry oneEntry) DiffUnderCrType(other oneEntry, crType string) (int, bool) {
+	if crType != base.ConflictResolutionSeqno {
+		return entry.Diff(other)
+	}
+	if entry.Key != other.Key {
+		if entry.Key > other.Key {
+			return 1, false
+		} else {
+			return -1, false
+		}
+	}
+
+	return 0, entry.crVersion(nil).SameUnderSeqnoCR(other.crVersion(nil)) && entry.sameBody(&other)
+}
+
+// vector is the HLV of the entry as pruned by its bucket, which only custom conflict resolution looks at
+func (entry *oneEntry) crVersion(vector *base.VersionVector) base.CrVersion {
+	docMeta := entry.CrMeta.GetDocumentMetadata()
+	return base.CrVersion{
+		Cas:      docMeta.Cas,
+		RevSeqno: docMeta.RevSeq,
+		Expiry:   docMeta.Expiry,
+		Flags:    docMeta.Flags,
+		Deleted:  entry.IsTombstone(),
+		Hlv:      vector,
+	}
 }
 
 func SetHlv(crMeta1, crMeta2 *crMeta.CRMetadata, bucketUUID1, bucketUUID2 hlv.DocumentSourceId) error {
@@ -209,10 +307,24 @@ func SetHlv(crMeta1, crMeta2 *crMeta.CRMetadata, bucketUUID1, bucketUUID2 hlv.Do
 	return nil
 }
 
+// Returns the document CAS and, if the document has a HLV, its cvCas
+func (entry *oneEntry) getCasList() []uint64 {
+	casList := []uint64{entry.CrMeta.GetDocumentMetadata().Cas}
+	if entryHlv := entry.CrMeta.GetHLV(); entryHlv != nil {
+		casList = append(casList, entryHlv.GetCvCas())
+	}
+	return casList
+}
+
 func (entry *oneEntry) IsMutation() bool {
 	return entry.CrMeta.GetDocumentMetadata().Opcode == gomemcached.UPR_MUTATION
 }
 
+func (entry *oneEntry) IsTombstone() bool {
+	opcode := entry.CrMeta.GetDocumentMetadata().Opcode
+	return opcode == gomemcached.UPR_DELETION || opcode == gomemcached.UPR_EXPIRATION
+}
+
 func (srcEntry *oneEntry) MapsToTargetCol(tgtColId uint32, colFilterTgtIds []uint32, currentTgtFileColId uint32) bool {
 	for _, oneMatchedFilterIdx := range srcEntry.ColFiltersMatched {
 		// Each matched entry represents a target collection ID that is supposed to be replicated
@@ -240,6 +352,10 @@ func NewFilesDiffer(file1, file2 string, collectionMapping map[uint32][]uint32,
 		colFilterTgtIds:     colFilterTgtIds,
 		duplicatedHintMap:   map[string][]uint8{},
 		logger:              logger,
+		TtlDrift:            make(TtlDriftReport),
+		Winners:             make(WinnerReport),
+		HlvCausality:        make(HlvCausalityReport),
+		Mobile:              make(MobileReport),
 	}
 	if len(collectionMapping) == 0 {
 		// This means this is legacy mode - no collection support
@@ -281,11 +397,12 @@ func UpdateCrMeta(crMetadata *crMeta.CRMetadata, actorID hlv.DocumentSourceId, h
 	return err
 }
 
-func getOneEntry(readOp fdp.FileOp, actorId hlv.DocumentSourceId) (*oneEntry, error) {
+func getOneEntry(readOp fdp.FileOp, actorId hlv.DocumentSourceId, hashAlgorithm base.HashAlgorithm) (*oneEntry, error) {
 	entry := &oneEntry{}
 	docMeta := &xdcrBase.DocumentMetadata{}
 	entry.CrMeta = &crMeta.CRMetadata{}
 	entry.ActorID = actorId
+	entry.HashAlgorithm = hashAlgorithm
 	keyLenBytes := make([]byte, 2)
 	bytesRead, err := readOp(keyLenBytes)
 	if err != nil {
@@ -365,6 +482,23 @@ func getOneEntry(readOp fdp.FileOp, actorId hlv.DocumentSourceId) (*oneEntry, er
 	}
 	pRev := binary.BigEndian.Uint64(pRevIdBytes)
 
+	preImportCasBytes := make([]byte, 8)
+	bytesRead, err = readOp(preImportCasBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read preImportCasBytes, bytes read: %v, err: %v", bytesRead, err)
+	}
+	syncCasBytes := make([]byte, 8)
+	bytesRead, err = readOp(syncCasBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read syncCasBytes, bytes read: %v, err: %v", bytesRead, err)
+	}
+	entry.Mobile = base.MobileMeta{
+		Cas:          docMeta.Cas,
+		SyncCas:      binary.BigEndian.Uint64(syncCasBytes),
+		ImportCas:    entry.CrMeta.GetImportCas(),
+		PreImportCas: binary.BigEndian.Uint64(preImportCasBytes),
+	}
+
 	hlvSizebytes := make([]byte, 8)
 	bytesRead, err = readOp(hlvSizebytes)
 	if err != nil {
@@ -387,12 +521,35 @@ func getOneEntry(readOp fdp.FileOp, actorId hlv.DocumentSourceId) (*oneEntry, er
 		// if HLV is not present then it implies that importCas is not present; True docCas and RevID represent the version of the doc
 		entry.CrMeta.SetHLV(nil)
 	}
-	hashBytes := make([]byte, sha512.Size)
-	bytesRead, err = readOp(hashBytes)
+
+	syncRevLenBytes := make([]byte, 2)
+	bytesRead, err = readOp(syncRevLenBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read syncRevLenBytes, bytes read: %v, err: %v", bytesRead, err)
+	}
+	syncRevBytes := make([]byte, binary.BigEndian.Uint16(syncRevLenBytes))
+	bytesRead, err = readOp(syncRevBytes)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to read hashBytes, bytes read: %v, err: %v", bytesRead, err)
+		return nil, fmt.Errorf("Unable to read syncRev, bytes read: %v, err: %v", bytesRead, err)
+	}
+	entry.Mobile.SyncRev = string(syncRevBytes)
+
+	hashLenBytes := make([]byte, 2)
+	bytesRead, err = readOp(hashLenBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read hashLenBytes, bytes read: %v, err: %v", bytesRead, err)
+	}
+	hashLen := binary.BigEndian.Uint16(hashLenBytes)
+	if hashLen != 0 && int(hashLen) != hashAlgorithm.Size() {
+		return nil, fmt.Errorf("Invalid hash length %v for document %s with hash algorithm %v", hashLen, entry.Key, hashAlgorithm)
+	}
+	if hashLen > 0 {
+		entry.BodyHash = make([]byte, hashLen)
+		bytesRead, err = readOp(entry.BodyHash)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read hashBytes, bytes read: %v, err: %v", bytesRead, err)
+		}
 	}
-	copy(entry.BodyHash[:], hashBytes)
 
 	collectionIdBytes := make([]byte, 4)
 	bytesRead, err = readOp(collectionIdBytes)
@@ -425,11 +582,33 @@ func (a ByKeyName) Len() int           { return len(a) }
 func (a ByKeyName) Swap(i, j int)      { *a[i], *a[j] = *a[j], *a[i] }
 func (a ByKeyName) Less(i, j int) bool { return a[i].Key < a[j].Key }
 
+// Reads the header that starts every non-empty file
+// Returns false if the file is empty
+func (attr *FileAttributes) readHeader() (bool, error) {
+	header := make([]byte, base.MutationFileHeaderSize)
+	bytesRead, err := attr.readOp(header)
+	if err != nil {
+		if bytesRead == 0 && strings.Contains(err.Error(), io.EOF.Error()) {
+			return false, nil
+		}
+		return false, fmt.Errorf("Unable to read file header, bytes read: %v, err: %v", bytesRead, err)
+	}
+	attr.hashAlgorithm, err = base.DecodeMutationFileHeader(header[:bytesRead])
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (attr *FileAttributes) fillAndDedupEntries() error {
-	var err error
+	hasContent, err := attr.readHeader()
+	if err != nil || !hasContent {
+		return err
+	}
+
 	var entry *oneEntry
 	for {
-		entry, err = getOneEntry(attr.readOp, attr.actorId)
+		entry, err = getOneEntry(attr.readOp, attr.actorId, attr.hashAlgorithm)
 		if err != nil {
 			break
 		}
@@ -526,8 +705,12 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 				item2 := differ.file2.sortedEntries[tgtColId][j]
 				differ.addMigrationHintIfNeeded(colMigrationMode, item1, migrationHintMap)
 
-				keyCompare, match := item1.Diff(*item2)
+				keyCompare, match := item1.DiffUnderCrType(*item2, differ.conflictResolutionType)
 				validComparison := !colMigrationMode || item1.MapsToTargetCol(item2.ColId, differ.colFilterTgtIds, tgtColId) && item1.IsMutation() && item2.IsMutation()
+				if keyCompare == 0 && validComparison {
+					// expiry does not take part in the comparison, so drift is checked whether or not the documents match
+					differ.checkTtlDrift(srcColId, item1, item2)
+				}
 				if match {
 					// Both items are the same
 					i++
@@ -535,11 +718,16 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 				} else {
 					if keyCompare == 0 {
 						// Both document are the same, but others mismatched
-						if validComparison {
+						if validComparison && differ.isInCasWindow(item1, item2) && !differ.isExpiredOnOneSide(item1, item2) && !differ.isImportOnly(srcColId, item1, item2) {
 							var onePair entryPair
 							onePair[0] = item1
 							onePair[1] = item2
 							differ.BothExistButMismatch = append(differ.BothExistButMismatch, &onePair)
+							// both reports compare the same pruned HLVs, so that they agree on which side dominates
+							sourceHlv := newVersionVector(item1.CrMeta.GetHLV(), item1.CrMeta.GetDocumentMetadata().Cas, sourcePruningWindow.get())
+							targetHlv := newVersionVector(item2.CrMeta.GetHLV(), item2.CrMeta.GetDocumentMetadata().Cas, targetPruningWindow.get())
+							differ.Winners.get(srcColId).add(item1.Key, base.ResolveConflict(differ.conflictResolutionType, item1.crVersion(sourceHlv), item2.crVersion(targetHlv)))
+							differ.HlvCausality.get(srcColId).add(item1.Key, sourceHlv, targetHlv)
 							diffKeys = append(diffKeys, item1.Key)
 							addToSrcDiffMapIfNotAdded(srcDedupMap, item1.Key, srcDiffMap, srcColId)
 							tgtDiffMap[tgtColId] = append(tgtDiffMap[tgtColId], item1.Key)
@@ -548,7 +736,7 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 						j++
 					} else if keyCompare < 0 {
 						// Like "a" < "b", where a is 1 and b is 2
-						if validComparison {
+						if validComparison && differ.isInCasWindow(item1) && differ.reportAsMissing(item1, true) {
 							differ.MissingFromFile2 = append(differ.MissingFromFile2, item1)
 							diffKeys = append(diffKeys, item1.Key)
 							addToSrcDiffMapIfNotAdded(srcDedupMap, item1.Key, srcDiffMap, srcColId)
@@ -557,7 +745,7 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 						i++
 					} else {
 						// "b" > "a", leading to keyCompare > 0
-						if validComparison {
+						if validComparison && differ.isInCasWindow(item2) && differ.reportAsMissing(item2, false) {
 							differ.MissingFromFile1 = append(differ.MissingFromFile1, item2)
 							diffKeys = append(diffKeys, item2.Key)
 							addToSrcDiffMapIfNotAdded(srcDedupMap, item2.Key, srcDiffMap, srcColId)
@@ -573,7 +761,7 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 				item1 := differ.file1.sortedEntries[srcColId][i]
 				differ.addMigrationHintIfNeeded(colMigrationMode, item1, migrationHintMap)
 				validComparison := !colMigrationMode || item1.MapsToTargetCol(tgtColId, differ.colFilterTgtIds, tgtColId) && item1.IsMutation()
-				if validComparison {
+				if validComparison && differ.isInCasWindow(item1) && differ.reportAsMissing(item1, true) {
 					differ.MissingFromFile2 = append(differ.MissingFromFile2, item1)
 					addToSrcDiffMapIfNotAdded(srcDedupMap, item1.Key, srcDiffMap, srcColId)
 				}
@@ -585,8 +773,12 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 			if !colMigrationMode {
 				for ; j < file2Len; j++ {
 					// This means that all the rest of the entries in file2 are missing from file1
-					differ.MissingFromFile1 = append(differ.MissingFromFile1, differ.file2.sortedEntries[tgtColId][j])
-					tgtDiffMap[tgtColId] = append(tgtDiffMap[tgtColId], differ.file2.sortedEntries[tgtColId][j].Key)
+					item2 := differ.file2.sortedEntries[tgtColId][j]
+					if !differ.isInCasWindow(item2) || !differ.reportAsMissing(item2, false) {
+						continue
+					}
+					differ.MissingFromFile1 = append(differ.MissingFromFile1, item2)
+					tgtDiffMap[tgtColId] = append(tgtDiffMap[tgtColId], item2.Key)
 				}
 			}
 		}
@@ -594,6 +786,111 @@ func (differ *FilesDiffer) diffSorted() (map[uint32][]string, map[uint32][]strin
 	return srcDiffMap, tgtDiffMap, migrationHintMap
 }
 
+// Applies the expiry grace window and the tombstone policy to an entry that is absent from the other file
+// Returns true if the entry is to be reported as missing
+func (differ *FilesDiffer) reportAsMissing(entry *oneEntry, fromFile1 bool) bool {
+	if entry.IsMutation() && differ.isExpiring(entry) {
+		// the other side may have been captured after it expired
+		differ.expiringCount++
+		return false
+	}
+	if !entry.IsTombstone() {
+		return true
+	}
+	switch differ.tombstonePolicy {
+	case base.TombstonePolicyIgnore, base.TombstonePolicyReport:
+	default:
+		return true
+	}
+
+	if fromFile1 {
+		differ.file1OnlyTombstoneCount++
+	} else {
+		differ.file2OnlyTombstoneCount++
+	}
+	if differ.tombstonePolicy == base.TombstonePolicyReport {
+		if fromFile1 {
+			differ.TombstoneOnlyInFile1 = append(differ.TombstoneOnlyInFile1, newTombstoneEntry(entry, differ.file2.purgeSeqno))
+		} else {
+			differ.TombstoneOnlyInFile2 = append(differ.TombstoneOnlyInFile2, newTombstoneEntry(entry, differ.file1.purgeSeqno))
+		}
+	}
+	return false
+}
+
+func (differ *FilesDiffer) isExpiring(entry *oneEntry) bool {
+	expiry := entry.CrMeta.GetDocumentMetadata().Expiry
+	if differ.expiryGrace == 0 || expiry == 0 {
+		return false
+	}
+	return int64(expiry) <= differ.captureTime.Add(differ.expiryGrace).Unix()
+}
+
+// A live document that is expiring on one side and a tombstone on the other side means
+// that the document expired in between the captures of the two sides
+func (differ *FilesDiffer) isExpiredOnOneSide(item1, item2 *oneEntry) bool {
+	if item1.IsMutation() && item2.IsTombstone() && differ.isExpiring(item1) ||
+		item2.IsMutation() && item1.IsTombstone() && differ.isExpiring(item2) {
+		differ.expiringCount++
+		return true
+	}
+	return false
+}
+
+// In mobile mode, records how Sync Gateway metadata explains a mismatch
+// Returns true if the documents only differ because Sync Gateway imported them on one side
+func (differ *FilesDiffer) isImportOnly(srcColId uint32, item1, item2 *oneEntry) bool {
+	if !differ.mobileMode {
+		return false
+	}
+	divergence := base.CompareMobileMeta(item1.Mobile, item2.Mobile, item1.sameBody(item2))
+	if divergence != base.MobileDivergenceNone {
+		differ.Mobile.get(srcColId).add(item1.Key, divergence)
+	}
+	return divergence == base.MobileImportOnly
+}
+
+func (differ *FilesDiffer) checkTtlDrift(srcColId uint32, item1, item2 *oneEntry) {
+	if !item1.IsMutation() || !item2.IsMutation() || !differ.isInCasWindow(item1, item2) {
+		return
+	}
+	expiry1 := item1.CrMeta.GetDocumentMetadata().Expiry
+	expiry2 := item2.CrMeta.GetDocumentMetadata().Expiry
+	if expiry1 != expiry2 {
+		differ.TtlDrift.get(srcColId).add(item1.Key, expiry1, expiry2)
+	}
+}
+
+// The files are written to until their vbuckets have been streamed, so the later of their
+// modification times is when the documents in them were last captured
+func (differ *FilesDiffer) setCaptureTime() {
+	differ.captureTime = time.Now()
+	var latest time.Time
+	for _, fileName := range []string{differ.file1.name, differ.file2.name} {
+		fileInfo, err := os.Stat(fileName)
+		if err != nil {
+			return
+		}
+		if fileInfo.ModTime().After(latest) {
+			latest = fileInfo.ModTime()
+		}
+	}
+	differ.captureTime = latest
+}
+
+// Returns true if any of the given entries has been modified within the CAS window
+func (differ *FilesDiffer) isInCasWindow(entries ...*oneEntry) bool {
+	if differ.casWindow == nil {
+		return true
+	}
+	for _, entry := range entries {
+		if differ.casWindow.ContainsAny(entry.getCasList()...) {
+			return true
+		}
+	}
+	return false
+}
+
 func addToSrcDiffMapIfNotAdded(srcDedupMap map[string]bool, key string, srcDiffMap map[uint32][]string, srcColId uint32) {
 	if _, exists := srcDedupMap[key]; !exists {
 		srcDiffMap[srcColId] = append(srcDiffMap[srcColId], key)
@@ -620,7 +917,17 @@ func (differ *FilesDiffer) Diff() (srcDiffMap, tgtDiffMap map[uint32][]string, m
 	if differ.err2 != nil {
 		differ.logger.Errorf("Error when loading file %v contents: %v\n", differ.file2.name, differ.err2)
 	}
+	if len(differ.file1.entries) > 0 && len(differ.file2.entries) > 0 && differ.file1.hashAlgorithm != differ.file2.hashAlgorithm {
+		differ.logger.Warnf("Document bodies of %v and %v are not compared since they were digested with %v and %v respectively\n",
+			differ.file1.name, differ.file2.name, differ.file1.hashAlgorithm, differ.file2.hashAlgorithm)
+	}
 
+	if differ.expiryGrace > 0 {
+		differ.setCaptureTime()
+	}
+	if len(differ.colFilterStrings) > 0 {
+		differ.removeFilterUnmatched()
+	}
 	srcDiffMap, tgtDiffMap, migrationHintMap = differ.diffSorted()
 	diffBytes, err = differ.diffToJson()
 
@@ -632,17 +939,64 @@ func (differ *FilesDiffer) Diff() (srcDiffMap, tgtDiffMap map[uint32][]string, m
 	for _, entryMap := range differ.file2.entries {
 		differ.file2ItemCount += len(entryMap)
 	}
+	if len(differ.colFilterStrings) > 0 {
+		differ.countMigrationItems()
+	}
 	return srcDiffMap, tgtDiffMap, migrationHintMap, diffBytes, err
 }
 
+// The source documents whose latest version passes no migration filter are not replicated. They are only counted,
+// and left out of the diff
+func (differ *FilesDiffer) removeFilterUnmatched() {
+	for colId, entryMap := range differ.file1.entries {
+		for key, entry := range entryMap {
+			if len(entry.ColFiltersMatched) == 0 && entry.IsMutation() {
+				delete(entryMap, key)
+				differ.filterUnmatchedCount++
+			}
+		}
+		sortedEntries := differ.file1.sortedEntries[colId][:0]
+		for _, entry := range differ.file1.sortedEntries[colId] {
+			if _, exists := entryMap[entry.Key]; exists {
+				sortedEntries = append(sortedEntries, entry)
+			}
+		}
+		differ.file1.sortedEntries[colId] = sortedEntries
+	}
+}
+
+// Deletions are not diffed in migration mode, so only the live documents of the target collections are counted
+func (differ *FilesDiffer) countMigrationItems() {
+	differ.filterMatchedCount = make([]int, len(differ.colFilterStrings))
+	for _, entryMap := range differ.file1.entries {
+		for _, entry := range entryMap {
+			for _, filterIdx := range entry.ColFiltersMatched {
+				if int(filterIdx) < len(differ.filterMatchedCount) {
+					differ.filterMatchedCount[filterIdx]++
+				}
+			}
+		}
+	}
+	differ.file2ColItemCount = make(map[uint32]int)
+	for colId, entryMap := range differ.file2.entries {
+		for _, entry := range entryMap {
+			if entry.IsMutation() {
+				differ.file2ColItemCount[colId]++
+			}
+		}
+	}
+}
+
 func (differ *FilesDiffer) PrettyPrintResult() {
 	mismatchCnt := len(differ.BothExistButMismatch)
 	missing1Cnt := len(differ.MissingFromFile1)
 	missing2Cnt := len(differ.MissingFromFile2)
+	tombstone1Cnt := len(differ.TombstoneOnlyInFile1)
+	tombstone2Cnt := len(differ.TombstoneOnlyInFile2)
 
 	if len(differ.file1.entries) == 0 && len(differ.file2.entries) == 0 {
 		fmt.Printf("Diff tool has not been run yet\n")
-	} else if mismatchCnt == 0 && missing1Cnt == 0 && missing2Cnt == 0 {
+	} else if mismatchCnt == 0 && missing1Cnt == 0 && missing2Cnt == 0 && tombstone1Cnt == 0 && tombstone2Cnt == 0 {
 		fmt.Printf("Both sides match\n")
 	} else {
 		if mismatchCnt > 0 {
@@ -671,6 +1025,22 @@ func (differ *FilesDiffer) PrettyPrintResult() {
 			}
 			fmt.Printf("-------------------------------------------------\n")
 		}
+		if tombstone1Cnt > 0 {
+			fmt.Printf("%v Tombstones exist in %v for docs that are absent from %v:\n", tombstone1Cnt, differ.file1.name, differ.file2.name)
+			fmt.Printf("-------------------------------------------------\n")
+			for i := 0; i < tombstone1Cnt; i++ {
+				fmt.Printf("%v\n", differ.TombstoneOnlyInFile1[i].String())
+			}
+			fmt.Printf("-------------------------------------------------\n")
+		}
+		if tombstone2Cnt > 0 {
+			fmt.Printf("%v Tombstones exist in %v for docs that are absent from %v:\n", tombstone2Cnt, differ.file2.name, differ.file1.name)
+			fmt.Printf("-------------------------------------------------\n")
+			for i := 0; i < tombstone2Cnt; i++ {
+				fmt.Printf("%v\n", differ.TombstoneOnlyInFile2[i].String())
+			}
+			fmt.Printf("-------------------------------------------------\n")
+		}
 	}
 }
 
@@ -680,6 +1050,10 @@ func (differ *FilesDiffer) diffToJson() ([]byte, error) {
 		"MissingFromSource": differ.MissingFromFile1,
 		"MissingFromTarget": differ.MissingFromFile2,
 	}
+	if differ.tombstonePolicy == base.TombstonePolicyReport {
+		outputMap["TombstoneOnlyInSource"] = differ.TombstoneOnlyInFile1
+		outputMap["TombstoneOnlyInTarget"] = differ.TombstoneOnlyInFile2
+	}
 
 	ret, err := json.Marshal(outputMap)
 
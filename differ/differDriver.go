@@ -72,6 +72,18 @@ func (p *pruningWindow) set(svc service_def.BucketTopologySvc, spec *metadata.Re
 	return nil
 }
 
+func (p *pruningWindow) setDuration(duration time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.duration = duration
+}
+
+// For when there is no bucket topology service to get the pruning windows of the buckets from
+func SetVersionPruningWindow(duration time.Duration) {
+	sourcePruningWindow.setDuration(duration)
+	targetPruningWindow.setDuration(duration)
+}
+
 func (p *pruningWindow) get() time.Duration {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
@@ -114,7 +126,7 @@ func (d *DiffKeysMap) ToFetchEntries(mappings map[uint32][]uint32, migrationHint
 				tgtList, ok = mappings[srcColId]
 				if !ok {
 					if len(migrationHintMap) == 0 {
-						// Shouldn't happen
+						// Either the collection has been left out of the diff by the user, or shouldn't happen
 						continue
 					}
 				}
@@ -245,55 +257,97 @@ type DifferDriver struct {
 	specifiedSpec     *metadata.ReplicationSpecification
 	logger            *xdcrLog.CommonLogger
 	numOfVbuckets     uint16
+	casWindow         *base.CasWindow
+	vbnos             []uint16
+	tombstonePolicy   string
+	// seqnos up to which tombstones had been purged from each vbucket, from the checkpoints. nil if unknown
+	sourcePurgeSeqnos map[uint16]uint64
+	targetPurgeSeqnos map[uint16]uint64
+	// tombstones for documents absent from the other side, which are not reported as missing because of the tombstone policy
+	SourceOnlyTombstoneCount int64
+	TargetOnlyTombstoneCount int64
+	// documents that expire before this long after they were captured are not reported as missing. 0 disables it
+	expiryGrace time.Duration
+	// documents that are not reported because they are expiring
+	ExpiringCount int64
+	ttlDrift      TtlDriftReport
+	// conflict resolution type of the buckets. Empty if unknown
+	conflictResolutionType string
+	winners                WinnerReport
+	hlvCausality           HlvCausalityReport
+	// explain mismatches by the Sync Gateway metadata of the documents
+	mobileMode bool
+	mobile     MobileReport
+	// in migration mode, the source documents that pass each filter, and the live documents of each target collection
+	filterMatchedCount []int
+	tgtColItemCount    map[uint32]int
+	// in migration mode, the source documents whose latest version passes no filter
+	filterUnmatchedCount int
 }
 
-func NewDifferDriver(sourceFileDir, targetFileDir, diffFileDir, diffKeysFileName string, numberOfWorkers, numberOfBins, numberOfFds int, collectionMapping map[uint32][]uint32, colFilterStrings []string, colFilterTgtIds []uint32, sourceClusterUUID, targetClusterUUID, sourceBucketUUID, targetBucketUUID string, bucketTopologySvc service_def.BucketTopologySvc, specifiedSpec *metadata.ReplicationSpecification, logger *xdcrLog.CommonLogger, numOfVbuckets uint16) *DifferDriver {
+func NewDifferDriver(sourceFileDir, targetFileDir, diffFileDir, diffKeysFileName string, numberOfWorkers, numberOfBins, numberOfFds int, collectionMapping map[uint32][]uint32, colFilterStrings []string, colFilterTgtIds []uint32, sourceClusterUUID, targetClusterUUID, sourceBucketUUID, targetBucketUUID string, bucketTopologySvc service_def.BucketTopologySvc, specifiedSpec *metadata.ReplicationSpecification, logger *xdcrLog.CommonLogger, numOfVbuckets uint16, casWindow *base.CasWindow, vbnos []uint16, tombstonePolicy string, sourcePurgeSeqnos, targetPurgeSeqnos map[uint16]uint64, expiryGrace time.Duration, conflictResolutionType string, mobileMode bool) *DifferDriver {
 	var fdPool *fdp.FdPool
 	if numberOfFds > 0 {
 		fdPool = fdp.NewFileDescriptorPool(numberOfFds)
 	}
 
 	return &DifferDriver{
-		sourceFileDir:     sourceFileDir,
-		targetFileDir:     targetFileDir,
-		diffFileDir:       diffFileDir,
-		diffKeysFileName:  diffKeysFileName,
-		numberOfWorkers:   numberOfWorkers,
-		numberOfBins:      numberOfBins,
-		waitGroup:         &sync.WaitGroup{},
-		stateLock:         &sync.RWMutex{},
-		fileDescPool:      fdPool,
-		finChan:           make(chan bool),
-		collectionMapping: collectionMapping,
-		srcDiffKeys:       make(DiffKeysMap),
-		tgtDiffKeys:       make(DiffKeysMap),
-		colFilterStrings:  colFilterStrings,
-		colFilterTgtIds:   colFilterTgtIds,
-		srcMigrationHint:  MigrationHintMap{},
-		SrcVbItemCntMap:   make(map[uint16]int),
-		TgtVbItemCntMap:   make(map[uint16]int),
-		MapLock:           &sync.RWMutex{},
-		DuplicatedHint:    DuplicatedHintMap{},
-		sourceClusterUUID: sourceClusterUUID,
-		targetClusterUUID: targetClusterUUID,
-		sourceBucketUUID:  sourceBucketUUID,
-		targetBucketUUID:  targetBucketUUID,
-		bucketTopologySvc: bucketTopologySvc,
-		specifiedSpec:     specifiedSpec,
-		logger:            logger,
-		numOfVbuckets:     numOfVbuckets,
+		sourceFileDir:          sourceFileDir,
+		targetFileDir:          targetFileDir,
+		diffFileDir:            diffFileDir,
+		diffKeysFileName:       diffKeysFileName,
+		numberOfWorkers:        numberOfWorkers,
+		numberOfBins:           numberOfBins,
+		waitGroup:              &sync.WaitGroup{},
+		stateLock:              &sync.RWMutex{},
+		fileDescPool:           fdPool,
+		finChan:                make(chan bool),
+		collectionMapping:      collectionMapping,
+		srcDiffKeys:            make(DiffKeysMap),
+		tgtDiffKeys:            make(DiffKeysMap),
+		colFilterStrings:       colFilterStrings,
+		colFilterTgtIds:        colFilterTgtIds,
+		srcMigrationHint:       MigrationHintMap{},
+		SrcVbItemCntMap:        make(map[uint16]int),
+		TgtVbItemCntMap:        make(map[uint16]int),
+		MapLock:                &sync.RWMutex{},
+		DuplicatedHint:         DuplicatedHintMap{},
+		sourceClusterUUID:      sourceClusterUUID,
+		targetClusterUUID:      targetClusterUUID,
+		sourceBucketUUID:       sourceBucketUUID,
+		targetBucketUUID:       targetBucketUUID,
+		bucketTopologySvc:      bucketTopologySvc,
+		specifiedSpec:          specifiedSpec,
+		logger:                 logger,
+		numOfVbuckets:          numOfVbuckets,
+		casWindow:              casWindow,
+		vbnos:                  base.SelectedVbuckets(vbnos, numOfVbuckets),
+		tombstonePolicy:        tombstonePolicy,
+		sourcePurgeSeqnos:      sourcePurgeSeqnos,
+		targetPurgeSeqnos:      targetPurgeSeqnos,
+		expiryGrace:            expiryGrace,
+		ttlDrift:               make(TtlDriftReport),
+		conflictResolutionType: conflictResolutionType,
+		winners:                make(WinnerReport),
+		hlvCausality:           make(HlvCausalityReport),
+		mobileMode:             mobileMode,
+		mobile:                 make(MobileReport),
+		filterMatchedCount:     make([]int, len(colFilterStrings)),
+		tgtColItemCount:        make(map[uint32]int),
 	}
 }
 
 func (dr *DifferDriver) Run() error {
-	loadDistribution := utils.BalanceLoad(dr.numberOfWorkers, int(dr.numOfVbuckets))
-	err := sourcePruningWindow.set(dr.bucketTopologySvc, dr.specifiedSpec)
-	if err != nil {
-		return err
-	}
-	err1 := targetPruningWindow.set(dr.bucketTopologySvc, dr.specifiedSpec)
-	if err1 != nil {
-		return err1
+	loadDistribution := utils.BalanceLoad(dr.numberOfWorkers, len(dr.vbnos))
+	if dr.bucketTopologySvc != nil {
+		err := sourcePruningWindow.set(dr.bucketTopologySvc, dr.specifiedSpec)
+		if err != nil {
+			return err
+		}
+		err1 := targetPruningWindow.set(dr.bucketTopologySvc, dr.specifiedSpec)
+		if err1 != nil {
+			return err1
+		}
 	}
 	go dr.reportStatus()
 
@@ -304,7 +358,7 @@ func (dr *DifferDriver) Run() error {
 		highIndex := loadDistribution[i][1]
 		vbList := make([]uint16, highIndex-lowIndex)
 		for j := lowIndex; j < highIndex; j++ {
-			vbList[j-lowIndex] = uint16(j)
+			vbList[j-lowIndex] = dr.vbnos[j]
 		}
 
 		dr.waitGroup.Add(1)
@@ -336,6 +390,24 @@ func (dr *DifferDriver) cleanup() {
 	if err != nil {
 		fmt.Printf("Error writing srcDiff fetchList. err=%v\n", err)
 	}
+	err = dr.writeTtlDriftReport()
+	if err != nil {
+		fmt.Printf("Error writing ttl drift report. err=%v\n", err)
+	}
+	err = dr.writeWinnerReport()
+	if err != nil {
+		fmt.Printf("Error writing winner report. err=%v\n", err)
+	}
+	err = dr.writeHlvCausalityReport()
+	if err != nil {
+		fmt.Printf("Error writing hlv causality report. err=%v\n", err)
+	}
+	if dr.mobileMode {
+		err = dr.writeMobileReport()
+		if err != nil {
+			fmt.Printf("Error writing mobile report. err=%v\n", err)
+		}
+	}
 }
 
 func (dr *DifferDriver) reportStatus() {
@@ -347,7 +419,7 @@ func (dr *DifferDriver) reportStatus() {
 		case <-ticker.C:
 			vbCompleted := atomic.LoadUint32(&dr.vbCompleted)
 			fmt.Printf("%v File differ processed %v vbuckets\n", time.Now(), vbCompleted)
-			if vbCompleted == uint32(dr.numOfVbuckets) {
+			if vbCompleted == uint32(len(dr.vbnos)) {
 				return
 			}
 		case <-dr.finChan:
@@ -377,6 +449,101 @@ func (dr *DifferDriver) addTgtDiffKeys(diffKeys map[uint32][]string) {
 	}
 }
 
+func (dr *DifferDriver) addTtlDrift(ttlDrift TtlDriftReport) {
+	dr.stateLock.Lock()
+	defer dr.stateLock.Unlock()
+	dr.ttlDrift.Merge(ttlDrift)
+}
+
+func (dr *DifferDriver) writeTtlDriftReport() error {
+	dr.stateLock.RLock()
+	defer dr.stateLock.RUnlock()
+	if count := len(*dr.ttlDrift.Total()); count > 0 {
+		dr.logger.Warnf("%v documents have a different expiry on source and target\n", count)
+	}
+	return dr.ttlDrift.Write(dr.diffFileDir + base.FileDirDelimiter + base.TtlDriftReportFileName)
+}
+
+func (dr *DifferDriver) addWinners(winners WinnerReport) {
+	dr.stateLock.Lock()
+	defer dr.stateLock.Unlock()
+	dr.winners.Merge(winners)
+}
+
+func (dr *DifferDriver) writeWinnerReport() error {
+	dr.stateLock.RLock()
+	defer dr.stateLock.RUnlock()
+	if source, target, merge := dr.winners.Total().Count(); source+target+merge > 0 {
+		dr.logger.Infof("Under %v conflict resolution, XDCR would keep the source version of %v mismatched documents, the target version of %v, and merge %v\n",
+			dr.conflictResolutionType, source, target, merge)
+	}
+	return dr.winners.Write(dr.diffFileDir + base.FileDirDelimiter + base.WinnerReportFileName)
+}
+
+func (dr *DifferDriver) addHlvCausality(hlvCausality HlvCausalityReport) {
+	dr.stateLock.Lock()
+	defer dr.stateLock.Unlock()
+	dr.hlvCausality.Merge(hlvCausality)
+}
+
+func (dr *DifferDriver) writeHlvCausalityReport() error {
+	dr.stateLock.RLock()
+	defer dr.stateLock.RUnlock()
+	logHlvCausalityCounts(dr.logger, dr.hlvCausality)
+	return dr.hlvCausality.Write(dr.diffFileDir + base.FileDirDelimiter + base.HlvCausalityReportFileName)
+}
+
+func (dr *DifferDriver) addMobile(mobile MobileReport) {
+	dr.stateLock.Lock()
+	defer dr.stateLock.Unlock()
+	dr.mobile.Merge(mobile)
+}
+
+func (dr *DifferDriver) writeMobileReport() error {
+	dr.stateLock.RLock()
+	defer dr.stateLock.RUnlock()
+	logMobileCounts(dr.logger, dr.mobile)
+	return dr.mobile.Write(dr.diffFileDir + base.FileDirDelimiter + base.MobileReportFileName)
+}
+
+func (dr *DifferDriver) addMigrationCounts(filterMatchedCount []int, filterUnmatchedCount int, tgtColItemCount map[uint32]int) {
+	dr.stateLock.Lock()
+	defer dr.stateLock.Unlock()
+	dr.filterUnmatchedCount += filterUnmatchedCount
+	for i, count := range filterMatchedCount {
+		dr.filterMatchedCount[i] += count
+	}
+	for colId, count := range tgtColItemCount {
+		dr.tgtColItemCount[colId] += count
+	}
+}
+
+// Accounts for the source documents once Run has returned. nil if not in migration mode
+func (dr *DifferDriver) MigrationSummary() *base.MigrationSummary {
+	if len(dr.colFilterStrings) == 0 {
+		return nil
+	}
+	dr.stateLock.RLock()
+	defer dr.stateLock.RUnlock()
+
+	summary := &base.MigrationSummary{
+		SourceMatched:         int(atomic.LoadInt64(&dr.SourceItemCount)),
+		SourceMultipleMatched: len(dr.DuplicatedHint),
+		SourceUnmatched:       dr.filterUnmatchedCount,
+	}
+	for i, filter := range dr.colFilterStrings {
+		tgtColId := dr.colFilterTgtIds[i]
+		summary.Filters = append(summary.Filters, &base.MigrationFilterSummary{
+			Index:         i,
+			Filter:        filter,
+			TargetColId:   tgtColId,
+			SourceMatched: dr.filterMatchedCount[i],
+			TargetItems:   dr.tgtColItemCount[tgtColId],
+		})
+	}
+	return summary
+}
+
 func (dr *DifferDriver) writeDiffKeys() error {
 	dr.stateLock.RLock()
 	defer dr.stateLock.RUnlock()
@@ -506,12 +673,21 @@ func (dh *DifferHandler) run() error {
 				dh.driver.logger.Errorf("error occured while constructing the actorID from bucketUUID %v and clusterUUID %v. err %v", dh.driver.targetBucketUUID, dh.driver.targetClusterUUID, err)
 				return err
 			}
+			filesDiffer.casWindow = dh.driver.casWindow
+			filesDiffer.tombstonePolicy = dh.driver.tombstonePolicy
+			filesDiffer.file1.purgeSeqno = dh.driver.sourcePurgeSeqnos[vbno]
+			filesDiffer.file2.purgeSeqno = dh.driver.targetPurgeSeqnos[vbno]
+			filesDiffer.expiryGrace = dh.driver.expiryGrace
+			filesDiffer.conflictResolutionType = dh.driver.conflictResolutionType
+			filesDiffer.mobileMode = dh.driver.mobileMode
 			srcDiffMap, tgtDiffMap, migrationHints, diffBytes, err := filesDiffer.Diff()
 			if err != nil {
 				fmt.Printf("error getting srcDiff from file differ. err=%v\n", err)
 				continue
 			}
-			if len(srcDiffMap) > 0 || len(tgtDiffMap) > 0 {
+			// tombstones reported separately are not passed on to the mutation differ
+			hasTombstoneDiffs := len(filesDiffer.TombstoneOnlyInFile1) > 0 || len(filesDiffer.TombstoneOnlyInFile2) > 0
+			if len(srcDiffMap) > 0 || len(tgtDiffMap) > 0 || hasTombstoneDiffs {
 				if len(srcDiffMap) > 0 {
 					dh.driver.addSrcDiffKeys(srcDiffMap, migrationHints)
 				}
@@ -522,6 +698,24 @@ func (dh *DifferHandler) run() error {
 			}
 			srcVbItemCnt += filesDiffer.file1ItemCount
 			tgtVbItemCnt += filesDiffer.file2ItemCount
+			atomic.AddInt64(&dh.driver.SourceOnlyTombstoneCount, int64(filesDiffer.file1OnlyTombstoneCount))
+			atomic.AddInt64(&dh.driver.TargetOnlyTombstoneCount, int64(filesDiffer.file2OnlyTombstoneCount))
+			atomic.AddInt64(&dh.driver.ExpiringCount, int64(filesDiffer.expiringCount))
+			if len(filesDiffer.TtlDrift) > 0 {
+				dh.driver.addTtlDrift(filesDiffer.TtlDrift)
+			}
+			if len(filesDiffer.Winners) > 0 {
+				dh.driver.addWinners(filesDiffer.Winners)
+			}
+			if len(filesDiffer.HlvCausality) > 0 {
+				dh.driver.addHlvCausality(filesDiffer.HlvCausality)
+			}
+			if len(filesDiffer.Mobile) > 0 {
+				dh.driver.addMobile(filesDiffer.Mobile)
+			}
+			if filesDiffer.filterMatchedCount != nil {
+				dh.driver.addMigrationCounts(filesDiffer.filterMatchedCount, filesDiffer.filterUnmatchedCount, filesDiffer.file2ColItemCount)
+			}
 
 			dh.duplicatedHintMap.Merge(filesDiffer.duplicatedHintMap)
 		}
@@ -20,6 +20,8 @@ import (
 	"time"
 
 	"github.com/couchbase/gomemcached"
+	xdcrLog "github.com/couchbase/goxdcr/v8/log"
+	"github.com/couchbase/xdcrDiffer/base"
 	"github.com/couchbase/xdcrDiffer/dcp"
 	fdp "github.com/couchbase/xdcrDiffer/fileDescriptorPool"
 	"github.com/stretchr/testify/assert"
@@ -30,6 +32,8 @@ const MinUint = 0
 
 var randomOnce sync.Once
 
+var testLogger = xdcrLog.NewLogger("differTest", xdcrLog.DefaultLoggerContext)
+
 func randomString(l int) string {
 	bytes := make([]byte, l)
 	for i := 0; i < l; i++ {
@@ -96,11 +100,17 @@ func genTestData(regularMutation, colFilters bool) (key string, seqno, revId, ca
 		ColId:             0,
 		ColFiltersMatched: filterIds,
 	}
-	dataSlice := mutationToSerialize.Serialize()
+	// Serializing can only fail for documents with xattrs
+	dataSlice, _ := mutationToSerialize.Serialize()
 
 	return key, seqno, revId, cas, flags, expiry, opCode, hash, dataSlice, colId, filterIds
 }
 
+// Files written by the file handler start with a header
+func withFileHeader(data []byte) []byte {
+	return append(base.EncodeMutationFileHeader(base.HashAlgorithmSha512), data...)
+}
+
 func genMultipleRecords(numOfRecords int) []byte {
 	var retSlice []byte
 
@@ -113,7 +123,7 @@ func genMultipleRecords(numOfRecords int) []byte {
 }
 
 func genSameFiles(numOfRecords int, fileName1, fileName2 string) error {
-	data := genMultipleRecords(numOfRecords)
+	data := withFileHeader(genMultipleRecords(numOfRecords))
 
 	err := ioutil.WriteFile(fileName1, data, 0644)
 	if err != nil {
@@ -130,7 +140,7 @@ func genSameFiles(numOfRecords int, fileName1, fileName2 string) error {
 
 func genMismatchedFiles(numOfRecords, mismatchCnt int, fileName1, fileName2 string) ([]string, error) {
 	var mismatchedKeyNames []string
-	data := genMultipleRecords(numOfRecords - mismatchCnt)
+	data := withFileHeader(genMultipleRecords(numOfRecords - mismatchCnt))
 
 	err := ioutil.WriteFile(fileName1, data, 0644)
 	if err != nil {
@@ -171,7 +181,7 @@ func genMismatchedFiles(numOfRecords, mismatchCnt int, fileName1, fileName2 stri
 			ColId:             colId,
 			ColFiltersMatched: nil,
 		}
-		mismatchedData := mismatchedDataMut.Serialize()
+		mismatchedData, _ := mismatchedDataMut.Serialize()
 
 		_, err = f1.Write(oneData)
 		if err != nil {
@@ -212,10 +222,10 @@ func TestLoader(t *testing.T) {
 
 	key, seqno, _, _, _, _, _, _, data, _, _ := genTestData(true, false)
 
-	err := ioutil.WriteFile(outputFileTemp, data, 0644)
+	err := ioutil.WriteFile(outputFileTemp, withFileHeader(data), 0644)
 	assert.Nil(err)
 
-	differ := NewFilesDiffer(outputFileTemp, "", nil, nil, nil)
+	differ := NewFilesDiffer(outputFileTemp, "", nil, nil, nil, testLogger)
 	err = differ.file1.LoadFileIntoBuffer()
 	assert.Nil(err)
 
@@ -233,10 +243,10 @@ func TestLoaderWithColFilters(t *testing.T) {
 
 	key, _, _, _, _, _, _, _, data, _, filterIds := genTestData(true, true)
 
-	err := ioutil.WriteFile(outputFileTemp, data, 0644)
+	err := ioutil.WriteFile(outputFileTemp, withFileHeader(data), 0644)
 	assert.Nil(err)
 
-	differ := NewFilesDiffer(outputFileTemp, "", nil, nil, nil)
+	differ := NewFilesDiffer(outputFileTemp, "", nil, nil, nil, testLogger)
 	err = differ.file1.LoadFileIntoBuffer()
 	assert.Nil(err)
 
@@ -261,7 +271,7 @@ func TestLoadSameFile(t *testing.T) {
 	err := genSameFiles(entries, file1, file2)
 	assert.Equal(nil, err)
 
-	differ := NewFilesDiffer(file1, file2, nil, nil, nil)
+	differ := NewFilesDiffer(file1, file2, nil, nil, nil, testLogger)
 	assert.NotNil(differ)
 
 	srcDiffMap, tgtDiffMap, _, _, _ := differ.Diff()
@@ -289,7 +299,7 @@ func Disabled_TestLoadMismatchedFilesOnly(t *testing.T) {
 	keys, err := genMismatchedFiles(entries, numMismatch, file1, file2)
 	assert.Nil(err)
 
-	differ := NewFilesDiffer(file1, file2, nil, nil, nil)
+	differ := NewFilesDiffer(file1, file2, nil, nil, nil, testLogger)
 	assert.NotNil(differ)
 
 	srcDiffMap, tgtDiffMap, _, _, _ := differ.Diff()
@@ -333,7 +343,7 @@ func Disabled_TestLoadMismatchedFilesAndUneven(t *testing.T) {
 	assert.Nil(err)
 	f.Close()
 
-	differ := NewFilesDiffer(file1, file2, nil, nil, nil)
+	differ := NewFilesDiffer(file1, file2, nil, nil, nil, testLogger)
 	assert.NotNil(differ)
 
 	srcDiffMap, tgtDiffMap, _, _, _ := differ.Diff()
@@ -366,7 +376,7 @@ func TestLoadSameFileWPool(t *testing.T) {
 	err := genSameFiles(entries, file1, file2)
 	assert.Equal(nil, err)
 
-	differ, err := NewFilesDifferWithFDPool(file1, file2, fileDescPool, nil, nil, nil)
+	differ, err := NewFilesDifferWithFDPool(file1, file2, fileDescPool, nil, nil, nil, testLogger)
 	assert.NotNil(differ)
 	assert.Nil(err)
 
@@ -381,7 +391,7 @@ func TestNoFilePool(t *testing.T) {
 	fmt.Println("============== Test case start: TestNoFilePool =================")
 	assert := assert.New(t)
 
-	differDriver := NewDifferDriver("", "", "", "", 2, 2, 0, nil, nil, nil)
+	differDriver := NewDifferDriver("", "", "", "", 2, 2, 0, nil, nil, nil, "", "", "", "", nil, nil, testLogger, base.TraditionalNumberOfVbuckets, nil, nil, "", nil, nil, 0, "", false)
 	assert.NotNil(differDriver)
 	assert.Nil(differDriver.fileDescPool)
 	fmt.Println("============== Test case end: TestNoFilePool =================")
@@ -0,0 +1,97 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	hlv "github.com/couchbase/goxdcr/v8/hlv"
+	xdcrLog "github.com/couchbase/goxdcr/v8/log"
+	"github.com/couchbase/xdcrDiffer/base"
+)
+
+// How the HLVs of a mismatched document relate, along with the HLVs that were compared
+type HlvCausality struct {
+	Key    string
+	Class  string
+	Source *base.VersionVector `json:",omitempty"`
+	Target *base.VersionVector `json:",omitempty"`
+}
+
+type HlvCausalities []*HlvCausality
+
+type HlvCausalityReport = CollectionReport[HlvCausalities, *HlvCausalities]
+
+// The HLVs are expected to be pruned already, by newVersionVector
+func (h *HlvCausalities) add(key string, source, target *base.VersionVector) {
+	*h = append(*h, &HlvCausality{
+		Key:    key,
+		Class:  base.CompareVersionVectors(source, target).String(),
+		Source: source,
+		Target: target,
+	})
+}
+
+// Prunes the HLV by the pruning window of its bucket relative to the document CAS, as XDCR would before comparing it
+func newVersionVector(docHlv *hlv.HLV, cas uint64, pruningWindow time.Duration) *base.VersionVector {
+	if docHlv == nil {
+		return nil
+	}
+	vector := &base.VersionVector{
+		CvSrc: string(docHlv.GetCvSrc()),
+		CvVer: docHlv.GetCvVer(),
+		CvCas: docHlv.GetCvCas(),
+		Pv:    make(map[string]uint64),
+		Mv:    make(map[string]uint64),
+	}
+	for src, ver := range docHlv.GetPV() {
+		vector.Pv[string(src)] = ver
+	}
+	for src, ver := range docHlv.GetMV() {
+		vector.Mv[string(src)] = ver
+	}
+	if pruningWindow > 0 && cas > uint64(pruningWindow) {
+		vector.Prune(cas - uint64(pruningWindow))
+	}
+	return vector
+}
+
+func (h *HlvCausalities) merge(other *HlvCausalities) {
+	*h = append(*h, *other...)
+}
+
+func (h *HlvCausalities) sort() {
+	causalities := *h
+	sort.Slice(causalities, func(i, j int) bool { return causalities[i].Key < causalities[j].Key })
+}
+
+// Returns the number of documents of each class
+func (h *HlvCausalities) Count() map[string]int {
+	counts := make(map[string]int)
+	for _, causality := range *h {
+		counts[causality.Class]++
+	}
+	return counts
+}
+
+func logHlvCausalityCounts(logger *xdcrLog.CommonLogger, h HlvCausalityReport) {
+	counts := h.Total().Count()
+	if len(counts) == 0 {
+		return
+	}
+	var summary []string
+	for _, causality := range base.Causalities {
+		summary = append(summary, fmt.Sprintf("%v %v", counts[causality.String()], causality))
+	}
+	logger.Infof("HLVs of mismatched documents: %v\n", strings.Join(summary, ", "))
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"sort"
+	"time"
+)
+
+type MismatchClass int
+
+const (
+	MismatchConcurrent  MismatchClass = iota
+	MismatchTargetOlder MismatchClass = iota
+	MismatchTargetNewer MismatchClass = iota
+)
+
+func (m MismatchClass) String() string {
+	switch m {
+	case MismatchTargetOlder:
+		return "TargetOlder"
+	case MismatchTargetNewer:
+		return "TargetNewer"
+	default:
+		return "Concurrent"
+	}
+}
+
+// For each mismatched document of a collection, which side holds the newer version
+// When the target is older, the CAS difference is how far behind replication is for that document
+type CollectionLagReport struct {
+	TargetOlder []string
+	TargetNewer []string
+	Concurrent  []string
+	LagP50Ms    float64
+	LagP99Ms    float64
+	LagMaxMs    float64
+
+	// in nanoseconds, one per TargetOlder key
+	lags []uint64
+}
+
+// Keyed by source collection ID
+type LagReport map[uint32]*CollectionLagReport
+
+func (l LagReport) add(srcColId uint32, key string, class MismatchClass, lag uint64) {
+	report, exists := l[srcColId]
+	if !exists {
+		report = &CollectionLagReport{}
+		l[srcColId] = report
+	}
+
+	switch class {
+	case MismatchTargetOlder:
+		report.TargetOlder = append(report.TargetOlder, key)
+		report.lags = append(report.lags, lag)
+	case MismatchTargetNewer:
+		report.TargetNewer = append(report.TargetNewer, key)
+	default:
+		report.Concurrent = append(report.Concurrent, key)
+	}
+}
+
+func (l LagReport) Merge(other LagReport) {
+	for srcColId, otherReport := range other {
+		report, exists := l[srcColId]
+		if !exists {
+			l[srcColId] = otherReport
+			continue
+		}
+		report.TargetOlder = append(report.TargetOlder, otherReport.TargetOlder...)
+		report.TargetNewer = append(report.TargetNewer, otherReport.TargetNewer...)
+		report.Concurrent = append(report.Concurrent, otherReport.Concurrent...)
+		report.lags = append(report.lags, otherReport.lags...)
+	}
+}
+
+// Populates the lag percentiles of each collection
+func (l LagReport) computeHistogram() {
+	for _, report := range l {
+		if len(report.lags) == 0 {
+			continue
+		}
+		sort.Slice(report.lags, func(i, j int) bool { return report.lags[i] < report.lags[j] })
+		report.LagP50Ms = nanosToMillis(percentile(report.lags, 50))
+		report.LagP99Ms = nanosToMillis(percentile(report.lags, 99))
+		report.LagMaxMs = nanosToMillis(report.lags[len(report.lags)-1])
+	}
+}
+
+// Nearest-rank percentile of an already sorted list
+func percentile(sortedList []uint64, p int) uint64 {
+	rank := (p*len(sortedList) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sortedList[rank-1]
+}
+
+func nanosToMillis(nanos uint64) float64 {
+	return float64(nanos) / float64(time.Millisecond)
+}
+
+// The version of a document is normally its CAS, which is a hybrid logical clock and is preserved by XDCR
+// when replicated. If both sides have a HLV whose current version comes from the same source, the current
+// versions are comparable as-is. revId breaks the tie if the versions are the same
+// Returns the class and, if the target is older, the lag in nanoseconds
+func classifyMismatch(sourceResult, targetResult *GetResult) (MismatchClass, uint64) {
+	srcVer := uint64(sourceResult.Cas)
+	tgtVer := uint64(targetResult.Cas)
+	if sourceResult.HLV != nil && targetResult.HLV != nil && sourceResult.GetCvSrc() == targetResult.GetCvSrc() {
+		srcVer = sourceResult.GetCvVer()
+		tgtVer = targetResult.GetCvVer()
+	}
+
+	if srcVer > tgtVer {
+		return MismatchTargetOlder, srcVer - tgtVer
+	} else if srcVer < tgtVer {
+		return MismatchTargetNewer, 0
+	}
+
+	if sourceResult.SeqNo > targetResult.SeqNo {
+		return MismatchTargetOlder, 0
+	} else if sourceResult.SeqNo < targetResult.SeqNo {
+		return MismatchTargetNewer, 0
+	}
+	return MismatchConcurrent, 0
+}
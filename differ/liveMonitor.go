@@ -0,0 +1,463 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	xdcrLog "github.com/couchbase/goxdcr/v8/log"
+	"github.com/couchbase/xdcrDiffer/base"
+	"github.com/rcrowley/go-metrics"
+)
+
+// In live mode, the DCP streams stay open and every document that changes on either side is rechecked through
+// the mutation differ once it has not changed for the settle delay. The divergences that remain are written out
+// per rolling window, and the latest windows are kept on disk
+type LiveMonitor struct {
+	differ         *MutationDiffer
+	logger         *xdcrLog.CommonLogger
+	settleDelay    time.Duration
+	windowDuration time.Duration
+	windowsToKeep  int
+	metricsPort    int
+	metricsHost    string
+	outputDir      string
+
+	// collection ID -> key -> last time the key was seen changing
+	// Source changes are keyed by source collection IDs, target changes by target collection IDs
+	srcPending  map[uint32]map[string]time.Time
+	tgtPending  map[uint32]map[string]time.Time
+	pendingLock sync.Mutex
+	// number of keys in srcPending and tgtPending together, guarded by pendingLock
+	numPending int
+	// changes to keys that are not pending yet are dropped once this many keys are pending. 0 means no limit
+	maxPendingKeys int
+	// droppedKeys count when the last window was written
+	lastDropped int64
+
+	window      *liveWindow
+	windowStart time.Time
+
+	registry              metrics.Registry
+	sourceChanges         metrics.Counter
+	targetChanges         metrics.Counter
+	keysRechecked         metrics.Counter
+	windowsWritten        metrics.Counter
+	pendingKeys           metrics.Gauge
+	windowDivergences     metrics.Gauge
+	lastWindowDivergences metrics.Gauge
+	droppedKeys           metrics.Counter
+
+	server    *http.Server
+	finChan   chan bool
+	waitGroup sync.WaitGroup
+}
+
+func NewLiveMonitor(differ *MutationDiffer, logger *xdcrLog.CommonLogger, settleDelay, windowDuration time.Duration, windowsToKeep, metricsPort int, metricsHost string, maxPendingKeys int) *LiveMonitor {
+	registry := metrics.NewRegistry()
+	return &LiveMonitor{
+		differ:                differ,
+		logger:                logger,
+		settleDelay:           settleDelay,
+		windowDuration:        windowDuration,
+		windowsToKeep:         windowsToKeep,
+		metricsPort:           metricsPort,
+		metricsHost:           metricsHost,
+		maxPendingKeys:        maxPendingKeys,
+		outputDir:             differ.mutationDifferFileDir + base.FileDirDelimiter + base.LiveDiffDir,
+		srcPending:            make(map[uint32]map[string]time.Time),
+		tgtPending:            make(map[uint32]map[string]time.Time),
+		window:                newLiveWindow(),
+		registry:              registry,
+		sourceChanges:         metrics.NewRegisteredCounter("sourceChanges", registry),
+		targetChanges:         metrics.NewRegisteredCounter("targetChanges", registry),
+		keysRechecked:         metrics.NewRegisteredCounter("keysRechecked", registry),
+		windowsWritten:        metrics.NewRegisteredCounter("windowsWritten", registry),
+		pendingKeys:           metrics.NewRegisteredGauge("pendingKeys", registry),
+		windowDivergences:     metrics.NewRegisteredGauge("windowDivergences", registry),
+		lastWindowDivergences: metrics.NewRegisteredGauge("lastWindowDivergences", registry),
+		droppedKeys:           metrics.NewRegisteredCounter("pendingKeysDropped", registry),
+		finChan:               make(chan bool),
+	}
+}
+
+func (m *LiveMonitor) Start() error {
+	err := os.MkdirAll(m.outputDir, 0777)
+	if err != nil {
+		return fmt.Errorf("Error mkdir %v: %v", m.outputDir, err)
+	}
+
+	err = m.differ.initialize()
+	if err != nil {
+		return err
+	}
+
+	if m.metricsPort > 0 {
+		m.startMetricsServer()
+	}
+
+	m.windowStart = time.Now()
+	m.waitGroup.Add(1)
+	go m.run()
+
+	m.logger.Infof("Live monitor started with settle delay %v and window %v\n", m.settleDelay, m.windowDuration)
+	return nil
+}
+
+// Writes out the current window. Keys that have not settled yet are not rechecked
+func (m *LiveMonitor) Stop() {
+	close(m.finChan)
+	m.waitGroup.Wait()
+
+	m.pendingLock.Lock()
+	numPending := m.numPending
+	m.pendingLock.Unlock()
+	if numPending > 0 {
+		m.logger.Warnf("Live monitor stopping with %v keys that have not been rechecked\n", numPending)
+	}
+	if dropped := m.droppedKeys.Count(); dropped > 0 {
+		m.logger.Warnf("Live monitor dropped %v changes in total because %v keys were already pending\n", dropped, m.maxPendingKeys)
+	}
+
+	err := m.writeWindow(time.Now())
+	if err != nil {
+		m.logger.Errorf("Error writing live window. err=%v\n", err)
+	}
+
+	if m.server != nil {
+		m.server.Close()
+	}
+	m.logger.Infof("Live monitor stopped\n")
+}
+
+func (m *LiveMonitor) RecordSourceChange(colId uint32, key []byte) {
+	m.sourceChanges.Inc(1)
+	m.recordChange(m.srcPending, colId, string(key))
+}
+
+func (m *LiveMonitor) RecordTargetChange(colId uint32, key []byte) {
+	m.targetChanges.Inc(1)
+	m.recordChange(m.tgtPending, colId, string(key))
+}
+
+func (m *LiveMonitor) recordChange(pending map[uint32]map[string]time.Time, colId uint32, key string) {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	m.addPending(pending, colId, key, time.Now(), true)
+}
+
+// A key that is already pending only has its change time updated if overwrite is set, so the limit only keeps
+// new keys from being tracked. Must be called with pendingLock held
+func (m *LiveMonitor) addPending(pending map[uint32]map[string]time.Time, colId uint32, key string, changed time.Time, overwrite bool) {
+	if _, exists := pending[colId][key]; exists {
+		if overwrite {
+			pending[colId][key] = changed
+		}
+		return
+	}
+	if m.maxPendingKeys > 0 && m.numPending >= m.maxPendingKeys {
+		m.droppedKeys.Inc(1)
+		return
+	}
+	if _, exists := pending[colId]; !exists {
+		pending[colId] = make(map[string]time.Time)
+	}
+	pending[colId][key] = changed
+	m.numPending++
+}
+
+func (m *LiveMonitor) run() {
+	defer m.waitGroup.Done()
+
+	ticker := time.NewTicker(base.LiveRecheckInterval * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.finChan:
+			return
+		case <-ticker.C:
+			m.recheckSettledKeys()
+			if time.Since(m.windowStart) >= m.windowDuration {
+				m.rotateWindow()
+			}
+		}
+	}
+}
+
+// Removes and returns the keys that have not changed for the settle delay
+func (m *LiveMonitor) getSettledKeys() (DiffKeysMap, DiffKeysMap) {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	settledBefore := time.Now().Add(-m.settleDelay)
+	srcKeys := takeSettledKeys(m.srcPending, settledBefore)
+	tgtKeys := takeSettledKeys(m.tgtPending, settledBefore)
+	m.numPending -= srcKeys.GetTotalCount() + tgtKeys.GetTotalCount()
+	m.pendingKeys.Update(int64(m.numPending))
+	return srcKeys, tgtKeys
+}
+
+func takeSettledKeys(pending map[uint32]map[string]time.Time, settledBefore time.Time) DiffKeysMap {
+	settled := make(DiffKeysMap)
+	for colId, keys := range pending {
+		for key, lastChanged := range keys {
+			if lastChanged.Before(settledBefore) {
+				settled[colId] = append(settled[colId], key)
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(pending, colId)
+		}
+	}
+	return settled
+}
+
+func (m *LiveMonitor) recheckSettledKeys() {
+	srcKeys, tgtKeys := m.getSettledKeys()
+	numKeys := srcKeys.GetTotalCount() + tgtKeys.GetTotalCount()
+	if numKeys == 0 {
+		return
+	}
+
+	m.differ.diffKeys(srcKeys, tgtKeys)
+	m.keysRechecked.Inc(int64(numKeys))
+
+	m.window.forget(srcKeys, tgtKeys, m.differ.colIdsMap, m.differ.reverseTgtColIdsMap)
+	m.differ.stateLock.RLock()
+	m.window.merge(m.differ)
+	m.differ.stateLock.RUnlock()
+	m.windowDivergences.Update(int64(m.window.count()))
+}
+
+// Writes out the current window and starts a new one. Divergences that are still outstanding are rechecked
+// as part of the new window so that they keep showing up until they are resolved
+func (m *LiveMonitor) rotateWindow() {
+	windowEnd := time.Now()
+	err := m.writeWindow(windowEnd)
+	if err != nil {
+		m.logger.Errorf("Error writing live window. err=%v\n", err)
+	}
+	m.pruneWindows()
+
+	srcKeys, tgtKeys := m.window.keys()
+	m.pendingLock.Lock()
+	settled := windowEnd.Add(-m.settleDelay)
+	for colId, keys := range srcKeys {
+		for _, key := range keys {
+			m.addPending(m.srcPending, colId, key, settled, false)
+		}
+	}
+	for colId, keys := range tgtKeys {
+		for _, key := range keys {
+			m.addPending(m.tgtPending, colId, key, settled, false)
+		}
+	}
+	m.pendingLock.Unlock()
+
+	m.window = newLiveWindow()
+	m.windowStart = windowEnd
+	m.windowDivergences.Update(0)
+}
+
+func (m *LiveMonitor) writeWindow(windowEnd time.Time) error {
+	divergences := m.window.count()
+	m.logger.Infof("Live window %v - %v has %v divergences\n", m.windowStart.Format(time.RFC3339), windowEnd.Format(time.RFC3339), divergences)
+
+	windowDir := m.outputDir + base.FileDirDelimiter + m.windowStart.UTC().Format(base.LiveWindowDirTimeFormat)
+	err := os.MkdirAll(windowDir, 0777)
+	if err != nil {
+		return err
+	}
+
+	diffBytes, err := marshalDiffDetails(m.differ.compareType, m.window.srcDiff, m.window.missingFromSource, m.window.missingFromTarget,
+		m.window.deletedFromSource, m.window.deletedFromTarget)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(windowDir+base.FileDirDelimiter+base.MutationDiffFileName, diffBytes, base.FileModeReadWrite)
+	if err != nil {
+		return err
+	}
+
+	m.windowsWritten.Inc(1)
+	m.lastWindowDivergences.Update(int64(divergences))
+
+	// The dropped keys are only rechecked if they change again
+	if dropped := m.droppedKeys.Count(); dropped > m.lastDropped {
+		m.logger.Warnf("Live window %v dropped %v changes because %v keys were already pending\n", m.windowStart.Format(time.RFC3339), dropped-m.lastDropped, m.maxPendingKeys)
+		m.lastDropped = dropped
+	}
+	return nil
+}
+
+// The window directories are named after their start time so they sort chronologically
+func (m *LiveMonitor) pruneWindows() {
+	entries, err := os.ReadDir(m.outputDir)
+	if err != nil {
+		m.logger.Warnf("Unable to list %v. err=%v\n", m.outputDir, err)
+		return
+	}
+
+	var windowDirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			windowDirs = append(windowDirs, entry.Name())
+		}
+	}
+	sort.Strings(windowDirs)
+
+	for i := 0; i < len(windowDirs)-m.windowsToKeep; i++ {
+		err = os.RemoveAll(m.outputDir + base.FileDirDelimiter + windowDirs[i])
+		if err != nil {
+			m.logger.Warnf("Unable to remove live window %v. err=%v\n", windowDirs[i], err)
+		}
+	}
+}
+
+func (m *LiveMonitor) startMetricsServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc(base.LiveMetricsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		metrics.WriteJSONOnce(m.registry, w)
+	})
+	m.server = &http.Server{
+		Addr:    net.JoinHostPort(m.metricsHost, strconv.Itoa(m.metricsPort)),
+		Handler: mux,
+	}
+
+	go func() {
+		err := m.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			m.logger.Errorf("Live metrics endpoint stopped. err=%v\n", err)
+		}
+	}()
+	m.logger.Infof("Live metrics are served on %v at %v\n", m.server.Addr, base.LiveMetricsPath)
+}
+
+// The divergences found so far within a window. Only the outcome of the latest recheck of a key is kept
+type liveWindow struct {
+	missingFromSource map[uint32]map[string]*GetResult
+	missingFromTarget map[uint32]map[string]*GetResult
+	srcDiff           map[uint32]map[string][]*GetResult
+	tgtDiff           map[uint32]map[string][]*GetResult
+	deletedFromSource map[uint32]map[string][]*GetResult
+	deletedFromTarget map[uint32]map[string][]*GetResult
+}
+
+func newLiveWindow() *liveWindow {
+	return &liveWindow{
+		missingFromSource: make(map[uint32]map[string]*GetResult),
+		missingFromTarget: make(map[uint32]map[string]*GetResult),
+		srcDiff:           make(map[uint32]map[string][]*GetResult),
+		tgtDiff:           make(map[uint32]map[string][]*GetResult),
+		deletedFromSource: make(map[uint32]map[string][]*GetResult),
+		deletedFromTarget: make(map[uint32]map[string][]*GetResult),
+	}
+}
+
+// Removes the earlier outcomes of the keys that have just been rechecked
+func (w *liveWindow) forget(srcKeys, tgtKeys DiffKeysMap, colIdsMap, reverseColIdsMap map[uint32][]uint32) {
+	for srcColId, keys := range srcKeys {
+		for _, key := range keys {
+			w.forgetKey(srcColId, colIdsMap[srcColId], key)
+		}
+	}
+	for tgtColId, keys := range tgtKeys {
+		for _, key := range keys {
+			for _, srcColId := range reverseColIdsMap[tgtColId] {
+				w.forgetKey(srcColId, []uint32{tgtColId}, key)
+			}
+		}
+	}
+}
+
+func (w *liveWindow) forgetKey(srcColId uint32, tgtColIds []uint32, key string) {
+	delete(w.missingFromSource[srcColId], key)
+	delete(w.srcDiff[srcColId], key)
+	delete(w.deletedFromSource[srcColId], key)
+	delete(w.deletedFromTarget[srcColId], key)
+	for _, tgtColId := range tgtColIds {
+		delete(w.missingFromTarget[tgtColId], key)
+		delete(w.tgtDiff[tgtColId], key)
+	}
+}
+
+func (w *liveWindow) merge(d *MutationDiffer) {
+	mergeResultMap(w.missingFromSource, d.missingFromSource)
+	mergeResultMap(w.missingFromTarget, d.missingFromTarget)
+	mergeResultsMap(w.srcDiff, d.srcDiff)
+	mergeResultsMap(w.tgtDiff, d.tgtDiff)
+	mergeResultsMap(w.deletedFromSource, d.deletedFromSource)
+	mergeResultsMap(w.deletedFromTarget, d.deletedFromTarget)
+}
+
+func mergeResultMap(dst, src map[uint32]map[string]*GetResult) {
+	for colId, results := range src {
+		if _, exists := dst[colId]; !exists {
+			dst[colId] = make(map[string]*GetResult)
+		}
+		for key, result := range results {
+			dst[colId][key] = result
+		}
+	}
+}
+
+func mergeResultsMap(dst, src map[uint32]map[string][]*GetResult) {
+	for colId, results := range src {
+		if _, exists := dst[colId]; !exists {
+			dst[colId] = make(map[string][]*GetResult)
+		}
+		for key, result := range results {
+			dst[colId][key] = result
+		}
+	}
+}
+
+// Each mismatch is recorded from both points of view so only the source one is counted
+func (w *liveWindow) count() int {
+	var count int
+	for _, results := range w.missingFromSource {
+		count += len(results)
+	}
+	for _, results := range w.missingFromTarget {
+		count += len(results)
+	}
+	for _, generic := range []map[uint32]map[string][]*GetResult{w.srcDiff, w.deletedFromSource, w.deletedFromTarget} {
+		for _, results := range generic {
+			count += len(results)
+		}
+	}
+	return count
+}
+
+// Returns the keys that are still diverging, keyed by source and target collection IDs respectively
+// Note that deletedFromTarget is keyed by the source collection ID
+func (w *liveWindow) keys() (DiffKeysMap, DiffKeysMap) {
+	srcKeys := make(DiffKeysMap)
+	srcKeys.Merge(resultMapToDiffKeysMap(w.missingFromSource))
+	srcKeys.Merge(resultMapToDiffKeysMap(w.srcDiff))
+	srcKeys.Merge(resultMapToDiffKeysMap(w.deletedFromSource))
+	srcKeys.Merge(resultMapToDiffKeysMap(w.deletedFromTarget))
+
+	tgtKeys := make(DiffKeysMap)
+	tgtKeys.Merge(resultMapToDiffKeysMap(w.missingFromTarget))
+	tgtKeys.Merge(resultMapToDiffKeysMap(w.tgtDiff))
+	return srcKeys, tgtKeys
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLiveMonitor(settleDelay time.Duration, maxPendingKeys int) *LiveMonitor {
+	return &LiveMonitor{
+		settleDelay:    settleDelay,
+		maxPendingKeys: maxPendingKeys,
+		srcPending:     make(map[uint32]map[string]time.Time),
+		tgtPending:     make(map[uint32]map[string]time.Time),
+		window:         newLiveWindow(),
+		pendingKeys:    metrics.NewGauge(),
+		droppedKeys:    metrics.NewCounter(),
+	}
+}
+
+func TestLiveMonitorSettle(t *testing.T) {
+	assert := assert.New(t)
+	m := newTestLiveMonitor(time.Minute, 0)
+
+	m.RecordSourceChange(8, []byte("changedNow"))
+	m.srcPending[8]["settled"] = time.Now().Add(-2 * time.Minute)
+	m.tgtPending[9] = map[string]time.Time{"tgtSettled": time.Now().Add(-2 * time.Minute)}
+	m.numPending += 2
+
+	srcKeys, tgtKeys := m.getSettledKeys()
+	assert.Equal(DiffKeysMap{8: {"settled"}}, srcKeys)
+	assert.Equal(DiffKeysMap{9: {"tgtSettled"}}, tgtKeys)
+	assert.Equal(1, m.numPending)
+	assert.Equal(int64(1), m.pendingKeys.Value())
+	assert.Len(m.tgtPending, 0)
+
+	// a key that changes again has to settle all over
+	m.srcPending[8]["changedNow"] = time.Now().Add(-2 * time.Minute)
+	m.RecordSourceChange(8, []byte("changedNow"))
+	srcKeys, _ = m.getSettledKeys()
+	assert.Equal(0, srcKeys.GetTotalCount())
+	assert.Equal(1, m.numPending)
+}
+
+func TestLiveMonitorMaxPendingKeys(t *testing.T) {
+	assert := assert.New(t)
+	m := newTestLiveMonitor(time.Minute, 2)
+
+	m.RecordSourceChange(8, []byte("key1"))
+	m.RecordTargetChange(9, []byte("key2"))
+	m.RecordSourceChange(8, []byte("key3"))
+	assert.Equal(2, m.numPending)
+	assert.Equal(int64(1), m.droppedKeys.Count())
+	assert.NotContains(m.srcPending[8], "key3")
+
+	// keys that are already pending keep being tracked
+	m.RecordSourceChange(8, []byte("key1"))
+	assert.Equal(int64(1), m.droppedKeys.Count())
+
+	// settled keys make room again
+	m.srcPending[8]["key1"] = time.Now().Add(-2 * time.Minute)
+	m.tgtPending[9]["key2"] = time.Now().Add(-2 * time.Minute)
+	m.getSettledKeys()
+	assert.Equal(0, m.numPending)
+	m.RecordSourceChange(8, []byte("key3"))
+	assert.Equal(1, m.numPending)
+	assert.Equal(int64(1), m.droppedKeys.Count())
+}
+
+func TestLiveMonitorRequeue(t *testing.T) {
+	assert := assert.New(t)
+	m := newTestLiveMonitor(time.Minute, 0)
+
+	m.RecordSourceChange(8, []byte("changedAgain"))
+	changedAgain := m.srcPending[8]["changedAgain"]
+
+	settled := time.Now().Add(-m.settleDelay)
+	m.addPending(m.srcPending, 8, "changedAgain", settled, false)
+	m.addPending(m.srcPending, 8, "outstanding", settled, false)
+	assert.Equal(changedAgain, m.srcPending[8]["changedAgain"])
+	assert.Equal(settled, m.srcPending[8]["outstanding"])
+	assert.Equal(2, m.numPending)
+}
+
+func TestLiveWindow(t *testing.T) {
+	assert := assert.New(t)
+	w := newLiveWindow()
+	colIdsMap := map[uint32][]uint32{8: {9}}
+	reverseColIdsMap := map[uint32][]uint32{9: {8}}
+
+	result := &GetResult{}
+	w.missingFromTarget[9] = map[string]*GetResult{"missing": result}
+	w.srcDiff[8] = map[string][]*GetResult{"mismatch": {result, result}}
+	w.tgtDiff[9] = map[string][]*GetResult{"mismatch": {result, result}}
+	w.deletedFromTarget[8] = map[string][]*GetResult{"deleted": {result, result}}
+	// a mismatch is counted once even though it is recorded from both sides
+	assert.Equal(3, w.count())
+
+	srcKeys, tgtKeys := w.keys()
+	assert.ElementsMatch([]string{"mismatch", "deleted"}, srcKeys[8])
+	assert.ElementsMatch([]string{"missing", "mismatch"}, tgtKeys[9])
+
+	// rechecking a key from either side forgets its earlier outcome on both sides
+	w.forget(DiffKeysMap{8: {"mismatch"}}, DiffKeysMap{9: {"missing"}}, colIdsMap, reverseColIdsMap)
+	assert.Equal(1, w.count())
+	assert.Len(w.tgtDiff[9], 0)
+	assert.Len(w.missingFromTarget[9], 0)
+	assert.Contains(w.deletedFromTarget[8], "deleted")
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"sort"
+
+	xdcrLog "github.com/couchbase/goxdcr/v8/log"
+	"github.com/couchbase/xdcrDiffer/base"
+)
+
+// The mismatched documents of a collection that Sync Gateway metadata explains, by how it explains them
+type CollectionMobileReport struct {
+	// Not reported as mismatched
+	ImportOnly      []string
+	ImportPending   []string
+	RevTreeMismatch []string
+}
+
+type MobileReport = CollectionReport[CollectionMobileReport, *CollectionMobileReport]
+
+func (c *CollectionMobileReport) add(key string, divergence base.MobileDivergence) {
+	switch divergence {
+	case base.MobileImportOnly:
+		c.ImportOnly = append(c.ImportOnly, key)
+	case base.MobileImportPending:
+		c.ImportPending = append(c.ImportPending, key)
+	case base.MobileRevTreeMismatch:
+		c.RevTreeMismatch = append(c.RevTreeMismatch, key)
+	}
+}
+
+func (c *CollectionMobileReport) merge(other *CollectionMobileReport) {
+	c.ImportOnly = append(c.ImportOnly, other.ImportOnly...)
+	c.ImportPending = append(c.ImportPending, other.ImportPending...)
+	c.RevTreeMismatch = append(c.RevTreeMismatch, other.RevTreeMismatch...)
+}
+
+func (c *CollectionMobileReport) sort() {
+	sort.Strings(c.ImportOnly)
+	sort.Strings(c.ImportPending)
+	sort.Strings(c.RevTreeMismatch)
+}
+
+// Returns the number of documents only differing by an import, pending import and with mismatched rev trees
+func (c *CollectionMobileReport) Count() (int, int, int) {
+	return len(c.ImportOnly), len(c.ImportPending), len(c.RevTreeMismatch)
+}
+
+func logMobileCounts(logger *xdcrLog.CommonLogger, m MobileReport) {
+	importOnly, importPending, revTreeMismatch := m.Total().Count()
+	if importOnly > 0 {
+		logger.Infof("%v documents only differ because Sync Gateway imported them on one side. They are not reported as mismatched\n", importOnly)
+	}
+	if importPending+revTreeMismatch > 0 {
+		logger.Warnf("Of the mismatched documents, %v have a write Sync Gateway is yet to import and %v have mismatched rev trees\n", importPending, revTreeMismatch)
+	}
+}
@@ -11,6 +11,7 @@ package differ
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -62,6 +63,10 @@ type MutationDiffer struct {
 	tgtDiff           map[uint32]map[string][]*GetResult
 	deletedFromSource map[uint32]map[string][]*GetResult
 	deletedFromTarget map[uint32]map[string][]*GetResult
+	lagReport         LagReport
+	winners           WinnerReport
+	hlvCausality      HlvCausalityReport
+	mobile            MobileReport
 
 	keysWithError []*MutationDifferFetchEntry
 	stateLock     *sync.RWMutex
@@ -93,6 +98,20 @@ type MutationDiffer struct {
 	srcKvVbMap      map[string][]uint16
 	tgtKvVbMap      map[string][]uint16
 	utils           xdcrUtils.UtilsIface
+
+	// In keys-from-file mode, the fetch list is built from the selector instead of the file differ's output
+	keySelector *base.KeySelector
+	// In key-list verification mode, the already resolved source collection ID to keys to be verified
+	verifyKeys DiffKeysMap
+	// If set, only documents modified within the window on either side are reported
+	casWindow *base.CasWindow
+	// If set, only keys that hash to these vbuckets are diffed
+	vbnos            map[uint16]bool
+	numberOfVbuckets uint16
+	// Decides which versions are the same, and which side wins a mismatch. Empty if unknown
+	conflictResolutionType string
+	// Explain mismatches by the Sync Gateway metadata of the documents
+	mobileMode bool
 }
 
 func (r *GetResult) MarshalJSON() ([]byte, error) {
@@ -121,7 +140,7 @@ func (r *GetResult) MarshalJSON() ([]byte, error) {
 	return json.Marshal(dataToBeEncoded)
 }
 
-func NewMutationDiffer(sourceClusterUUID, sourceBucketName, sourceBucketUUID string, sourceRef *metadata.RemoteClusterReference, targetClusterUUID, targetBucketName, targetBucketUUID string, targetRef *metadata.RemoteClusterReference, fileDifferDir string, mutationDifferFileDir string, numberOfWorkers int, batchSize int, timeout int, maxNumOfSendBatchRetry int, sendBatchRetryInterval time.Duration, sendBatchMaxBackoff time.Duration, compareType string, logger *xdcrLog.CommonLogger, colIdsMap map[uint32][]uint32, srcCapability metadata.Capability, tgtCapability metadata.Capability, xdcrUtils xdcrUtils.UtilsIface, retries int, retriesWaitSecs int, duplMapping DuplicatedHintMap) *MutationDiffer {
+func NewMutationDiffer(sourceClusterUUID, sourceBucketName, sourceBucketUUID string, sourceRef *metadata.RemoteClusterReference, targetClusterUUID, targetBucketName, targetBucketUUID string, targetRef *metadata.RemoteClusterReference, fileDifferDir string, mutationDifferFileDir string, numberOfWorkers int, batchSize int, timeout int, maxNumOfSendBatchRetry int, sendBatchRetryInterval time.Duration, sendBatchMaxBackoff time.Duration, compareType string, logger *xdcrLog.CommonLogger, colIdsMap map[uint32][]uint32, srcCapability metadata.Capability, tgtCapability metadata.Capability, xdcrUtils xdcrUtils.UtilsIface, retries int, retriesWaitSecs int, duplMapping DuplicatedHintMap, keySelector *base.KeySelector, verifyKeys DiffKeysMap, casWindow *base.CasWindow, vbnos []uint16, numberOfVbuckets uint16, conflictResolutionType string, mobileMode bool) *MutationDiffer {
 	// this indicates that mutation differ is expected to read srcDiff fetchList generated by file differ,
 	inputDiffKeysFileName := fileDifferDir + base.FileDirDelimiter + base.DiffKeysFileName
 	if len(colIdsMap) == 0 {
@@ -129,6 +148,13 @@ func NewMutationDiffer(sourceClusterUUID, sourceBucketName, sourceBucketUUID str
 		colIdsMap = make(map[uint32][]uint32)
 		colIdsMap[0] = []uint32{0}
 	}
+	var vbnoMap map[uint16]bool
+	if len(vbnos) > 0 {
+		vbnoMap = make(map[uint16]bool)
+		for _, vbno := range vbnos {
+			vbnoMap[vbno] = true
+		}
+	}
 	return &MutationDiffer{
 		sourceClusterUUID:      sourceClusterUUID,
 		sourceBucketName:       sourceBucketName,
@@ -149,6 +175,10 @@ func NewMutationDiffer(sourceClusterUUID, sourceBucketName, sourceBucketUUID str
 		tgtDiff:                make(map[uint32]map[string][]*GetResult),
 		deletedFromSource:      make(map[uint32]map[string][]*GetResult),
 		deletedFromTarget:      make(map[uint32]map[string][]*GetResult),
+		lagReport:              make(LagReport),
+		winners:                make(WinnerReport),
+		hlvCausality:           make(HlvCausalityReport),
+		mobile:                 make(MobileReport),
 		keysWithError:          MutationDiffFetchList{},
 		stateLock:              &sync.RWMutex{},
 		maxNumOfSendBatchRetry: maxNumOfSendBatchRetry,
@@ -166,21 +196,34 @@ func NewMutationDiffer(sourceClusterUUID, sourceBucketName, sourceBucketUUID str
 		conflictRetries:        retries,
 		retriesWaitSec:         retriesWaitSecs,
 		duplicateMap:           duplMapping,
+		keySelector:            keySelector,
+		verifyKeys:             verifyKeys,
+		casWindow:              casWindow,
+		vbnos:                  vbnoMap,
+		numberOfVbuckets:       numberOfVbuckets,
+		conflictResolutionType: conflictResolutionType,
+		mobileMode:             mobileMode,
 	}
 }
 
 func (d *MutationDiffer) Run() error {
-	srcDiffKeys, tgtDiffKeys, migrationHintMap, err := d.loadDiffKeys()
-	if err != nil {
-		return err
+	var srcDiffKeys, tgtDiffKeys DiffKeysMap
+	var migrationHintMap MigrationHintMap
+	var err error
+	if len(d.verifyKeys) > 0 {
+		srcDiffKeys = d.verifyKeys
+		d.logger.Infof("Verifying %v keys from the user-specified key list\n", srcDiffKeys.GetTotalCount())
+	} else if d.keySelector.IsKeysFileMode() {
+		srcDiffKeys = d.getDiffKeysFromKeySelector()
+	} else {
+		srcDiffKeys, tgtDiffKeys, migrationHintMap, err = d.loadDiffKeys()
+		if err != nil {
+			return err
+		}
 	}
 	d.migrationHintMap = migrationHintMap
-
-	srcPovFetchList, srcPovFetchIdx := srcDiffKeys.ToFetchEntries(d.colIdsMap, migrationHintMap)
-	tgtPovFetchList, tgtPovFetchIdx := tgtDiffKeys.ToFetchEntries(d.reverseTgtColIdsMap, nil)
-	combinedFetchList := dedupFetchLists(srcPovFetchList, srcPovFetchIdx, tgtPovFetchList, tgtPovFetchIdx)
-
-	d.logger.Infof("Mutation srcDiff to work on %v srcPovFetchList with diffs.\n", len(combinedFetchList))
+	srcDiffKeys = d.selectVbuckets(srcDiffKeys)
+	tgtDiffKeys = d.selectVbuckets(tgtDiffKeys)
 
 	err = d.initialize()
 	if err != nil {
@@ -190,6 +233,20 @@ func (d *MutationDiffer) Run() error {
 
 	d.logger.Infof("Mutation differ initialized\n")
 
+	d.diffKeys(srcDiffKeys, tgtDiffKeys)
+
+	return d.writeDiff()
+}
+
+// Fetches the given keys from both clusters and diffs them. If there are differences, the differing keys are
+// refetched as many times as the user asked for in order to rule out in-flight mutations
+func (d *MutationDiffer) diffKeys(srcDiffKeys, tgtDiffKeys DiffKeysMap) {
+	srcPovFetchList, srcPovFetchIdx := srcDiffKeys.ToFetchEntries(d.colIdsMap, d.migrationHintMap)
+	tgtPovFetchList, tgtPovFetchIdx := tgtDiffKeys.ToFetchEntries(d.reverseTgtColIdsMap, nil)
+	combinedFetchList := dedupFetchLists(srcPovFetchList, srcPovFetchIdx, tgtPovFetchList, tgtPovFetchIdx)
+
+	d.logger.Infof("Mutation srcDiff to work on %v srcPovFetchList with diffs.\n", len(combinedFetchList))
+
 	d.fetchAndDiff(combinedFetchList)
 
 	// Retry multiple times if asked to, in order to minimize in flight differences
@@ -200,20 +257,19 @@ func (d *MutationDiffer) Run() error {
 		}
 		srcDiffKeys = d.getDiffKeysFromSourceGocbResult()
 		tgtDiffKeys = d.getDiffKeysFromTargetGocbResult()
-		srcPovFetchList, srcPovFetchIdx = srcDiffKeys.ToFetchEntries(d.colIdsMap, migrationHintMap)
+		srcPovFetchList, srcPovFetchIdx = srcDiffKeys.ToFetchEntries(d.colIdsMap, d.migrationHintMap)
 		tgtPovFetchList, tgtPovFetchIdx = tgtDiffKeys.ToFetchEntries(d.reverseTgtColIdsMap, nil)
 		combinedFetchList = dedupFetchLists(srcPovFetchList, srcPovFetchIdx, tgtPovFetchList, tgtPovFetchIdx)
 		d.logger.Infof("With %v diffs, retrying %v out of %v times to resolve in-flight differences...",
 			len(combinedFetchList), i+1, d.conflictRetries)
 		d.fetchAndDiff(combinedFetchList)
 	}
-
-	return d.writeDiff()
 }
 
 func (d *MutationDiffer) fetchAndDiff(combinedFetchList MutationDiffFetchList) {
 	// First clear the results that the differWorker will be working on
 	d.clearGoCbResults()
+	atomic.StoreUint32(&d.numKeysProcessed, 0)
 	finCh := make(chan bool)
 
 	go d.reportStatus(len(combinedFetchList), finCh)
@@ -356,27 +412,59 @@ func (d *MutationDiffer) reportStatus(totalKeys int, finCh chan bool) {
 	}
 }
 
+// Writes every output even if an earlier one fails, and returns all of their errors
 func (d *MutationDiffer) writeDiff() error {
+	var errs []error
 	err := d.writeKeysWithError()
 	if err != nil {
 		d.logger.Errorf("Error writing fetchList with errors. err=%v\n", err)
+		errs = append(errs, err)
 	}
 
 	err = d.writeCollectionMapping()
 	if err != nil {
 		d.logger.Errorf("Error collection mapping with errors. err=%v\n", err)
+		errs = append(errs, err)
 	}
 
 	err = d.writeDiffDetails()
 	if err != nil {
 		d.logger.Errorf("Error writing srcDiff details. err=%v\n", err)
+		errs = append(errs, err)
 	}
 
 	err = d.writeMigrationDetails()
 	if err != nil {
 		d.logger.Errorf("Error writing migration details. err=%v\n", err)
+		errs = append(errs, err)
 	}
-	return err
+
+	err = d.writeLagReport()
+	if err != nil {
+		d.logger.Errorf("Error writing lag report. err=%v\n", err)
+		errs = append(errs, err)
+	}
+
+	err = d.writeWinnerReport()
+	if err != nil {
+		d.logger.Errorf("Error writing winner report. err=%v\n", err)
+		errs = append(errs, err)
+	}
+
+	err = d.writeHlvCausalityReport()
+	if err != nil {
+		d.logger.Errorf("Error writing hlv causality report. err=%v\n", err)
+		errs = append(errs, err)
+	}
+
+	if d.mobileMode {
+		err = d.writeMobileReport()
+		if err != nil {
+			d.logger.Errorf("Error writing mobile report. err=%v\n", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func (d *MutationDiffer) writeDiffDetails() error {
@@ -424,14 +512,18 @@ func (d *MutationDiffer) writeKeysWithError() error {
 }
 
 func (d *MutationDiffer) getDiffBytes() ([]byte, error) {
+	return marshalDiffDetails(d.compareType, d.srcDiff, d.missingFromSource, d.missingFromTarget, d.deletedFromSource, d.deletedFromTarget)
+}
+
+func marshalDiffDetails(compareType string, srcDiff map[uint32]map[string][]*GetResult, missingFromSource, missingFromTarget map[uint32]map[string]*GetResult, deletedFromSource, deletedFromTarget map[uint32]map[string][]*GetResult) ([]byte, error) {
 	outputMap := map[string]interface{}{
-		"Mismatch":          d.srcDiff,
-		"MissingFromSource": d.missingFromSource,
-		"MissingFromTarget": d.missingFromTarget,
+		"Mismatch":          srcDiff,
+		"MissingFromSource": missingFromSource,
+		"MissingFromTarget": missingFromTarget,
 	}
-	if d.compareType == base.MutationCompareTypeMetadata || d.compareType == base.MutationCompareTypeBodyAndMeta {
-		outputMap["DeletedFromSource"] = d.deletedFromSource
-		outputMap["DeletedFromTarget"] = d.deletedFromTarget
+	if compareType == base.MutationCompareTypeMetadata || compareType == base.MutationCompareTypeBodyAndMeta {
+		outputMap["DeletedFromSource"] = deletedFromSource
+		outputMap["DeletedFromTarget"] = deletedFromTarget
 	}
 	return json.Marshal(outputMap)
 }
@@ -494,6 +586,37 @@ func (d *MutationDiffer) loadDiffKeys() (DiffKeysMap, DiffKeysMap, MigrationHint
 	return srcDiffKeys, tgtDiffKeys, migrationHintMap, nil
 }
 
+// Every key from the keys file is looked up in each of the mapped source collections
+func (d *MutationDiffer) getDiffKeysFromKeySelector() DiffKeysMap {
+	diffKeys := make(DiffKeysMap)
+	keys := d.keySelector.Keys()
+	for srcColId := range d.colIdsMap {
+		diffKeys[srcColId] = keys
+	}
+	d.logger.Infof("Loaded %v keys from keys file to verify against %v source collections\n", len(keys), len(d.colIdsMap))
+	return diffKeys
+}
+
+// Drops the keys that do not belong to the selected vbuckets
+func (d *MutationDiffer) selectVbuckets(diffKeys DiffKeysMap) DiffKeysMap {
+	if d.vbnos == nil || diffKeys == nil {
+		return diffKeys
+	}
+	selectedKeys := make(DiffKeysMap)
+	var total, selected int
+	for colId, keys := range diffKeys {
+		for _, key := range keys {
+			total++
+			if d.vbnos[utils.CbcVbMap([]byte(key), uint32(d.numberOfVbuckets))] {
+				selectedKeys[colId] = append(selectedKeys[colId], key)
+				selected++
+			}
+		}
+	}
+	d.logger.Infof("%v out of %v keys belong to the selected vbuckets\n", selected, total)
+	return selectedKeys
+}
+
 func (d *MutationDiffer) addDocDiff(missingFromSource, missingFromTarget map[uint32]map[string]*GetResult, srcDiff, tgtDiff, deletedFromSource, deletedFromTarget map[uint32]map[string][]*GetResult) {
 	d.stateLock.Lock()
 	defer d.stateLock.Unlock()
@@ -552,6 +675,30 @@ func (d *MutationDiffer) addDocDiff(missingFromSource, missingFromTarget map[uin
 	}
 }
 
+func (d *MutationDiffer) addLagReport(lagReport LagReport) {
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	d.lagReport.Merge(lagReport)
+}
+
+func (d *MutationDiffer) addWinners(winners WinnerReport) {
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	d.winners.Merge(winners)
+}
+
+func (d *MutationDiffer) addHlvCausality(hlvCausality HlvCausalityReport) {
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	d.hlvCausality.Merge(hlvCausality)
+}
+
+func (d *MutationDiffer) addMobile(mobile MobileReport) {
+	d.stateLock.Lock()
+	defer d.stateLock.Unlock()
+	d.mobile.Merge(mobile)
+}
+
 func (d *MutationDiffer) addKeysWithError(keysWithError MutationDiffFetchList) {
 	d.stateLock.Lock()
 	defer d.stateLock.Unlock()
@@ -698,6 +845,10 @@ func (dw *DifferWorker) diff() {
 	tgtDiff := make(map[uint32]map[string][]*GetResult)
 	deletedFromSource := make(map[uint32]map[string][]*GetResult)
 	deletedFromTarget := make(map[uint32]map[string][]*GetResult)
+	lagReport := make(LagReport)
+	winners := make(WinnerReport)
+	hlvCausality := make(HlvCausalityReport)
+	mobile := make(MobileReport)
 
 	migrationMode := len(dw.migrationHintMap) > 0
 
@@ -740,6 +891,9 @@ func (dw *DifferWorker) diff() {
 				if targetResult.key == "" {
 					continue
 				}
+				if !dw.differ.isInCasWindow(sourceResult, targetResult) {
+					continue
+				}
 				if bodyOnly {
 					srcerr = sourceResult.bodyErr
 					tgterr = targetResult.bodyErr
@@ -773,13 +927,30 @@ func (dw *DifferWorker) diff() {
 						tgtDiff[tgtColId][key] = append(tgtDiff[tgtColId][key], []*GetResult{targetResult, sourceResult}...)
 					}
 				} else {
-					metaSame, err := areGetResultsTheSame(sourceResult, targetResult, srcUUID, tgtUUID, includeBody)
+					metaSame, err := areGetResultsTheSame(sourceResult, targetResult, srcUUID, tgtUUID, includeBody, dw.differ.conflictResolutionType)
 					if err != nil {
 						atomic.AddUint32(&dw.differ.numKeysWithErrors, 1)
 						dw.logger.Errorf(err.Error())
 						continue
 					}
 					if !metaSame {
+						if dw.differ.mobileMode && sourceResult.GetMetaResult != nil && targetResult.GetMetaResult != nil {
+							// without the bodies, only the metadata can tell the documents apart
+							bodySame := !includeBody || areGetResultsBodyTheSame(sourceResult, targetResult)
+							divergence := base.CompareMobileMeta(sourceResult.mobileMeta(), targetResult.mobileMeta(), bodySame)
+							if divergence != base.MobileDivergenceNone {
+								mobile.get(srcColId).add(key, divergence)
+							}
+							if divergence == base.MobileImportOnly {
+								continue
+							}
+						}
+						if sourceResult.GetMetaResult != nil && targetResult.GetMetaResult != nil {
+							sourceHlv := newVersionVector(sourceResult.HLV, uint64(sourceResult.Cas), sourcePruningWindow.get())
+							targetHlv := newVersionVector(targetResult.HLV, uint64(targetResult.Cas), targetPruningWindow.get())
+							winners.get(srcColId).add(key, base.ResolveConflict(dw.differ.conflictResolutionType, sourceResult.crVersion(sourceHlv), targetResult.crVersion(targetHlv)))
+							hlvCausality.get(srcColId).add(key, sourceHlv, targetHlv)
+						}
 						if isDeleted(sourceResult.GetMetaResult) {
 							if _, exists := deletedFromSource[srcColId]; !exists {
 								deletedFromSource[srcColId] = make(map[string][]*GetResult)
@@ -794,6 +965,10 @@ func (dw *DifferWorker) diff() {
 							deletedFromTarget[srcColId][key] = append(deletedFromSource[srcColId][key], []*GetResult{sourceResult, targetResult}...)
 							continue
 						}
+						if sourceResult.GetMetaResult != nil && targetResult.GetMetaResult != nil {
+							mismatchClass, lag := classifyMismatch(sourceResult, targetResult)
+							lagReport.add(srcColId, key, mismatchClass, lag)
+						}
 						if _, exists := srcDiff[srcColId]; !exists {
 							srcDiff[srcColId] = make(map[string][]*GetResult)
 						}
@@ -813,6 +988,9 @@ func (dw *DifferWorker) diff() {
 			if targetResult.key == "" {
 				continue
 			}
+			if !dw.differ.isInCasWindow(targetResult) {
+				continue
+			}
 			srcColIds := dw.reverseColIds[tgtColId]
 			var foundSourceColId bool
 			var keyExists bool
@@ -834,6 +1012,10 @@ func (dw *DifferWorker) diff() {
 		}
 	}
 	dw.differ.addDocDiff(missingFromSource, missingFromTarget, srcDiff, tgtDiff, deletedFromSource, deletedFromTarget)
+	dw.differ.addLagReport(lagReport)
+	dw.differ.addWinners(winners)
+	dw.differ.addHlvCausality(hlvCausality)
+	dw.differ.addMobile(mobile)
 }
 
 type batch struct {
@@ -960,6 +1142,9 @@ func (b *batch) get(key string, isSource bool, compareType string, colId uint32)
 			getResult.lock.Lock()
 			defer getResult.lock.Unlock()
 			getResult.hlvBytes, getResult.importCas, getResult.pRev, getResult.parsingErr = getHlvImportCas(bucketUUID, result)
+			if getResult.parsingErr == nil {
+				getResult.preImportCas, getResult.syncRev, getResult.syncCas, getResult.parsingErr = getMobileMeta(result)
+			}
 		}
 		b.waitGroup.Done()
 	}
@@ -1006,6 +1191,24 @@ func (b *batch) get(key string, isSource bool, compareType string, colId uint32)
 	}
 }
 
+// Results without metadata (i.e. body only comparison) cannot be placed in time and are always kept
+func (d *MutationDiffer) isInCasWindow(results ...*GetResult) bool {
+	if d.casWindow == nil {
+		return true
+	}
+	var hasMeta bool
+	for _, result := range results {
+		casList := result.getCasList()
+		if len(casList) > 0 {
+			hasMeta = true
+		}
+		if d.casWindow.ContainsAny(casList...) {
+			return true
+		}
+	}
+	return !hasMeta
+}
+
 func isKeyNotFoundError(err error) bool {
 	return err != nil && strings.Contains(err.Error(), gocbcore.ErrDocumentNotFound.Error())
 }
@@ -1030,7 +1233,7 @@ func NewDocMeta(result *GetResult) *xdcrBase.DocumentMetadata {
 
 }
 
-func areGetResultsTheSame(result1, result2 *GetResult, sourceUUID, targetUUID hlv.DocumentSourceId, includeBody bool) (bool, error) {
+func areGetResultsTheSame(result1, result2 *GetResult, sourceUUID, targetUUID hlv.DocumentSourceId, includeBody bool, crType string) (bool, error) {
 	if result1.GetMetaResult == nil && result2.GetMetaResult == nil {
 		return true, nil
 	} else if result1.GetMetaResult == nil {
@@ -1080,6 +1283,14 @@ func areGetResultsTheSame(result1, result2 *GetResult, sourceUUID, targetUUID hl
 		err := SetHlv(sourceCrMeta, targetCrMeta, sourceUUID, targetUUID)
 		result1.HLV = sourceCrMeta.GetHLV()
 		result2.HLV = targetCrMeta.GetHLV()
+		if crType == base.ConflictResolutionSeqno {
+			// Neither the CAS nor the HLV take part in revId based conflict resolution
+			metaSame := result1.crVersion(nil).SameUnderSeqnoCR(result2.crVersion(nil))
+			if includeBody {
+				return metaSame && areGetResultsBodyTheSame(result1, result2), nil
+			}
+			return metaSame, nil
+		}
 		if err != nil {
 			// An err is populated only if implict construction of HLVs are not possible --> this implies that there is a diff
 			// return false and ignore the error.
@@ -1136,6 +1347,29 @@ func getHlvImportCas(bucketUUID string, result *gocbcore.LookupInResult) (hlvByt
 	return
 }
 
+// Returns the CAS before the import, and the current revision and CAS Sync Gateway recorded, if any
+func getMobileMeta(result *gocbcore.LookupInResult) (preImportCas uint64, syncRev string, syncCas uint64, err error) {
+	if result == nil {
+		return
+	}
+	if result.Ops[3].Err == nil {
+		preImportCas, err = base.ParseMacroExpandedCas(result.Ops[3].Value)
+		if err != nil {
+			return
+		}
+	}
+	if result.Ops[4].Err == nil {
+		syncRev, err = base.ParseSyncRev(result.Ops[4].Value)
+		if err != nil {
+			return
+		}
+	}
+	if result.Ops[5].Err == nil {
+		syncCas, err = base.ParseMacroExpandedCas(result.Ops[5].Value)
+	}
+	return
+}
+
 func isDeleted(result *gocbcore.GetMetaResult) bool {
 	if result != nil {
 		return result.Deleted != 0
@@ -1151,12 +1385,53 @@ type GetResult struct {
 	bodyErr    error
 	metaErr    error
 	parsingErr error
+	// Sync Gateway metadata, zero if the document has none
+	preImportCas uint64
+	syncRev      string
+	syncCas      uint64
 	*gocbcore.GetMetaResult
 	hlvBytes []byte
 	*hlv.HLV
 	lock sync.RWMutex
 }
 
+// vector is the HLV of the document as pruned by its bucket, which only custom conflict resolution looks at
+func (r *GetResult) crVersion(vector *base.VersionVector) base.CrVersion {
+	return base.CrVersion{
+		Cas:      uint64(r.Cas),
+		RevSeqno: uint64(r.SeqNo),
+		Expiry:   r.Expiry,
+		Flags:    r.Flags,
+		Deleted:  isDeleted(r.GetMetaResult),
+		Hlv:      vector,
+	}
+}
+
+func (r *GetResult) mobileMeta() base.MobileMeta {
+	return base.MobileMeta{
+		Cas:          uint64(r.Cas),
+		SyncRev:      r.syncRev,
+		SyncCas:      r.syncCas,
+		ImportCas:    r.importCas,
+		PreImportCas: r.preImportCas,
+	}
+}
+
+// Returns the document CAS and, if the document has a HLV, its cvCas
+func (r *GetResult) getCasList() []uint64 {
+	if r == nil || r.GetMetaResult == nil {
+		return nil
+	}
+	casList := []uint64{uint64(r.Cas)}
+	if len(r.hlvBytes) > 0 {
+		cvCas, _, _, _, _, err := xdcrCrMeta.ParseHlvFields(uint64(r.Cas), r.hlvBytes)
+		if err == nil {
+			casList = append(casList, cvCas)
+		}
+	}
+	return casList
+}
+
 func (d *MutationDiffer) initialize() error {
 	var err error
 	err = d.openBucket(d.sourceBucketName, d.sourceReference, true)
@@ -1203,7 +1478,7 @@ func (d *MutationDiffer) openBucket(bucketName string, reference *metadata.Remot
 
 	useSecurePrefix := reference.HttpAuthMech() == xdcrBase.HttpAuthMechHttps
 
-	if !source && len(reference.ClientKey()) > 0 && len(reference.ClientCertificate()) > 0 {
+	if len(reference.ClientKey()) > 0 && len(reference.ClientCertificate()) > 0 {
 		auth = &base.CertificateAuth{
 			// client cert auth requires no password
 			PasswordAuth:     base.PasswordAuth{},
@@ -1411,6 +1686,10 @@ func (d *MutationDiffer) clearGoCbResults() {
 	d.tgtDiff = make(map[uint32]map[string][]*GetResult)
 	d.deletedFromSource = make(map[uint32]map[string][]*GetResult)
 	d.deletedFromTarget = make(map[uint32]map[string][]*GetResult)
+	d.lagReport = make(LagReport)
+	d.winners = make(WinnerReport)
+	d.hlvCausality = make(HlvCausalityReport)
+	d.mobile = make(MobileReport)
 }
 
 func (d *MutationDiffer) writeMigrationDetails() error {
@@ -1427,3 +1706,36 @@ func (d *MutationDiffer) writeMigrationDetails() error {
 	}
 	return nil
 }
+
+func (d *MutationDiffer) writeLagReport() error {
+	d.lagReport.computeHistogram()
+	for srcColId, report := range d.lagReport {
+		d.logger.Infof("Collection %v mismatches: %v target older, %v target newer, %v concurrent. Lag p50=%vms p99=%vms max=%vms\n",
+			srcColId, len(report.TargetOlder), len(report.TargetNewer), len(report.Concurrent), report.LagP50Ms, report.LagP99Ms, report.LagMaxMs)
+	}
+
+	fileName := d.mutationDifferFileDir + base.FileDirDelimiter + base.MutationDiffLagReportFileName
+	bytes, err := json.Marshal(d.lagReport)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, bytes, 0644)
+}
+
+func (d *MutationDiffer) writeWinnerReport() error {
+	if source, target, merge := d.winners.Total().Count(); source+target+merge > 0 {
+		d.logger.Infof("Under %v conflict resolution, XDCR would keep the source version of %v mismatched documents, the target version of %v, and merge %v\n",
+			d.conflictResolutionType, source, target, merge)
+	}
+	return d.winners.Write(d.mutationDifferFileDir + base.FileDirDelimiter + base.MutationDiffWinnerReportFileName)
+}
+
+func (d *MutationDiffer) writeHlvCausalityReport() error {
+	logHlvCausalityCounts(d.logger, d.hlvCausality)
+	return d.hlvCausality.Write(d.mutationDifferFileDir + base.FileDirDelimiter + base.MutationDiffHlvCausalityReportFileName)
+}
+
+func (d *MutationDiffer) writeMobileReport() error {
+	logMobileCounts(d.logger, d.mobile)
+	return d.mobile.Write(d.mutationDifferFileDir + base.FileDirDelimiter + base.MutationDiffMobileReportFileName)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+
+	"github.com/couchbase/xdcrDiffer/base"
+)
+
+type VbucketItemCountDiff struct {
+	Vbno        uint16
+	SourceItems uint64
+	TargetItems uint64
+}
+
+type CollectionItemCountDiff struct {
+	SourceColId  uint32
+	TargetColIds []uint32
+	SourceItems  uint64
+	TargetItems  uint64
+}
+
+// Result of comparing the item counts of both clusters before anything is streamed
+// Differing counts are a sure sign of missing documents, but equal counts do not rule out mismatches
+type PreflightReport struct {
+	SourceItems uint64
+	TargetItems uint64
+	// Per vbucket counts are only comparable when every document is replicated into the same vbucket
+	VbucketsCompared bool
+	Vbuckets         []*VbucketItemCountDiff
+	// Per collection counts are only compared for one to one collection mappings
+	CollectionsCompared bool
+	Collections         []*CollectionItemCountDiff
+	// The differing vbuckets in the format accepted by the vbuckets option
+	SuspiciousVbuckets string
+
+	suspiciousVbnos []uint16
+}
+
+// vbnos restricts the vbuckets that are compared, nil for all of them
+// The collection counts can be nil if either bucket does not support collections
+func NewPreflightReport(srcVbCounts, tgtVbCounts map[uint16]uint64, compareVbuckets bool, vbnos []uint16,
+	srcColCounts, tgtColCounts map[uint32]uint64, srcToTgtColIdsMap map[uint32][]uint32) *PreflightReport {
+	report := &PreflightReport{
+		VbucketsCompared:    compareVbuckets,
+		CollectionsCompared: srcColCounts != nil && tgtColCounts != nil,
+	}
+	for _, count := range srcVbCounts {
+		report.SourceItems += count
+	}
+	for _, count := range tgtVbCounts {
+		report.TargetItems += count
+	}
+
+	if compareVbuckets {
+		report.compareVbuckets(srcVbCounts, tgtVbCounts, vbnos)
+	}
+	if report.CollectionsCompared {
+		report.compareCollections(srcColCounts, tgtColCounts, srcToTgtColIdsMap)
+	}
+	return report
+}
+
+func (r *PreflightReport) compareVbuckets(srcVbCounts, tgtVbCounts map[uint16]uint64, vbnos []uint16) {
+	for _, vbno := range base.SelectedVbuckets(vbnos, uint16(len(srcVbCounts))) {
+		if srcVbCounts[vbno] == tgtVbCounts[vbno] {
+			continue
+		}
+		r.Vbuckets = append(r.Vbuckets, &VbucketItemCountDiff{
+			Vbno:        vbno,
+			SourceItems: srcVbCounts[vbno],
+			TargetItems: tgtVbCounts[vbno],
+		})
+		r.suspiciousVbnos = append(r.suspiciousVbnos, vbno)
+	}
+	r.SuspiciousVbuckets = base.FormatVbucketList(r.suspiciousVbnos)
+}
+
+func (r *PreflightReport) compareCollections(srcColCounts, tgtColCounts map[uint32]uint64, srcToTgtColIdsMap map[uint32][]uint32) {
+	// a target collection that more than one source collection replicates into cannot be compared
+	tgtColIdRefCount := make(map[uint32]int)
+	for _, tgtColIds := range srcToTgtColIdsMap {
+		for _, tgtColId := range tgtColIds {
+			tgtColIdRefCount[tgtColId]++
+		}
+	}
+
+	for srcColId, tgtColIds := range srcToTgtColIdsMap {
+		if len(tgtColIds) != 1 || tgtColIdRefCount[tgtColIds[0]] != 1 {
+			continue
+		}
+		if srcColCounts[srcColId] == tgtColCounts[tgtColIds[0]] {
+			continue
+		}
+		r.Collections = append(r.Collections, &CollectionItemCountDiff{
+			SourceColId:  srcColId,
+			TargetColIds: tgtColIds,
+			SourceItems:  srcColCounts[srcColId],
+			TargetItems:  tgtColCounts[tgtColIds[0]],
+		})
+	}
+	sort.Slice(r.Collections, func(i, j int) bool { return r.Collections[i].SourceColId < r.Collections[j].SourceColId })
+}
+
+// Returns the vbuckets whose item counts differ
+func (r *PreflightReport) SuspiciousVbnos() []uint16 {
+	return r.suspiciousVbnos
+}
+
+func (r *PreflightReport) Write(fileName string) error {
+	reportBytes, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, reportBytes, 0644)
+}
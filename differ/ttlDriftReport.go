@@ -0,0 +1,43 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"sort"
+)
+
+// A document that exists on both sides with a different expiry
+// Expiries are absolute, in seconds since epoch, and 0 means the document does not expire
+type TtlDrift struct {
+	Key          string
+	SourceExpiry uint32
+	TargetExpiry uint32
+}
+
+type TtlDrifts []*TtlDrift
+
+type TtlDriftReport = CollectionReport[TtlDrifts, *TtlDrifts]
+
+func (t *TtlDrifts) add(key string, sourceExpiry, targetExpiry uint32) {
+	*t = append(*t, &TtlDrift{
+		Key:          key,
+		SourceExpiry: sourceExpiry,
+		TargetExpiry: targetExpiry,
+	})
+}
+
+func (t *TtlDrifts) merge(other *TtlDrifts) {
+	*t = append(*t, *other...)
+}
+
+func (t *TtlDrifts) sort() {
+	drifts := *t
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Key < drifts[j].Key })
+}
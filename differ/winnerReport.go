@@ -0,0 +1,55 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package differ
+
+import (
+	"sort"
+
+	"github.com/couchbase/xdcrDiffer/base"
+)
+
+// For each mismatched document of a collection, the side whose version XDCR would keep under the
+// conflict resolution type of the buckets
+type CollectionWinnerReport struct {
+	Source []string
+	Target []string
+	// Only with custom conflict resolution
+	Merge []string
+}
+
+type WinnerReport = CollectionReport[CollectionWinnerReport, *CollectionWinnerReport]
+
+func (c *CollectionWinnerReport) add(key string, winner base.CrWinner) {
+	switch winner {
+	case base.CrWinnerSource:
+		c.Source = append(c.Source, key)
+	case base.CrWinnerMerge:
+		c.Merge = append(c.Merge, key)
+	default:
+		c.Target = append(c.Target, key)
+	}
+}
+
+func (c *CollectionWinnerReport) merge(other *CollectionWinnerReport) {
+	c.Source = append(c.Source, other.Source...)
+	c.Target = append(c.Target, other.Target...)
+	c.Merge = append(c.Merge, other.Merge...)
+}
+
+func (c *CollectionWinnerReport) sort() {
+	sort.Strings(c.Source)
+	sort.Strings(c.Target)
+	sort.Strings(c.Merge)
+}
+
+// Returns the number of documents won by the source, by the target and merged
+func (c *CollectionWinnerReport) Count() (int, int, int) {
+	return len(c.Source), len(c.Target), len(c.Merge)
+}
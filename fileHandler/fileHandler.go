@@ -2,7 +2,10 @@ package filehandler
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 
 	xdcrLog "github.com/couchbase/goxdcr/v8/log"
@@ -24,6 +27,11 @@ type Bucket struct {
 
 	logger    *xdcrLog.CommonLogger
 	bufferCap int
+
+	// recorded in the header that starts every file
+	hashAlgorithm base.HashAlgorithm
+	// whether the header has been written, or verified if the file already had content
+	headerChecked bool
 }
 type FileHandler struct {
 	fileDir             string
@@ -35,9 +43,13 @@ type FileHandler struct {
 	BucketMap           map[uint16]map[int]*Bucket
 	BucketLock          sync.RWMutex
 	logger              *xdcrLog.CommonLogger
+
+	// only the files of these vbuckets are opened and written to. All vbuckets if empty
+	vbnos         []uint16
+	hashAlgorithm base.HashAlgorithm
 }
 
-func NewBucket(fileDir string, vbno uint16, bucketIndex int, fdPool fdp.FdPoolIface, logger *xdcrLog.CommonLogger, bufferCap int) (*Bucket, error) {
+func NewBucket(fileDir string, vbno uint16, bucketIndex int, fdPool fdp.FdPoolIface, logger *xdcrLog.CommonLogger, bufferCap int, hashAlgorithm base.HashAlgorithm) (*Bucket, error) {
 	fileName := utils.GetFileName(fileDir, vbno, bucketIndex)
 	var cb fdp.FileOp
 	var closeOp func() error
@@ -59,14 +71,15 @@ func NewBucket(fileDir string, vbno uint16, bucketIndex int, fdPool fdp.FdPoolIf
 		}
 	}
 	return &Bucket{
-		data:      make([]byte, bufferCap),
-		index:     0,
-		file:      file,
-		fileName:  fileName,
-		fdPoolCb:  cb,
-		closeOp:   closeOp,
-		logger:    logger,
-		bufferCap: bufferCap,
+		data:          make([]byte, bufferCap),
+		index:         0,
+		file:          file,
+		fileName:      fileName,
+		fdPoolCb:      cb,
+		closeOp:       closeOp,
+		logger:        logger,
+		bufferCap:     bufferCap,
+		hashAlgorithm: hashAlgorithm,
 	}, nil
 }
 
@@ -87,23 +100,119 @@ func (b *Bucket) Write(item []byte) error {
 
 // caller should lock the bucket
 func (b *Bucket) FlushToFile() error {
+	if b.index > 0 && !b.headerChecked {
+		err := b.checkHeader()
+		if err != nil {
+			return err
+		}
+	}
+	err := b.writeToFile(b.data[:b.index])
+	if err != nil {
+		return err
+	}
+	b.index = 0
+	return nil
+}
+
+func (b *Bucket) writeToFile(data []byte) error {
 	var numOfBytes int
 	var err error
 	if b.fdPoolCb != nil {
-		numOfBytes, err = b.fdPoolCb(b.data[:b.index])
+		numOfBytes, err = b.fdPoolCb(data)
 	} else {
-		numOfBytes, err = b.file.Write(b.data[:b.index])
+		numOfBytes, err = b.file.Write(data)
 	}
 	if err != nil {
 		return err
 	}
-	if numOfBytes != b.index {
-		return fmt.Errorf("incomplete write. expected=%v, actual=%v", b.index, numOfBytes)
+	if numOfBytes != len(data) {
+		return fmt.Errorf("incomplete write. expected=%v, actual=%v", len(data), numOfBytes)
 	}
-	b.index = 0
 	return nil
 }
 
+// Writes the header if the file is empty. Otherwise the records are appended to what was written by a previous run,
+// which must have used the same hash algorithm
+// The file descriptor pool only creates the file once it has a descriptor to spare, so it may not exist yet
+// caller should lock the bucket
+func (b *Bucket) checkHeader() error {
+	var size int64
+	fileInfo, err := os.Stat(b.fileName)
+	if err == nil {
+		size = fileInfo.Size()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if size == 0 {
+		err = b.writeToFile(base.EncodeMutationFileHeader(b.hashAlgorithm))
+		if err != nil {
+			return err
+		}
+		b.headerChecked = true
+		return nil
+	}
+
+	file, err := os.Open(b.fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	header := make([]byte, base.MutationFileHeaderSize)
+	_, err = io.ReadFull(file, header)
+	if err != nil {
+		return fmt.Errorf("unable to read header of %v. err=%v", b.fileName, err)
+	}
+	hashAlgorithm, err := base.DecodeMutationFileHeader(header)
+	if err != nil {
+		return fmt.Errorf("%v: %v", b.fileName, err)
+	}
+	if hashAlgorithm != b.hashAlgorithm {
+		return fmt.Errorf("%v was written with hash algorithm %v, which cannot be resumed with %v", b.fileName, hashAlgorithm, b.hashAlgorithm)
+	}
+	b.headerChecked = true
+	return nil
+}
+
+// Called after the file has been truncated outside of the bucket
+func (b *Bucket) resetHeaderCheck() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.headerChecked = false
+}
+
+// Flushes the buffered data and syncs the file to disk
+// Returns the size of the file, which covers everything that has been written to the bucket so far
+func (b *Bucket) Flush() (int64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	err := b.FlushToFile()
+	if err != nil {
+		return 0, err
+	}
+
+	file := b.file
+	if file == nil {
+		// The file descriptor pool may have closed the file in between writes. Any descriptor can be used to sync it
+		file, err = os.Open(b.fileName)
+		if os.IsNotExist(err) {
+			// Nothing has been written yet, and the pool has not had a descriptor to spare to create it
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+	}
+	err = file.Sync()
+	if err != nil {
+		return 0, err
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fileInfo.Size(), nil
+}
+
 func (b *Bucket) Close() {
 	b.lock.Lock()
 	defer b.lock.Unlock()
@@ -124,7 +233,7 @@ func (b *Bucket) Close() {
 	}
 }
 
-func NewFileHandler(fileDir string, fdPool fdp.FdPoolIface, numberOfVbuckets uint16, numberOfBins int, bufferCapacity int, requiresVBRemapping bool, logger *xdcrLog.CommonLogger) *FileHandler {
+func NewFileHandler(fileDir string, fdPool fdp.FdPoolIface, numberOfVbuckets uint16, numberOfBins int, bufferCapacity int, requiresVBRemapping bool, logger *xdcrLog.CommonLogger, vbnos []uint16, hashAlgorithm base.HashAlgorithm) *FileHandler {
 	return &FileHandler{
 		fileDir:             fileDir,
 		fdPool:              fdPool,
@@ -133,6 +242,8 @@ func NewFileHandler(fileDir string, fdPool fdp.FdPoolIface, numberOfVbuckets uin
 		bufferCapacity:      bufferCapacity,
 		RequiresVBRemapping: requiresVBRemapping,
 		logger:              logger,
+		vbnos:               vbnos,
+		hashAlgorithm:       hashAlgorithm,
 	}
 }
 
@@ -143,12 +254,11 @@ func (fh *FileHandler) Initialize() error {
 	fh.BucketMap = make(map[uint16]map[int]*Bucket)
 	fh.BucketLock.Lock()
 	defer fh.BucketLock.Unlock()
-	var vbno uint16
-	for vbno = 0; vbno < fh.numberOfVbuckets; vbno++ {
+	for _, vbno := range base.SelectedVbuckets(fh.vbnos, fh.numberOfVbuckets) {
 		innerMap := make(map[int]*Bucket)
 		fh.BucketMap[vbno] = innerMap
 		for bin := 0; bin < fh.numberOfBins; bin++ {
-			bucket, err := NewBucket(fh.fileDir, vbno, bin, fh.fdPool, fh.logger, fh.bufferCapacity)
+			bucket, err := NewBucket(fh.fileDir, vbno, bin, fh.fdPool, fh.logger, fh.bufferCapacity, fh.hashAlgorithm)
 			if err != nil {
 				return err
 			}
@@ -179,10 +289,9 @@ func (fh *FileHandler) GetBucket(docKey []byte, actualVbNo uint16) (*Bucket, err
 }
 
 func (fh *FileHandler) Close() {
-	var vbno uint16
 	fh.BucketLock.RLock()
 	defer fh.BucketLock.RUnlock()
-	for vbno = 0; vbno < fh.numberOfVbuckets; vbno++ {
+	for _, vbno := range base.SelectedVbuckets(fh.vbnos, fh.numberOfVbuckets) {
 		innerMap := fh.BucketMap[vbno]
 		if innerMap == nil {
 			fh.logger.Warnf("Cannot find innerMap for Vbno %v at cleanup", vbno)
@@ -198,3 +307,107 @@ func (fh *FileHandler) Close() {
 		}
 	}
 }
+
+// Flushes and syncs every bucket
+// Returns the size of each bucket file, keyed by the file name without the directory
+func (fh *FileHandler) Flush() (map[string]int64, error) {
+	fileSizes := make(map[string]int64)
+	fh.BucketLock.RLock()
+	defer fh.BucketLock.RUnlock()
+	for _, innerMap := range fh.BucketMap {
+		for _, bucket := range innerMap {
+			size, err := bucket.Flush()
+			if err != nil {
+				return nil, fmt.Errorf("error flushing %v. err=%v", bucket.fileName, err)
+			}
+			fileSizes[filepath.Base(bucket.fileName)] = size
+		}
+	}
+	return fileSizes, nil
+}
+
+// Truncates the bucket files to the sizes they had when a checkpoint was taken, so that the mutations
+// written after the checkpoint are not duplicated when they are streamed again
+// A file that is smaller than when the checkpoint was taken has lost mutations that would not be streamed again,
+// so nothing is truncated and an error is returned instead
+func (fh *FileHandler) Truncate(fileSizes map[string]int64) error {
+	fh.BucketLock.RLock()
+	defer fh.BucketLock.RUnlock()
+	var shortFiles []string
+	for _, innerMap := range fh.BucketMap {
+		for _, bucket := range innerMap {
+			size, exists := fileSizes[filepath.Base(bucket.fileName)]
+			if !exists {
+				continue
+			}
+			var actualSize int64
+			fileInfo, err := os.Stat(bucket.fileName)
+			if err == nil {
+				actualSize = fileInfo.Size()
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+			if actualSize < size {
+				fh.logger.Errorf("File %v is smaller than when it was checkpointed. expected=%v, actual=%v\n", bucket.fileName, size, actualSize)
+				shortFiles = append(shortFiles, filepath.Base(bucket.fileName))
+			}
+		}
+	}
+	if len(shortFiles) > 0 {
+		sort.Strings(shortFiles)
+		return fmt.Errorf("files %v are smaller than when the checkpoint was taken. Reset their vbuckets with the checkpoint reset command, or run without oldCheckpointFileName", shortFiles)
+	}
+
+	for _, innerMap := range fh.BucketMap {
+		for _, bucket := range innerMap {
+			size, exists := fileSizes[filepath.Base(bucket.fileName)]
+			if !exists {
+				continue
+			}
+			err := os.Truncate(bucket.fileName, size)
+			if err != nil && !(os.IsNotExist(err) && size == 0) {
+				return err
+			}
+			bucket.resetHeaderCheck()
+		}
+	}
+	return nil
+}
+
+// Names of the files of the vbuckets that are not streamed
+func (fh *FileHandler) UnselectedFileNames() []string {
+	if len(fh.vbnos) == 0 {
+		return nil
+	}
+	selected := make(map[uint16]bool)
+	for _, vbno := range fh.vbnos {
+		selected[vbno] = true
+	}
+	var fileNames []string
+	var vbno uint16
+	for vbno = 0; vbno < fh.numberOfVbuckets; vbno++ {
+		if selected[vbno] {
+			continue
+		}
+		for bin := 0; bin < fh.numberOfBins; bin++ {
+			fileNames = append(fileNames, filepath.Base(utils.GetFileName(fh.fileDir, vbno, bin)))
+		}
+	}
+	return fileNames
+}
+
+// Empties the bucket files so that the vbuckets can be streamed again from the beginning
+func (fh *FileHandler) Empty() error {
+	fh.BucketLock.RLock()
+	defer fh.BucketLock.RUnlock()
+	for _, innerMap := range fh.BucketMap {
+		for _, bucket := range innerMap {
+			err := os.Truncate(bucket.fileName, 0)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			bucket.resetHeaderCheck()
+		}
+	}
+	return nil
+}
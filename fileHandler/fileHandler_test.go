@@ -0,0 +1,123 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package filehandler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	xdcrLog "github.com/couchbase/goxdcr/v8/log"
+	"github.com/couchbase/xdcrDiffer/base"
+	fdp "github.com/couchbase/xdcrDiffer/fileDescriptorPool"
+	"github.com/couchbase/xdcrDiffer/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateToCheckpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	logger := xdcrLog.NewLogger("fileHandlerTest", xdcrLog.DefaultLoggerContext)
+	fh := NewFileHandler(dir, nil, 2, 1, 1024, false, logger, nil, base.HashAlgorithmXxh3)
+	assert.Nil(fh.Initialize())
+	defer fh.Close()
+
+	bucket0 := fh.BucketMap[0][0]
+	bucket1 := fh.BucketMap[1][0]
+	assert.Nil(bucket0.Write([]byte("checkpointed")))
+	assert.Nil(bucket1.Write([]byte("checkpointed")))
+	fileSizes, err := fh.Flush()
+	assert.Nil(err)
+	size0 := fileSizes[filepath.Base(bucket0.fileName)]
+	size1 := fileSizes[filepath.Base(bucket1.fileName)]
+	assert.True(size0 > 0)
+
+	// written after the checkpoint, so discarded on resume
+	assert.Nil(bucket0.Write([]byte("after")))
+	assert.Nil(bucket1.Write([]byte("after")))
+	_, err = fh.Flush()
+	assert.Nil(err)
+	assert.Nil(fh.Truncate(fileSizes))
+	fileInfo, err := os.Stat(utils.GetFileName(dir, 0, 0))
+	assert.Nil(err)
+	assert.Equal(size0, fileInfo.Size())
+
+	// a file that lost what was checkpointed cannot be resumed, and the other files are left as they were
+	assert.Nil(bucket1.Write([]byte("after")))
+	_, err = fh.Flush()
+	assert.Nil(err)
+	assert.Nil(os.Truncate(utils.GetFileName(dir, 0, 0), size0-1))
+	assert.NotNil(fh.Truncate(fileSizes))
+	fileInfo, err = os.Stat(utils.GetFileName(dir, 1, 0))
+	assert.Nil(err)
+	assert.True(fileInfo.Size() > size1)
+}
+
+func TestUnselectedFileNames(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	logger := xdcrLog.NewLogger("fileHandlerTest", xdcrLog.DefaultLoggerContext)
+	fh := NewFileHandler(dir, nil, 4, 2, 1024, false, logger, []uint16{1, 2}, base.HashAlgorithmXxh3)
+	assert.ElementsMatch([]string{
+		filepath.Base(utils.GetFileName(dir, 0, 0)), filepath.Base(utils.GetFileName(dir, 0, 1)),
+		filepath.Base(utils.GetFileName(dir, 3, 0)), filepath.Base(utils.GetFileName(dir, 3, 1)),
+	}, fh.UnselectedFileNames())
+
+	fh = NewFileHandler(dir, nil, 4, 2, 1024, false, logger, nil, base.HashAlgorithmXxh3)
+	assert.Len(fh.UnselectedFileNames(), 0)
+}
+
+func TestFlushWithFewerFdsThanBuckets(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	logger := xdcrLog.NewLogger("fileHandlerTest", xdcrLog.DefaultLoggerContext)
+	// only the files of the first two buckets are created as the buckets are set up
+	fh := NewFileHandler(dir, fdp.NewFileDescriptorPool(2), 4, 2, 1024, false, logger, nil, base.HashAlgorithmXxh3)
+	assert.Nil(fh.Initialize())
+	defer fh.Close()
+
+	for _, innerMap := range fh.BucketMap {
+		for _, bucket := range innerMap {
+			assert.Nil(bucket.Write([]byte("item")))
+		}
+	}
+	_, err := fh.Flush()
+	assert.Nil(err)
+
+	var vbno uint16
+	for vbno = 0; vbno < 4; vbno++ {
+		for bin := 0; bin < 2; bin++ {
+			data, err := os.ReadFile(utils.GetFileName(dir, vbno, bin))
+			assert.Nil(err)
+			assert.Equal(append(base.EncodeMutationFileHeader(base.HashAlgorithmXxh3), []byte("item")...), data)
+		}
+	}
+}
+
+func TestFlushUnwrittenBuckets(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	logger := xdcrLog.NewLogger("fileHandlerTest", xdcrLog.DefaultLoggerContext)
+	fh := NewFileHandler(dir, fdp.NewFileDescriptorPool(1), 4, 1, 1024, false, logger, nil, base.HashAlgorithmXxh3)
+	assert.Nil(fh.Initialize())
+	defer fh.Close()
+
+	// the files that the pool has not created yet are empty as far as the checkpoint goes
+	assert.Nil(fh.BucketMap[0][0].Write([]byte("item")))
+	fileSizes, err := fh.Flush()
+	assert.Nil(err)
+	assert.Len(fileSizes, 4)
+	assert.Equal(int64(0), fileSizes[filepath.Base(utils.GetFileName(dir, 3, 0))])
+	assert.Nil(fh.Truncate(fileSizes))
+}
@@ -9,19 +9,21 @@ replace github.com/couchbase/query => ./stubs/query
 replace github.com/couchbase/regulator => ./stubs/regulator
 
 require (
+	github.com/couchbase/cbauth v0.1.12
 	github.com/couchbase/gocb/v2 v2.9.1
 	github.com/couchbase/gocbcore/v10 v10.5.1
 	github.com/couchbase/gomemcached v0.3.2
 	github.com/couchbase/goxdcr/v8 v8.1.0-1168.0.20241010093256-2f2aa9940a51
 	github.com/rcrowley/go-metrics v0.0.0-20201227073835-cf1acfcdf475
 	github.com/stretchr/testify v1.9.0
+	github.com/zeebo/xxh3 v1.0.2
+	golang.org/x/term v0.23.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/alecthomas/participle v0.7.1 // indirect
 	github.com/corpix/uarand v0.0.0-20170723150923-031be390f409 // indirect
-	github.com/couchbase/cbauth v0.1.12 // indirect
 	github.com/couchbase/clog v0.1.0 // indirect
 	github.com/couchbase/eventing-ee v0.0.0-00010101000000-000000000000 // indirect
 	github.com/couchbase/go-couchbase v0.1.1 // indirect
@@ -38,6 +40,7 @@ require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/grpc-ecosystem/go-grpc-middleware v1.4.0 // indirect
 	github.com/icrowley/fake v0.0.0-20240710202011-f797eb4a99c0 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
@@ -23,8 +23,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
-	"unsafe"
 
+	"github.com/couchbase/cbauth"
 	"github.com/couchbase/gocb/v2"
 	xdcrBase "github.com/couchbase/goxdcr/v8/base"
 	xdcrParts "github.com/couchbase/goxdcr/v8/base/filter"
@@ -43,7 +43,6 @@ import (
 	"github.com/couchbase/xdcrDiffer/filterPool"
 	"github.com/couchbase/xdcrDiffer/utils"
 	"github.com/stretchr/testify/mock"
-	"gopkg.in/yaml.v3"
 )
 
 var done = make(chan bool)
@@ -111,6 +110,8 @@ type inputOptions struct {
 	//interval for periodical checkpointing, in seconds
 	// value of 0 indicates no periodical checkpointing
 	checkpointInterval uint64
+	// number of previous checkpoints to keep alongside the latest one
+	checkpointHistory uint64
 	// whether to run data generation
 	runDataGeneration bool
 	// whether to run file differ
@@ -137,16 +138,83 @@ type inputOptions struct {
 	fileContaingXattrKeysForNoComapre string
 	//path to yaml config file
 	yamlConfigFilePath string
+	// comma separated list of source scope.collection namespaces to restrict the diff to
+	collectionsToInclude string
+	// comma separated list of source scope.collection namespaces to leave out of the diff
+	collectionsToExclude string
+	// only diff documents whose keys start with this prefix
+	keyPrefix string
+	// only diff documents whose keys match this regular expression
+	keyRegex string
+	// path to a file containing the keys to verify, one per line. DCP streaming and file differ are skipped if specified
+	keysFile string
+	// path to a newline or JSON list of scope.collection/key entries to verify, or "-" for stdin.
+	// Only the mutation differ is run if specified
+	verifyKeysFile string
+	// RFC3339 timestamps - only compare documents whose CAS or HLV cvCas on either side falls in this window
+	diffWindowStart string
+	diffWindowEnd   string
+	// keep DCP streams open and continuously recheck documents as they change on either side
+	liveMode bool
+	// in live mode, seconds a document must go without changing before it is rechecked
+	liveSettleSecs uint64
+	// in live mode, seconds covered by each rolling output window
+	liveWindowSecs uint64
+	// in live mode, number of most recent output windows to keep
+	liveWindowsToKeep uint64
+	// in live mode, port to serve metrics on. 0 disables the metrics endpoint
+	liveMetricsPort uint64
+	// in live mode, address to serve metrics on
+	liveMetricsHost string
+	// in live mode, number of changed keys waiting to be rechecked beyond which new keys are dropped. 0 means no limit
+	liveMaxPendingKeys uint64
+	// comma separated list of vbuckets and ranges, i.e. 0-15,100, to restrict streaming and diffing to
+	vbuckets string
+	// compare item counts from KV stats of both clusters before streaming
+	preflightCheck bool
+	// stop after the pre-flight check
+	preflightOnly bool
+	// restrict streaming and diffing to the vbuckets whose item counts differ in the pre-flight check
+	preflightRestrict bool
+	// directory for the pre-flight check report
+	preflightDir string
+	// max MB of streamed mutations held in memory across all dcp handlers of both clusters. 0 for no limit
+	memoryBudgetMB uint64
+	// algorithm used to digest document bodies in the files
+	hashAlgorithm string
+	// how the file differ treats a tombstone on one side for a document that is absent from the other side
+	tombstonePolicy string
+	// documents that expire within this many seconds of being captured are not reported as missing. 0 disables it
+	expiryGraceSecs uint64
+	// file of name=value lines holding the usernames and passwords, or "-" for stdin
+	credentialsFile string
+	// prompt for the passwords that are still missing
+	passwordPrompt bool
+	// PEM encoded client certificates and keys to authenticate to the clusters with, instead of passwords
+	sourceClientCertFile string
+	sourceClientKeyFile  string
+	targetClientCertFile string
+	targetClientKeyFile  string
+	// JSON file with the replication settings, used in place of the replication spec in metakv
+	replicationSpecFile string
+	// explain mismatches by the Sync Gateway metadata of the documents. Always on for mobile compatible replications
+	mobileMode bool
 }
 
 var options inputOptions = inputOptions{}
 
 func (o inputOptions) String() string {
-	return fmt.Sprintf("Options{sourceUrl: %s, sourceUsername: %s, sourcePassword: REDACTED, sourceBucketName: %s, remoteClusterName: %s, sourceFileDir: %s, targetUrl: %s, targetUsername: %s, targetPassword: REDACTED, targetBucketName: %s, targetFileDir: %s, numberOfSourceDcpClients: %d, numberOfWorkersPerSourceDcpClient: %d, numberOfTargetDcpClients: %d, numberOfWorkersPerTargetDcpClient: %d, numberOfWorkersForFileDiffer: %d, numberOfWorkersForMutationDiffer: %d, numberOfBins: %d, numberOfFileDesc: %d, completeByDuration: %d, completeBySeqno: %t, checkpointFileDir: %s, oldCheckpointFileName: %s, newCheckpointFileName: %s, fileDifferDir: %s, mutationDifferDir: %s, mutationDifferBatchSize: %d, mutationDifferTimeout: %d, sourceDcpHandlerChanSize: %d, targetDcpHandlerChanSize: %d, bucketOpTimeout: %d, maxNumOfGetStatsRetry: %d, maxNumOfSendBatchRetry: %d, getStatsRetryInterval: %d, sendBatchRetryInterval: %d, getStatsMaxBackoff: %d, sendBatchMaxBackoff: %d, delayBetweenSourceAndTarget: %d, checkpointInterval: %d, runDataGeneration: %t, runFileDiffer: %t, runMutationDiffer: %t, enforceTLS: %t, bucketBufferCapacity: %d, compareType: %s, mutationDifferRetries: %d, mutationDifferRetriesWaitSecs: %d, numOfFiltersInFilterPool: %d, debugMode: %t, setupTimeout: %d, fileContaingXattrKeysForNoComapre: %s}",
-		o.sourceUrl, o.sourceUsername, o.sourceBucketName, o.remoteClusterName, o.sourceFileDir, o.targetUrl, o.targetUsername, o.targetBucketName, o.targetFileDir, o.numberOfSourceDcpClients, o.numberOfWorkersPerSourceDcpClient, o.numberOfTargetDcpClients, o.numberOfWorkersPerTargetDcpClient, o.numberOfWorkersForFileDiffer, o.numberOfWorkersForMutationDiffer, o.numberOfBins, o.numberOfFileDesc, o.completeByDuration, o.completeBySeqno, o.checkpointFileDir, o.oldCheckpointFileName, o.newCheckpointFileName, o.fileDifferDir, o.mutationDifferDir, o.mutationDifferBatchSize, o.mutationDifferTimeout, o.sourceDcpHandlerChanSize, o.targetDcpHandlerChanSize, o.bucketOpTimeout, o.maxNumOfGetStatsRetry, o.maxNumOfSendBatchRetry, o.getStatsRetryInterval, o.sendBatchRetryInterval, o.getStatsMaxBackoff, o.sendBatchMaxBackoff, o.delayBetweenSourceAndTarget, o.checkpointInterval, o.runDataGeneration, o.runFileDiffer, o.runMutationDiffer, o.enforceTLS, o.bucketBufferCapacity, o.compareType, o.mutationDifferRetries, o.mutationDifferRetriesWaitSecs, o.numOfFiltersInFilterPool, o.debugMode, o.setupTimeout, o.fileContaingXattrKeysForNoComapre)
+	return fmt.Sprintf("Options{sourceUrl: %s, sourceUsername: %s, sourcePassword: REDACTED, sourceBucketName: %s, remoteClusterName: %s, sourceFileDir: %s, targetUrl: %s, targetUsername: %s, targetPassword: REDACTED, targetBucketName: %s, targetFileDir: %s, numberOfSourceDcpClients: %d, numberOfWorkersPerSourceDcpClient: %d, numberOfTargetDcpClients: %d, numberOfWorkersPerTargetDcpClient: %d, numberOfWorkersForFileDiffer: %d, numberOfWorkersForMutationDiffer: %d, numberOfBins: %d, numberOfFileDesc: %d, completeByDuration: %d, completeBySeqno: %t, checkpointFileDir: %s, oldCheckpointFileName: %s, newCheckpointFileName: %s, fileDifferDir: %s, mutationDifferDir: %s, mutationDifferBatchSize: %d, mutationDifferTimeout: %d, sourceDcpHandlerChanSize: %d, targetDcpHandlerChanSize: %d, bucketOpTimeout: %d, maxNumOfGetStatsRetry: %d, maxNumOfSendBatchRetry: %d, getStatsRetryInterval: %d, sendBatchRetryInterval: %d, getStatsMaxBackoff: %d, sendBatchMaxBackoff: %d, delayBetweenSourceAndTarget: %d, checkpointInterval: %d, checkpointHistory: %d, runDataGeneration: %t, runFileDiffer: %t, runMutationDiffer: %t, enforceTLS: %t, bucketBufferCapacity: %d, compareType: %s, mutationDifferRetries: %d, mutationDifferRetriesWaitSecs: %d, numOfFiltersInFilterPool: %d, debugMode: %t, setupTimeout: %d, fileContaingXattrKeysForNoComapre: %s, collectionsToInclude: %s, collectionsToExclude: %s, keyPrefix: %s, keyRegex: %s, keysFile: %s, verifyKeysFile: %s, diffWindowStart: %s, diffWindowEnd: %s, liveMode: %t, liveSettleSecs: %d, liveWindowSecs: %d, liveWindowsToKeep: %d, liveMetricsPort: %d, liveMetricsHost: %s, liveMaxPendingKeys: %d, vbuckets: %s, preflightCheck: %t, preflightOnly: %t, preflightRestrict: %t, preflightDir: %s, memoryBudgetMB: %d, hashAlgorithm: %s, tombstonePolicy: %s, expiryGraceSecs: %d, credentialsFile: %s, passwordPrompt: %t, sourceClientCertFile: %s, sourceClientKeyFile: %s, targetClientCertFile: %s, targetClientKeyFile: %s, replicationSpecFile: %s, mobileMode: %t}",
+		o.sourceUrl, o.sourceUsername, o.sourceBucketName, o.remoteClusterName, o.sourceFileDir, o.targetUrl, o.targetUsername, o.targetBucketName, o.targetFileDir, o.numberOfSourceDcpClients, o.numberOfWorkersPerSourceDcpClient, o.numberOfTargetDcpClients, o.numberOfWorkersPerTargetDcpClient, o.numberOfWorkersForFileDiffer, o.numberOfWorkersForMutationDiffer, o.numberOfBins, o.numberOfFileDesc, o.completeByDuration, o.completeBySeqno, o.checkpointFileDir, o.oldCheckpointFileName, o.newCheckpointFileName, o.fileDifferDir, o.mutationDifferDir, o.mutationDifferBatchSize, o.mutationDifferTimeout, o.sourceDcpHandlerChanSize, o.targetDcpHandlerChanSize, o.bucketOpTimeout, o.maxNumOfGetStatsRetry, o.maxNumOfSendBatchRetry, o.getStatsRetryInterval, o.sendBatchRetryInterval, o.getStatsMaxBackoff, o.sendBatchMaxBackoff, o.delayBetweenSourceAndTarget, o.checkpointInterval, o.checkpointHistory, o.runDataGeneration, o.runFileDiffer, o.runMutationDiffer, o.enforceTLS, o.bucketBufferCapacity, o.compareType, o.mutationDifferRetries, o.mutationDifferRetriesWaitSecs, o.numOfFiltersInFilterPool, o.debugMode, o.setupTimeout, o.fileContaingXattrKeysForNoComapre, o.collectionsToInclude, o.collectionsToExclude, o.keyPrefix, o.keyRegex, o.keysFile, o.verifyKeysFile, o.diffWindowStart, o.diffWindowEnd, o.liveMode, o.liveSettleSecs, o.liveWindowSecs, o.liveWindowsToKeep, o.liveMetricsPort, o.liveMetricsHost, o.liveMaxPendingKeys, o.vbuckets, o.preflightCheck, o.preflightOnly, o.preflightRestrict, o.preflightDir, o.memoryBudgetMB, o.hashAlgorithm, o.tombstonePolicy, o.expiryGraceSecs, o.credentialsFile, o.passwordPrompt, o.sourceClientCertFile, o.sourceClientKeyFile, o.targetClientCertFile, o.targetClientKeyFile, o.replicationSpecFile, o.mobileMode)
 }
 
 func argParse() {
+	registerOptions()
+	flag.Usage = usage
+	flag.Parse()
+}
+
+func registerOptions() {
 	flag.StringVar(&options.sourceUrl, "sourceUrl", "",
 		"url for source cluster")
 	flag.StringVar(&options.sourceUsername, "sourceUsername", "",
@@ -225,6 +293,8 @@ func argParse() {
 		"delay between source cluster start up and target cluster start up, in seconds")
 	flag.Uint64Var(&options.checkpointInterval, "checkpointInterval", base.CheckpointInterval,
 		"interval for periodical checkpointing, in seconds")
+	flag.Uint64Var(&options.checkpointHistory, "checkpointHistory", base.CheckpointHistory,
+		"number of previous checkpoints to keep alongside the latest one")
 	flag.BoolVar(&options.runDataGeneration, "runDataGeneration", true,
 		" whether to run data generation")
 	flag.BoolVar(&options.runFileDiffer, "runFileDiffer", true,
@@ -251,7 +321,72 @@ func argParse() {
 		"Path to the file containing the Xattr keys for NoCompare ")
 	flag.StringVar(&options.yamlConfigFilePath, "yamlConfigFilePath", "",
 		"Path to the file containing configuration for the difftool")
-	flag.Parse()
+	flag.StringVar(&options.collectionsToInclude, "collectionsToInclude", "",
+		"Comma separated list of source scope.collection namespaces to restrict the diff to")
+	flag.StringVar(&options.collectionsToExclude, "collectionsToExclude", "",
+		"Comma separated list of source scope.collection namespaces to exclude from the diff")
+	flag.StringVar(&options.keyPrefix, "keyPrefix", "",
+		"Only diff documents whose keys start with this prefix")
+	flag.StringVar(&options.keyRegex, "keyRegex", "",
+		"Only diff documents whose keys match this regular expression")
+	flag.StringVar(&options.keysFile, "keysFile", "",
+		"Path to a file containing the document keys to verify, one per line or as a JSON array, or - for stdin. Skips data generation and file differ")
+	flag.StringVar(&options.verifyKeysFile, "verifyKeysFile", "",
+		"Path to a newline or JSON list of scope.collection/key entries to verify, or - for stdin. Only runs the mutation differ")
+	flag.StringVar(&options.diffWindowStart, "diffWindowStart", "",
+		"RFC3339 timestamp. Only compare documents modified at or after this time on either side")
+	flag.StringVar(&options.diffWindowEnd, "diffWindowEnd", "",
+		"RFC3339 timestamp. Only compare documents modified before this time on either side")
+	flag.BoolVar(&options.liveMode, "liveMode", false,
+		"Keep DCP streams open and continuously recheck documents as they change, until interrupted")
+	flag.Uint64Var(&options.liveSettleSecs, "liveSettleSecs", base.LiveSettleSecs,
+		"In live mode, seconds a document must go without changing before it is rechecked")
+	flag.Uint64Var(&options.liveWindowSecs, "liveWindowSecs", base.LiveWindowSecs,
+		"In live mode, seconds covered by each rolling output window")
+	flag.Uint64Var(&options.liveWindowsToKeep, "liveWindowsToKeep", base.LiveWindowsToKeep,
+		"In live mode, number of most recent output windows to keep")
+	flag.Uint64Var(&options.liveMetricsPort, "liveMetricsPort", 0,
+		"In live mode, port to serve metrics on. 0 disables the metrics endpoint")
+	flag.StringVar(&options.vbuckets, "vbuckets", "",
+		"Comma separated list of vbuckets and ranges, i.e. 0-15,100, to restrict streaming and diffing to. Files of other vbuckets are left untouched")
+	flag.BoolVar(&options.preflightCheck, "preflightCheck", false,
+		"Compare the per vbucket and per collection item counts of both clusters before streaming")
+	flag.BoolVar(&options.preflightOnly, "preflightOnly", false,
+		"Stop after the pre-flight item count check. Implies preflightCheck")
+	flag.BoolVar(&options.preflightRestrict, "preflightRestrict", false,
+		"Restrict streaming and diffing to the vbuckets whose item counts differ in the pre-flight check. Implies preflightCheck")
+	flag.StringVar(&options.preflightDir, "preflightDir", base.PreflightDir,
+		"Directory for the pre-flight check report")
+	flag.Uint64Var(&options.memoryBudgetMB, "memoryBudgetMB", 0,
+		"Max MB of streamed mutations held in memory across all dcp handlers of both clusters. DCP flow control throttles the clusters when it is reached. 0 for no limit")
+	flag.StringVar(&options.hashAlgorithm, "hashAlgorithm", base.HashAlgorithmXxh3Name,
+		"Algorithm used to digest document bodies for the file differ, xxh3 or sha512. Checkpointed files can only be resumed with the algorithm they were written with")
+	flag.StringVar(&options.tombstonePolicy, "tombstonePolicy", base.TombstonePolicyStrict,
+		"How the file differ treats a tombstone on one side for a document that is absent from the other side. strict reports them as missing, ignore leaves them out and report lists them separately")
+	flag.Uint64Var(&options.expiryGraceSecs, "expiryGraceSecs", 0,
+		"Documents that have expired, or expire within this many seconds of being captured, are not reported as missing or as expired on one side only. 0 disables it")
+	flag.StringVar(&options.credentialsFile, "credentialsFile", "",
+		"File of name=value lines holding sourceUsername, sourcePassword, targetUsername and targetPassword, or - for stdin. Fills in the ones that are not otherwise given")
+	flag.BoolVar(&options.passwordPrompt, "passwordPrompt", false,
+		"Prompt on the terminal for the passwords that are still missing")
+	flag.StringVar(&options.sourceClientCertFile, "sourceClientCertFile", "",
+		"PEM encoded client certificate to authenticate to the source cluster with over TLS")
+	flag.StringVar(&options.sourceClientKeyFile, "sourceClientKeyFile", "",
+		"PEM encoded private key of sourceClientCertFile")
+	flag.StringVar(&options.targetClientCertFile, "targetClientCertFile", "",
+		"PEM encoded client certificate to authenticate to the target cluster with over TLS")
+	flag.StringVar(&options.targetClientKeyFile, "targetClientKeyFile", "",
+		"PEM encoded private key of targetClientCertFile")
+	flag.StringVar(&options.replicationSpecFile, "replicationSpecFile", "",
+		"JSON file with the replication settings, i.e. the output of GET /settings/replications/<replicationId>. Used with targetUrl and targetUsername to run with filtering and collections mapping without metakv")
+	flag.BoolVar(&options.mobileMode, "mobileMode", false,
+		"Understand the Sync Gateway _sync and _mou xattrs. Documents that only differ because Sync Gateway imported them on one side are not reported, and the ones with a pending import or mismatched rev trees are reported separately. Always on for mobile compatible replications")
+	flag.StringVar(&options.liveMetricsHost, "liveMetricsHost", base.LiveMetricsHost,
+		"In live mode, address to serve metrics on")
+	flag.Uint64Var(&options.liveMaxPendingKeys, "liveMaxPendingKeys", base.LiveMaxPendingKeys,
+		"In live mode, number of changed keys waiting to be rechecked beyond which new keys are dropped. 0 means no limit")
+
+	buildConfigSchema()
 }
 
 func validateCompareType(method string) {
@@ -264,8 +399,28 @@ func validateCompareType(method string) {
 	os.Exit(1)
 }
 
+func validateTombstonePolicy(policy string) {
+	for _, str := range base.TombstonePolicies {
+		if policy == str {
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Invalid tombstonePolicy '%v'. Accepted values are %v\n", policy, base.TombstonePolicies)
+	os.Exit(1)
+}
+
+func validateHashAlgorithm(name string) {
+	if _, err := base.ParseHashAlgorithm(name); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
 func usage() {
-	fmt.Fprintf(os.Stderr, "Usage : %s [OPTIONS] \n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage : %s <command> [OPTIONS] \n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "   or : %s [OPTIONS] \n", os.Args[0])
+	commandsUsage()
+	fmt.Fprintf(os.Stderr, "Options without a command:\n")
 	flag.PrintDefaults()
 }
 
@@ -329,20 +484,45 @@ type xdcrDiffTool struct {
 	colFilterOrderedTargetNs    []*xdcrBase.CollectionNamespace
 	colFilterOrderedTargetColId []uint32
 
+	// The compiled collection mapping, written out to explain the collection IDs of the outputs. nil without collections
+	mappingPlan *base.MappingPlan
+
 	// Used for migration mapping
 	migrationMapping  metadata.CollectionNamespaceMapping
 	duplicatedMapping differ.DuplicatedHintMap
 
+	// Given by sourceClientCertFile and targetClientCertFile, to be used in place of the passwords
+	sourceClientCert []byte
+	sourceClientKey  []byte
+	targetClientCert []byte
+	targetClientKey  []byte
+
 	sourceDcpDriver *dcp.DcpDriver
 	targetDcpDriver *dcp.DcpDriver
 
 	curState difftoolState
 
 	legacyMode bool
+	// Replication settings given by replicationSpecFile, nil if they are read from metakv
+	specFile *base.ReplicationSpecFile
 	// Xattr Keys to be excluded for comparison
 	xattrKeysForNoCompare map[string]bool
 	// Includes vBucket details for both the source and target buckets.
 	vbInfo *vbInfo
+	// Conflict resolution type of the buckets, which the file and mutation differs compare by
+	conflictResolutionType string
+	// Explain mismatches by the Sync Gateway metadata of the documents
+	mobileMode bool
+	// Restricts the diff to a subset of document keys, nil if not specified
+	keySelector *base.KeySelector
+	// Resolved entries of the user-specified key list for the key-list verification mode
+	verifyKeys differ.DiffKeysMap
+	// Restricts the diff to documents modified within a time window, nil if not specified
+	casWindow *base.CasWindow
+	// Rechecks documents as they change in live mode, nil otherwise
+	liveMonitor *differ.LiveMonitor
+	// The vbuckets to stream and diff, nil for all of them
+	vbnos []uint16
 }
 
 func staticHostAddr() string {
@@ -357,6 +537,7 @@ func NewDiffTool(legacyMode bool) (*xdcrDiffTool, error) {
 		srcToTgtColIdsMap:       make(map[uint32][]uint32),
 		colFilterToTgtColIdsMap: map[string][]uint32{},
 		xattrKeysForNoCompare:   map[string]bool{},
+		logger:                  xdcrLog.NewLogger("xdcrDiffTool", xdcrLog.DefaultLoggerContext),
 	}
 	if options.fileContaingXattrKeysForNoComapre != "" {
 		readFile, er := os.Open(options.fileContaingXattrKeysForNoComapre)
@@ -370,12 +551,39 @@ func NewDiffTool(legacyMode bool) (*xdcrDiffTool, error) {
 			difftool.xattrKeysForNoCompare[fileScanner.Text()] = true
 		}
 	}
+	if options.sourceClientCertFile != "" {
+		difftool.sourceClientCert, difftool.sourceClientKey, err = base.LoadClientCertificate(options.sourceClientCertFile, options.sourceClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.targetClientCertFile != "" {
+		difftool.targetClientCert, difftool.targetClientKey, err = base.LoadClientCertificate(options.targetClientCertFile, options.targetClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.replicationSpecFile != "" {
+		if err = difftool.loadReplicationSpecFile(options.replicationSpecFile); err != nil {
+			return nil, err
+		}
+	}
+	difftool.keySelector, err = base.NewKeySelector(options.keyPrefix, options.keyRegex, options.keysFile)
+	if err != nil {
+		return nil, err
+	}
+	difftool.casWindow, err = base.NewCasWindow(options.diffWindowStart, options.diffWindowEnd)
+	if err != nil {
+		return nil, err
+	}
+	if difftool.casWindow != nil && options.compareType == base.MutationCompareTypeBodyOnly {
+		fmt.Printf("Warning: compareType %v does not retrieve metadata so the mutation differ cannot apply %v\n", options.compareType, difftool.casWindow)
+	}
 	// HLV and ImportCas needs to be stripped from the Xattrs
 	difftool.xattrKeysForNoCompare[xdcrBase.XATTR_HLV] = true
 	difftool.xattrKeysForNoCompare[xdcrBase.XATTR_MOU] = true
 	difftool.xattrKeysForNoCompare[xdcrBase.XATTR_MOBILE] = true
 	logCtx := xdcrLog.DefaultLoggerContext
-	difftool.logger = xdcrLog.NewLogger("xdcrDiffTool", xdcrLog.DefaultLoggerContext)
 	if options.debugMode {
 		logCtx.SetLogLevel(xdcrLog.LogLevelDebug)
 		gocb.SetLogger(gocb.VerboseStdioLogger())
@@ -467,15 +675,37 @@ func NewDiffTool(legacyMode bool) (*xdcrDiffTool, error) {
 			}
 		}
 	} else {
+		if err = difftool.populateTemporarySpecAndRef(); err != nil {
+			return nil, err
+		}
 		// Need to do this outside of legacy mode
 		if err := difftool.retrieveClustersCapabilities(legacyMode, nil); err != nil {
 			return nil, err
 		}
+		if difftool.specFile != nil && (difftool.srcCapabilities.HasCollectionSupport() || difftool.tgtCapabilities.HasCollectionSupport()) {
+			difftool.logger.Infof("Source cluster supports collections: %v Target cluster supports collections: %v\n",
+				difftool.srcCapabilities.HasCollectionSupport(), difftool.tgtCapabilities.HasCollectionSupport())
+			if err = difftool.populateCollectionsPreReq(); err != nil {
+				return nil, err
+			}
+		}
 	}
 	difftool.vbInfo, err = difftool.getVbInfo()
 	if err != nil {
 		return nil, err
 	}
+	difftool.conflictResolutionType, err = difftool.getConflictResolutionType()
+	if err != nil {
+		return nil, err
+	}
+	difftool.mobileMode = options.mobileMode || difftool.specifiedSpec.Settings.GetMobileCompatible() != base.MobileCompatibilityOff
+	if difftool.mobileMode {
+		difftool.logger.Infof("Mobile mode: mismatches are explained by the Sync Gateway metadata of the documents\n")
+	}
+	difftool.vbnos, err = difftool.parseVbuckets()
+	if err != nil {
+		return nil, err
+	}
 	// Capture any Ctrl-C for continuing to next steps or cleanup
 	go difftool.monitorInterruptSignal()
 
@@ -628,80 +858,30 @@ func setupTopologyMockCredentials(xdcrTopologyMock *service_def_mock.XDCRCompTop
 	xdcrTopologyMock.On("MyCredentials").Return(getUserName, getPw, getAuthMech, getCert, getSanCert, getClientCert, getClientKey, getErr)
 }
 
-// Replaces placeholders in directory paths with the actual output directory
-// The golang yaml parser does not replce placeholders by deafult.
-func replaceOutputDirPlaceholder(outputFileDir string) {
-	options.sourceFileDir = strings.ReplaceAll(options.sourceFileDir, "${outputFileDir}", outputFileDir)
-	options.targetFileDir = strings.ReplaceAll(options.targetFileDir, "${outputFileDir}", outputFileDir)
-	options.fileDifferDir = strings.ReplaceAll(options.fileDifferDir, "${outputFileDir}", outputFileDir)
-	options.mutationDifferDir = strings.ReplaceAll(options.mutationDifferDir, "${outputFileDir}", outputFileDir)
-	options.checkpointFileDir = strings.ReplaceAll(options.checkpointFileDir, "${outputFileDir}", outputFileDir)
-}
-func UnmarshalYaml(path string) error {
-	yamlData, err := os.ReadFile(path)
-	if err != nil {
-		return err
-	}
-
-	data := make(map[string]interface{})
-	err = yaml.Unmarshal(yamlData, &data)
-	if err != nil {
-		return err
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == checkpointCommand {
+		checkpointMain(os.Args[2:])
+		return
 	}
-
-	v := reflect.ValueOf(&options)
-	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
-		return fmt.Errorf("v must be a pointer to a struct")
+	if len(os.Args) > 1 && os.Args[1] == configSchemaCommand {
+		configSchemaMain()
+		return
 	}
 
-	v = v.Elem() // Get the underlying struct
-	t := v.Type()
-
-	for key, value := range data {
-		_, ok := t.FieldByName(key)
-		if !ok {
-			// Skip if the field does not exist in the struct
-			continue
-		}
-
-		// Get the actual field
-		fieldValue := v.FieldByName(key)
-		if !fieldValue.IsValid() {
-			return fmt.Errorf("invalid field: %s", key)
-		}
-
-		// If the field is private, access it using unsafe
-		if !fieldValue.CanSet() {
-			fieldValue = reflect.NewAt(fieldValue.Type(), unsafe.Pointer(fieldValue.UnsafeAddr())).Elem()
-		}
-
-		switch fieldValue.Kind() {
-		case reflect.Uint64:
-			fieldValue.SetUint(uint64(value.(int))) // yaml unmarshals whole numbers as int
-		case reflect.String:
-			fieldValue.SetString(value.(string))
-		case reflect.Bool:
-			fieldValue.SetBool(value.(bool))
-		default:
-			mapValue := reflect.ValueOf(value)
-			if fieldValue.Type() == mapValue.Type() {
-				fieldValue.Set(mapValue)
-			} else {
-				return fmt.Errorf("type mismatch for field %s: expected %s but got %s", key, fieldValue.Type(), mapValue.Type())
-			}
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			commandMain(command, os.Args[2:])
+			return
 		}
 	}
 
-	if outDir, ok := data["outputFileDir"].(string); ok {
-		replaceOutputDirPlaceholder(outDir)
-		return nil
-	} else {
-		return fmt.Errorf("outputFileDir not found in yaml")
-	}
+	argParse()
+	loadOptions()
+	runDiffTool()
 }
 
-func main() {
-	argParse()
+// Completes the options given on the command line with the yaml config file and the credentials, and validates them
+func loadOptions() {
 	if options.yamlConfigFilePath != "" {
 		err := UnmarshalYaml(options.yamlConfigFilePath)
 		if err != nil {
@@ -709,25 +889,70 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	if err := resolveCredentials(); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
 
 	base.SetupTimeoutSeconds = options.setupTimeout
 
 	validateCompareType(options.compareType)
+	validateHashAlgorithm(options.hashAlgorithm)
+	validateTombstonePolicy(options.tombstonePolicy)
+}
+
+// metakv is reached through cbauth, which is set up here from the source credentials rather than from a
+// CBAUTH_REVRPC_URL carrying them in the environment, unless the environment has set it up already
+func setupCbauth() error {
+	if os.Getenv("CBAUTH_REVRPC_URL") != "" {
+		return nil
+	}
+	if options.sourceUsername == "" || options.sourcePassword == "" {
+		return fmt.Errorf("The source username and password are needed to read the replication from metakv")
+	}
+	if _, err := cbauth.InternalRetryDefaultInit(options.sourceUrl, options.sourceUsername, options.sourcePassword); err != nil {
+		return fmt.Errorf("Unable to set up cbauth to read the replication from metakv: %v", err)
+	}
+	return nil
+}
 
+// Runs the stages enabled by runDataGeneration, runFileDiffer and runMutationDiffer
+func runDiffTool() {
 	fmt.Printf("differ is run with options: %+v\n", options)
 	legacyMode := len(options.targetUsername) > 0
+	if options.replicationSpecFile != "" && !legacyMode {
+		fmt.Printf("replicationSpecFile replaces metakv, so the target needs to be given by targetUrl and targetUsername\n")
+		os.Exit(1)
+	}
 
 	if err := setupDirectories(); err != nil {
 		fmt.Printf("Unable to set up directory structure: %v\n", err)
 		os.Exit(1)
 	}
 
+	if !legacyMode {
+		if err := setupCbauth(); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	difftool, err := NewDiffTool(legacyMode)
 	if err != nil {
 		fmt.Printf("Error creating difftool: %v\n", err)
 		os.Exit(1)
 	}
 
+	if (options.collectionsToInclude != "" || options.collectionsToExclude != "") && difftool.srcBucketManifest == nil {
+		fmt.Printf("collectionsToInclude and collectionsToExclude require both clusters to support collections and the differ to be run via runDiffer.sh or with replicationSpecFile\n")
+		os.Exit(1)
+	}
+
+	if difftool.keySelector.IsKeysFileMode() && len(difftool.colFilterOrderedKeys) > 0 {
+		fmt.Printf("keysFile is not supported when replication is in migration mode\n")
+		os.Exit(1)
+	}
+
 	if options.enforceTLS {
 		// For using certificates, the source cluster must be on a loopback device since we will be retrieving the
 		// source cluster's certificate to prevent sniffing
@@ -737,16 +962,64 @@ func main() {
 		}
 	}
 
-	if legacyMode {
-		if options.enforceTLS {
-			fmt.Printf("enforceTLS option is not compatible with legacyMode")
+	if legacyMode && options.enforceTLS {
+		fmt.Printf("enforceTLS option is not compatible with legacyMode")
+		os.Exit(1)
+	}
+
+	if options.verifyKeysFile != "" {
+		if err := difftool.populateVerifyKeys(); err != nil {
+			fmt.Printf("Error loading key list from %v. err=%v\n", options.verifyKeysFile, err)
 			os.Exit(1)
 		}
-		// OK to ignore metakv err in manual mode
-		if err := difftool.populateTemporarySpecAndRef(); err != nil {
-			fmt.Printf("%v\n", err)
+		fmt.Printf("Verifying keys listed in %v. Only the mutation differ will be run\n", options.verifyKeysFile)
+		options.runDataGeneration = false
+		options.runFileDiffer = false
+		options.runMutationDiffer = true
+	} else if difftool.keySelector.IsKeysFileMode() {
+		// The keys to verify are already known so there is no need to stream and diff anything
+		fmt.Printf("Keys are read from %v. Disabling data generation and file difftool\n", options.keysFile)
+		options.runDataGeneration = false
+		options.runFileDiffer = false
+	}
+
+	if options.preflightCheck || options.preflightOnly || options.preflightRestrict {
+		report, err := difftool.runPreflightCheck()
+		if err != nil {
+			fmt.Printf("Error running pre-flight check. err=%v\n", err)
 			os.Exit(1)
 		}
+		if options.preflightOnly {
+			return
+		}
+		if options.preflightRestrict {
+			if !report.VbucketsCompared {
+				fmt.Printf("Per vbucket item counts are not comparable for this replication. All vbuckets will be diffed\n")
+			} else if len(report.SuspiciousVbnos()) == 0 {
+				fmt.Printf("Item counts of all vbuckets match. Skipping the rest of the diff\n")
+				return
+			} else {
+				fmt.Printf("Restricting the diff to vbuckets %v\n", report.SuspiciousVbuckets)
+				difftool.vbnos = report.SuspiciousVbnos()
+			}
+		}
+	}
+
+	if options.liveMode {
+		if options.verifyKeysFile != "" || difftool.keySelector.IsKeysFileMode() {
+			fmt.Printf("liveMode cannot be used with verifyKeysFile or keysFile\n")
+			os.Exit(1)
+		}
+		if len(difftool.colFilterOrderedKeys) > 0 {
+			fmt.Printf("liveMode is not supported when replication is in migration mode\n")
+			os.Exit(1)
+		}
+		err := difftool.runLiveDiffer()
+		if err != nil {
+			fmt.Printf("Error running live differ. err=%v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	if options.runDataGeneration {
@@ -827,7 +1100,7 @@ func (difftool *xdcrDiffTool) generateDataFiles() error {
 	difftool.logger.Infof("GenerateDataFiles routine started\n")
 	defer difftool.logger.Infof("GenerateDataFiles routine completed\n")
 
-	if options.completeByDuration == 0 && !options.completeBySeqno {
+	if options.completeByDuration == 0 && !options.completeBySeqno && difftool.liveMonitor == nil {
 		difftool.logger.Infof("completeByDuration is required when completeBySeqno is false\n")
 		os.Exit(1)
 	}
@@ -845,6 +1118,26 @@ func (difftool *xdcrDiffTool) generateDataFiles() error {
 		os.Exit(1)
 	}
 
+	var srcChangeObserver, tgtChangeObserver dcp.ChangeObserver
+	if difftool.liveMonitor != nil {
+		srcChangeObserver = difftool.liveMonitor.RecordSourceChange
+		tgtChangeObserver = difftool.liveMonitor.RecordTargetChange
+	}
+
+	var memoryBudget *base.ByteBudget
+	if options.memoryBudgetMB > 0 {
+		memoryBudget = base.NewByteBudget(options.memoryBudgetMB * 1024 * 1024)
+		stopReportChan := make(chan bool)
+		defer close(stopReportChan)
+		go reportMemoryBudget(difftool.logger, memoryBudget, stopReportChan)
+	}
+	// validated at startup
+	hashAlgorithm, _ := base.ParseHashAlgorithm(options.hashAlgorithm)
+	noValue := difftool.canStreamWithoutValue()
+	if noValue {
+		difftool.logger.Infof("Document bodies are not needed. Streaming keys, metadata and xattrs only\n")
+	}
+
 	difftool.sourceDcpDriver = startDcpDriver(difftool.logger, base.SourceClusterName, options.sourceUrl, difftool.specifiedSpec.SourceBucketName,
 		difftool.selfRef, options.sourceFileDir, options.checkpointFileDir,
 		options.oldCheckpointFileName, options.newCheckpointFileName, options.numberOfSourceDcpClients,
@@ -852,7 +1145,7 @@ func (difftool *xdcrDiffTool) generateDataFiles() error {
 		options.bucketOpTimeout, options.maxNumOfGetStatsRetry, options.getStatsRetryInterval,
 		options.getStatsMaxBackoff, options.checkpointInterval, errChan, waitGroup, options.completeBySeqno, fileDescPool, difftool.filter,
 		difftool.srcCapabilities, difftool.srcCollectionIds, difftool.colFilterOrderedKeys, difftool.utils, options.bucketBufferCapacity,
-		difftool.migrationMapping, difftool.specifiedSpec.Settings.GetMobileCompatible(), difftool.specifiedSpec.Settings.GetExpDelMode(), difftool.xattrKeysForNoCompare, difftool.vbInfo.sourceNoOfVbuckets, difftool.vbInfo.isVariableVB)
+		difftool.migrationMapping, difftool.specifiedSpec.Settings.GetMobileCompatible(), difftool.specifiedSpec.Settings.GetExpDelMode(), difftool.xattrKeysForNoCompare, difftool.vbInfo.sourceNoOfVbuckets, difftool.vbInfo.isVariableVB, difftool.keySelector, srcChangeObserver, options.checkpointHistory, difftool.vbnos, memoryBudget, noValue, hashAlgorithm)
 
 	delayDurationBetweenSourceAndTarget := time.Duration(options.delayBetweenSourceAndTarget) * time.Second
 	difftool.logger.Infof("Waiting for %v before starting target dcp clients\n", delayDurationBetweenSourceAndTarget)
@@ -866,14 +1159,14 @@ func (difftool *xdcrDiffTool) generateDataFiles() error {
 		options.bucketOpTimeout, options.maxNumOfGetStatsRetry, options.getStatsRetryInterval, options.getStatsMaxBackoff,
 		options.checkpointInterval, errChan, waitGroup, options.completeBySeqno, fileDescPool, difftool.filter,
 		difftool.tgtCapabilities, difftool.tgtCollectionIds, difftool.colFilterOrderedKeys, difftool.utils, options.bucketBufferCapacity,
-		difftool.migrationMapping, difftool.specifiedSpec.Settings.GetMobileCompatible(), difftool.specifiedSpec.Settings.GetExpDelMode(), difftool.xattrKeysForNoCompare, difftool.vbInfo.targetNoOfVbuckets, difftool.vbInfo.isVariableVB)
+		difftool.migrationMapping, difftool.specifiedSpec.Settings.GetMobileCompatible(), difftool.specifiedSpec.Settings.GetExpDelMode(), difftool.xattrKeysForNoCompare, difftool.vbInfo.targetNoOfVbuckets, difftool.vbInfo.isVariableVB, difftool.keySelector, tgtChangeObserver, options.checkpointHistory, difftool.vbnos, memoryBudget, noValue, hashAlgorithm)
 
 	difftool.curState.mtx.Lock()
 	difftool.curState.state = StateDcpStarted
 	difftool.curState.mtx.Unlock()
 
 	var err error
-	if options.completeBySeqno {
+	if options.completeBySeqno || difftool.liveMonitor != nil {
 		err = difftool.waitForCompletion(difftool.sourceDcpDriver, difftool.targetDcpDriver, errChan, waitGroup)
 	} else {
 		err = difftool.waitForDuration(difftool.sourceDcpDriver, difftool.targetDcpDriver, errChan, options.completeByDuration, delayDurationBetweenSourceAndTarget)
@@ -894,13 +1187,20 @@ func (difftool *xdcrDiffTool) diffDataFiles() error {
 	if err != nil {
 		return fmt.Errorf("Error mkdir fileDifferDir: %v\n", err)
 	}
+	difftool.writeMappingPlan(options.fileDifferDir)
 	var numberOfVbuckets uint16 = difftool.vbInfo.sourceNoOfVbuckets
 	if difftool.vbInfo.isVariableVB { // numOfVbs at source != numOfVbs at target
 		numberOfVbuckets = base.TraditionalNumberOfVbuckets
 	}
+	var srcPurgeSeqnos, tgtPurgeSeqnos map[uint16]uint64
+	if options.tombstonePolicy == base.TombstonePolicyReport {
+		srcPurgeSeqnos = difftool.loadPurgeSeqnos(base.SourceClusterName)
+		tgtPurgeSeqnos = difftool.loadPurgeSeqnos(base.TargetClusterName)
+	}
 	difftoolDriver := differ.NewDifferDriver(options.sourceFileDir, options.targetFileDir, options.fileDifferDir,
 		base.DiffKeysFileName, int(options.numberOfWorkersForFileDiffer), int(options.numberOfBins),
-		int(options.numberOfFileDesc), difftool.srcToTgtColIdsMap, difftool.colFilterOrderedKeys, difftool.colFilterOrderedTargetColId, difftool.selfRef.Uuid_, difftool.specifiedRef.Uuid_, difftool.specifiedSpec.SourceBucketUUID, difftool.specifiedSpec.TargetBucketUUID, difftool.bucketTopologySvc, difftool.specifiedSpec, difftool.logger, numberOfVbuckets)
+		int(options.numberOfFileDesc), difftool.srcToTgtColIdsMap, difftool.colFilterOrderedKeys, difftool.colFilterOrderedTargetColId, difftool.selfRef.Uuid_, difftool.specifiedRef.Uuid_, difftool.specifiedSpec.SourceBucketUUID, difftool.specifiedSpec.TargetBucketUUID, difftool.bucketTopologySvc, difftool.specifiedSpec, difftool.logger, numberOfVbuckets, difftool.casWindow, difftool.vbnos,
+		options.tombstonePolicy, srcPurgeSeqnos, tgtPurgeSeqnos, time.Duration(options.expiryGraceSecs)*time.Second, difftool.conflictResolutionType, difftool.mobileMode)
 	err = difftoolDriver.Run()
 	if err != nil {
 		difftool.logger.Errorf("Error from diffDataFiles = %v\n", err)
@@ -915,6 +1215,7 @@ func (difftool *xdcrDiffTool) diffDataFiles() error {
 		difftool.logger.Infof("Source bucket item count including tombstones is %v (excluding %v filtered mutations)", difftoolDriver.SourceItemCount, difftool.sourceDcpDriver.FilteredCount())
 	} else {
 		difftool.logger.Infof("Replication is in migration mode from the source bucket")
+		difftool.writeMigrationSummary(difftoolDriver.MigrationSummary())
 	}
 	difftool.logger.Infof("Target bucket item count including tombstones is %v (excluding %v filtered mutations)", difftoolDriver.TargetItemCount, difftool.targetDcpDriver.FilteredCount())
 	if difftool.colFilterOrderedKeys == nil && difftoolDriver.SourceItemCount != difftoolDriver.TargetItemCount {
@@ -930,10 +1231,61 @@ func (difftool *xdcrDiffTool) diffDataFiles() error {
 			difftool.logger.Infof("Source bucket item count is not equal to target bucket item count")
 		}
 	}
+	if options.tombstonePolicy != base.TombstonePolicyStrict {
+		difftool.logger.Infof("Tombstones for documents absent from the other side: %v on source, %v on target. They are not reported as missing since tombstonePolicy is %v\n",
+			difftoolDriver.SourceOnlyTombstoneCount, difftoolDriver.TargetOnlyTombstoneCount, options.tombstonePolicy)
+	}
+	if options.expiryGraceSecs > 0 {
+		difftool.logger.Infof("%v expiring documents are not reported as missing\n", difftoolDriver.ExpiringCount)
+	}
 	difftool.duplicatedMapping = difftoolDriver.DuplicatedHint
 	return err
 }
 
+// Item counts cannot be compared in migration mode, so the source documents are accounted for by the filters they pass
+func (difftool *xdcrDiffTool) writeMigrationSummary(summary *base.MigrationSummary) {
+	difftool.logger.Infof("Source documents passing at least one migration filter: %v, more than one: %v, none: %v\n",
+		summary.SourceMatched, summary.SourceMultipleMatched, summary.SourceUnmatched)
+	for _, filter := range summary.Filters {
+		difftool.logger.Infof("%v : %v -> collection %v. Passed by %v source documents, target collection has %v documents\n",
+			filter.Index, filter.Filter, filter.TargetColId, filter.SourceMatched, filter.TargetItems)
+	}
+	if expected, items := summary.ExpectedTargetItems(), summary.TargetItems(); expected != items {
+		difftool.logger.Infof("Filters route %v copies of source documents, target collections have %v documents\n", expected, items)
+	}
+
+	err := summary.Write(options.fileDifferDir + base.FileDirDelimiter + base.MigrationSummaryFileName)
+	if err != nil {
+		difftool.logger.Errorf("Error writing migration summary: %v\n", err)
+	}
+}
+
+// The purge seqnos are taken from the checkpoint written at the end of data generation, or the one it resumed from
+// Returns nil if there is no such checkpoint, or if the files of the cluster are not laid out by its own vbuckets
+func (difftool *xdcrDiffTool) loadPurgeSeqnos(clusterName string) map[uint16]uint64 {
+	if difftool.vbInfo.isVariableVB {
+		return nil
+	}
+	fileName := options.newCheckpointFileName
+	if fileName == "" {
+		fileName = options.oldCheckpointFileName
+	}
+	if options.checkpointFileDir == "" || fileName == "" {
+		return nil
+	}
+
+	checkpointDoc, err := dcp.LoadCheckpointDoc(checkpointFileName(clusterName, fileName))
+	if err != nil {
+		difftool.logger.Warnf("Unable to load %v checkpoint for purge seqnos. Tombstones will not be checked against them. err=%v\n", clusterName, err)
+		return nil
+	}
+	purgeSeqnos := make(map[uint16]uint64)
+	for vbno, checkpoint := range checkpointDoc.Checkpoints {
+		purgeSeqnos[vbno] = checkpoint.PurgeSeqno
+	}
+	return purgeSeqnos
+}
+
 func (difftool *xdcrDiffTool) runMutationDiffer() {
 	difftool.logger.Infof("runMutationDiffer started with compareBody=%v\n", options.compareType)
 	defer difftool.logger.Infof("runMutationDiffer completed\n")
@@ -947,34 +1299,101 @@ func (difftool *xdcrDiffTool) runMutationDiffer() {
 		err = fmt.Errorf("Error mkdir mutationDifferDir: %v\n", err)
 		return
 	}
+	difftool.writeMappingPlan(options.mutationDifferDir)
 
-	mutationDiffer := differ.NewMutationDiffer(difftool.selfRef.Uuid_, difftool.specifiedSpec.SourceBucketName, difftool.specifiedSpec.SourceBucketUUID,
+	mutationDiffer := difftool.newMutationDiffer()
+	err = mutationDiffer.Run()
+	if err != nil {
+		difftool.logger.Errorf("Error from runMutationDiffer = %v\n", err)
+	}
+}
+
+func (difftool *xdcrDiffTool) newMutationDiffer() *differ.MutationDiffer {
+	return differ.NewMutationDiffer(difftool.selfRef.Uuid_, difftool.specifiedSpec.SourceBucketName, difftool.specifiedSpec.SourceBucketUUID,
 		difftool.selfRef, difftool.specifiedRef.Uuid_, difftool.specifiedSpec.TargetBucketName, difftool.specifiedSpec.TargetBucketUUID, difftool.specifiedRef,
 		options.fileDifferDir, options.mutationDifferDir, int(options.numberOfWorkersForMutationDiffer),
 		int(options.mutationDifferBatchSize), int(options.mutationDifferTimeout), int(options.maxNumOfSendBatchRetry),
 		time.Duration(options.sendBatchRetryInterval)*time.Millisecond,
 		time.Duration(options.sendBatchMaxBackoff)*time.Second, options.compareType, difftool.logger, difftool.srcToTgtColIdsMap,
 		difftool.srcCapabilities, difftool.tgtCapabilities, difftool.utils, options.mutationDifferRetries,
-		options.mutationDifferRetriesWaitSecs, difftool.duplicatedMapping)
-	err = mutationDiffer.Run()
+		options.mutationDifferRetriesWaitSecs, difftool.duplicatedMapping, difftool.keySelector, difftool.verifyKeys, difftool.casWindow,
+		difftool.vbnos, difftool.vbInfo.sourceNoOfVbuckets, difftool.conflictResolutionType, difftool.mobileMode)
+}
+
+// Streams from both clusters until interrupted. Documents are rechecked directly as they change, so neither the
+// data files nor the file differ are needed
+func (difftool *xdcrDiffTool) runLiveDiffer() error {
+	difftool.logger.Infof("runLiveDiffer started with compareType=%v\n", options.compareType)
+	defer difftool.logger.Infof("runLiveDiffer completed\n")
+
+	err := os.MkdirAll(options.mutationDifferDir, 0777)
 	if err != nil {
-		difftool.logger.Errorf("Error from runMutationDiffer = %v\n", err)
+		return fmt.Errorf("Error mkdir mutationDifferDir: %v\n", err)
 	}
+	difftool.writeMappingPlan(options.mutationDifferDir)
+
+	difftool.liveMonitor = differ.NewLiveMonitor(difftool.newMutationDiffer(), difftool.logger,
+		time.Duration(options.liveSettleSecs)*time.Second, time.Duration(options.liveWindowSecs)*time.Second,
+		int(options.liveWindowsToKeep), int(options.liveMetricsPort), options.liveMetricsHost, int(options.liveMaxPendingKeys))
+	err = difftool.liveMonitor.Start()
+	if err != nil {
+		return err
+	}
+
+	options.completeBySeqno = false
+	err = difftool.generateDataFiles()
+	difftool.liveMonitor.Stop()
+	return err
 }
 
-func startDcpDriver(logger *xdcrLog.CommonLogger, name, url, bucketName string, ref *metadata.RemoteClusterReference, fileDir, checkpointFileDir, oldCheckpointFileName, newCheckpointFileName string, numberOfDcpClients, numberOfWorkersPerDcpClient, numberOfBins, dcpHandlerChanSize, bucketOpTimeout, maxNumOfGetStatsRetry, getStatsRetryInterval, getStatsMaxBackoff, checkpointInterval uint64, errChan chan error, waitGroup *sync.WaitGroup, completeBySeqno bool, fdPool fdp.FdPoolIface, filter xdcrParts.Filter, capabilities metadata.Capability, collectionIDs []uint32, colMigrationFilters []string, utils xdcrUtils.UtilsIface, bucketBufferCap int, migrationMapping metadata.CollectionNamespaceMapping, mobileCompat int, expDelMode xdcrBase.FilterExpDelType, xattrKeysForNoCompare map[string]bool, numberOfVbuckets uint16, isVariableVB bool) *dcp.DcpDriver {
+func startDcpDriver(logger *xdcrLog.CommonLogger, name, url, bucketName string, ref *metadata.RemoteClusterReference, fileDir, checkpointFileDir, oldCheckpointFileName, newCheckpointFileName string, numberOfDcpClients, numberOfWorkersPerDcpClient, numberOfBins, dcpHandlerChanSize, bucketOpTimeout, maxNumOfGetStatsRetry, getStatsRetryInterval, getStatsMaxBackoff, checkpointInterval uint64, errChan chan error, waitGroup *sync.WaitGroup, completeBySeqno bool, fdPool fdp.FdPoolIface, filter xdcrParts.Filter, capabilities metadata.Capability, collectionIDs []uint32, colMigrationFilters []string, utils xdcrUtils.UtilsIface, bucketBufferCap int, migrationMapping metadata.CollectionNamespaceMapping, mobileCompat int, expDelMode xdcrBase.FilterExpDelType, xattrKeysForNoCompare map[string]bool, numberOfVbuckets uint16, isVariableVB bool, keySelector *base.KeySelector, changeObserver dcp.ChangeObserver, checkpointHistory uint64, vbnos []uint16, memoryBudget *base.ByteBudget, noValue bool, hashAlgorithm base.HashAlgorithm) *dcp.DcpDriver {
 	waitGroup.Add(1)
 	dcpDriver := dcp.NewDcpDriver(logger, name, url, bucketName, ref, fileDir, checkpointFileDir, oldCheckpointFileName,
 		newCheckpointFileName, int(numberOfDcpClients), int(numberOfWorkersPerDcpClient), int(numberOfBins),
 		int(dcpHandlerChanSize), time.Duration(bucketOpTimeout)*time.Second, int(maxNumOfGetStatsRetry),
 		time.Duration(getStatsRetryInterval)*time.Second, time.Duration(getStatsMaxBackoff)*time.Second,
 		int(checkpointInterval), errChan, waitGroup, completeBySeqno, fdPool, filter, capabilities, collectionIDs, colMigrationFilters,
-		utils, bucketBufferCap, migrationMapping, mobileCompat, expDelMode, xattrKeysForNoCompare, numberOfVbuckets, isVariableVB)
+		utils, bucketBufferCap, migrationMapping, mobileCompat, expDelMode, xattrKeysForNoCompare, numberOfVbuckets, isVariableVB, keySelector, changeObserver, int(checkpointHistory), vbnos, memoryBudget, noValue, hashAlgorithm)
 	// dcp driver startup may take some time. Do it asynchronously
 	go startDcpDriverAysnc(dcpDriver, errChan, logger)
 	return dcpDriver
 }
 
+// Bodies are only compared by the file differ through their hashes. Neither the meta comparison
+// nor the live monitor, which rechecks documents with the mutation differ, needs them, unless
+// the replication filter or the migration rules have to look into the bodies
+func (difftool *xdcrDiffTool) canStreamWithoutValue() bool {
+	if options.compareType != base.MutationCompareTypeMetadata && difftool.liveMonitor == nil {
+		return false
+	}
+	if expr, ok := difftool.specifiedSpec.Settings.Values[metadata.FilterExpressionKey].(string); ok && len(expr) > 0 {
+		return false
+	}
+	return len(difftool.colFilterOrderedKeys) == 0
+}
+
+// Logs how much of the memory budget the dcp handlers of both clusters hold, and how often they had to wait for it
+func reportMemoryBudget(logger *xdcrLog.CommonLogger, memoryBudget *base.ByteBudget, stopChan chan bool) {
+	ticker := time.NewTicker(time.Duration(base.StatsReportInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reportMemoryBudgetOnce(logger, memoryBudget)
+		case <-stopChan:
+			reportMemoryBudgetOnce(logger, memoryBudget)
+			return
+		}
+	}
+}
+
+func reportMemoryBudgetOnce(logger *xdcrLog.CommonLogger, memoryBudget *base.ByteBudget) {
+	backpressureCount, backpressureTime := memoryBudget.BackpressureStats()
+	logger.Infof("Memory budget: %v of %v bytes in use. Backpressure applied %v times for a total of %v\n",
+		memoryBudget.Used(), memoryBudget.Limit(), backpressureCount, backpressureTime)
+}
+
 func startDcpDriverAysnc(dcpDriver *dcp.DcpDriver, errChan chan error, logger *xdcrLog.CommonLogger) {
 	err := dcpDriver.Start()
 	if err != nil {
@@ -1030,6 +1449,21 @@ func (difftool *xdcrDiffTool) waitForDuration(sourceDcpDriver, targetDcpDriver *
 	return err
 }
 
+// Logs the keys of the file that the differ ignores
+func (difftool *xdcrDiffTool) loadReplicationSpecFile(path string) error {
+	specFile, warnings, err := base.LoadReplicationSpecFile(path)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		difftool.logger.Warnf("%v\n", warning)
+	}
+	difftool.specFile = specFile
+	// The bucket topology service that would otherwise report the pruning windows needs metakv
+	differ.SetVersionPruningWindow(time.Duration(specFile.VersionPruningWindowHrs) * time.Hour)
+	return nil
+}
+
 func (difftool *xdcrDiffTool) retrieveReplicationSpecInfo() error {
 	// CBAUTH has already been setup
 	var err error
@@ -1079,17 +1513,15 @@ func (difftool *xdcrDiffTool) populateTemporarySpecAndRef() error {
 	if err != nil {
 		return fmt.Errorf("populateTemporarySpecAndRef() - %v", err)
 	}
+	if difftool.specFile != nil {
+		applyReplicationSpecFile(difftool.specifiedSpec, difftool.specFile)
+	}
 
 	difftool.specifiedRef, err = metadata.NewRemoteClusterReference("" /*uuid*/, options.remoteClusterName /*name*/, options.targetUrl, options.targetUsername, options.targetPassword,
 		"", false, "", nil, nil, nil, nil)
 	if err != nil {
 		return fmt.Errorf("populateTemporarySpecAndRef() - %v", err)
 	}
-
-	err = difftool.populateSelfRef()
-	if err != nil {
-		return fmt.Errorf("populateTemporarySpecAndRef() - %v", err)
-	}
 	return err
 }
 
@@ -1120,6 +1552,8 @@ func (difftool *xdcrDiffTool) populateSelfRef() error {
 	difftool.selfRef.UserName_ = options.sourceUsername
 	difftool.selfRef.Password_ = options.sourcePassword
 	difftool.selfRef.HttpAuthMech_ = xdcrBase.HttpAuthMechPlain
+	difftool.selfRef.ClientCertificate_ = difftool.sourceClientCert
+	difftool.selfRef.ClientKey_ = difftool.sourceClientKey
 
 	// Only grab certificate if on a loopback device
 	if difftool.specifiedRef.IsHttps() && isURLLoopBack(options.sourceUrl) {
@@ -1157,10 +1591,29 @@ func (difftool *xdcrDiffTool) populateSelfRef() error {
 		}
 	}
 
+	if len(difftool.sourceClientCert) > 0 && difftool.selfRef.HttpAuthMech() != xdcrBase.HttpAuthMechHttps {
+		return fmt.Errorf("sourceClientCertFile requires the source cluster to be contacted over TLS, which needs a remote cluster reference with full encryption and a loopback sourceUrl")
+	}
+
 	poolsNodesPath := "/pools/nodes"
-	err, _ := difftool.utils.QueryRestApi(options.sourceUrl, poolsNodesPath, false, xdcrBase.MethodGet, "", nil, 0, &difftool.selfPoolsNodes, nil)
-	if err != nil {
-		return fmt.Errorf("unable to get pools/nodes information: %v", err)
+	if difftool.selfRef.HttpAuthMech() == xdcrBase.HttpAuthMechHttps {
+		// Over TLS, including the client certificate if there is one
+		connStr, err := difftool.selfRef.MyConnectionStr()
+		if err != nil {
+			return fmt.Errorf("unable to get connection string of the source cluster: %v", err)
+		}
+		difftool.selfPoolsNodes, err = difftool.utils.GetClusterInfo(connStr, poolsNodesPath, difftool.selfRef.UserName(),
+			difftool.selfRef.Password(), difftool.selfRef.HttpAuthMech(), difftool.selfRef.Certificates(),
+			difftool.selfRef.SANInCertificate(), difftool.selfRef.ClientCertificate(), difftool.selfRef.ClientKey(),
+			difftool.logger)
+		if err != nil {
+			return fmt.Errorf("unable to get pools/nodes information: %v", err)
+		}
+	} else {
+		err, _ := difftool.utils.QueryRestApi(options.sourceUrl, poolsNodesPath, false, xdcrBase.MethodGet, "", nil, 0, &difftool.selfPoolsNodes, nil)
+		if err != nil {
+			return fmt.Errorf("unable to get pools/nodes information: %v", err)
+		}
 	}
 
 	// Do this last
@@ -1170,15 +1623,8 @@ func (difftool *xdcrDiffTool) populateSelfRef() error {
 
 func (difftool *xdcrDiffTool) retrieveClustersCapabilities(legacyMode bool, xdcrCompTopologyMockCb func()) error {
 	var err error
-	difftool.specifiedRef, err = difftool.remoteClusterSvc.RemoteClusterByRefName(options.remoteClusterName, true /*refresh*/)
-	if err != nil {
-		for err != nil && err == metadata_svc.RefreshNotEnabledYet {
-			difftool.logger.Infof("Difftool hasn't finished reaching out to remote cluster. Sleeping 5 seconds and retrying...")
-			time.Sleep(5 * time.Second)
-			difftool.specifiedRef, err = difftool.remoteClusterSvc.RemoteClusterByRefName(options.remoteClusterName, true /*refresh*/)
-		}
-		if err != nil {
-			difftool.logger.Errorf("Error retrieving remote clusters: %v\n", err)
+	if !legacyMode {
+		if err = difftool.retrieveSpecifiedRef(); err != nil {
 			return err
 		}
 	}
@@ -1196,6 +1642,10 @@ func (difftool *xdcrDiffTool) retrieveClustersCapabilities(legacyMode bool, xdcr
 		if err != nil {
 			return fmt.Errorf("retrieveClusterCapabilities.GetCapability(%v) - %v", difftool.specifiedRef.Name(), err)
 		}
+	} else if difftool.specFile != nil {
+		if err = difftool.retrieveTargetCapabilities(); err != nil {
+			return err
+		}
 	}
 
 	// Self capabilities
@@ -1229,6 +1679,32 @@ func (difftool *xdcrDiffTool) retrieveClustersCapabilities(legacyMode bool, xdcr
 	return nil
 }
 
+func (difftool *xdcrDiffTool) retrieveSpecifiedRef() error {
+	var err error
+	difftool.specifiedRef, err = difftool.remoteClusterSvc.RemoteClusterByRefName(options.remoteClusterName, true /*refresh*/)
+	if err != nil {
+		for err != nil && err == metadata_svc.RefreshNotEnabledYet {
+			difftool.logger.Infof("Difftool hasn't finished reaching out to remote cluster. Sleeping 5 seconds and retrying...")
+			time.Sleep(5 * time.Second)
+			difftool.specifiedRef, err = difftool.remoteClusterSvc.RemoteClusterByRefName(options.remoteClusterName, true /*refresh*/)
+		}
+		if err != nil {
+			difftool.logger.Errorf("Error retrieving remote clusters: %v\n", err)
+			return err
+		}
+	}
+	if len(difftool.targetClientCert) > 0 {
+		if !difftool.specifiedRef.IsFullEncryption() {
+			return fmt.Errorf("targetClientCertFile requires the remote cluster reference %v to use Full-Encryption mode", difftool.specifiedRef.Name())
+		}
+		// the reference is shared with the remote cluster service, so the certificate is set on a copy
+		difftool.specifiedRef = difftool.specifiedRef.Clone()
+		difftool.specifiedRef.ClientCertificate_ = difftool.targetClientCert
+		difftool.specifiedRef.ClientKey_ = difftool.targetClientKey
+	}
+	return nil
+}
+
 func (difftool *xdcrDiffTool) populateCollectionsPreReq() error {
 	if difftool.srcCapabilities.HasCollectionSupport() && difftool.tgtCapabilities.HasCollectionSupport() {
 		// Both have collections support
@@ -1250,10 +1726,15 @@ func (difftool *xdcrDiffTool) populateCollectionsPreReq() error {
 // This is needed whenever source and tgt clusters are >= 7.0
 func (difftool *xdcrDiffTool) PopulateManifestsAndMappings() error {
 	var err error
-	difftool.logger.Infof("Waiting 15 sec for manfiest service to initialize and then getting manifest for source Bucket %v target Bucket %v...\n", difftool.specifiedSpec.SourceBucketName, difftool.specifiedSpec.TargetBucketName)
-	time.Sleep(15 * time.Second)
+	if difftool.collectionsManifestsSvc == nil {
+		difftool.logger.Infof("Getting manifest for source Bucket %v target Bucket %v...\n", difftool.specifiedSpec.SourceBucketName, difftool.specifiedSpec.TargetBucketName)
+		difftool.srcBucketManifest, difftool.tgtBucketManifest, err = difftool.retrieveManifestsFromClusters()
+	} else {
+		difftool.logger.Infof("Waiting 15 sec for manfiest service to initialize and then getting manifest for source Bucket %v target Bucket %v...\n", difftool.specifiedSpec.SourceBucketName, difftool.specifiedSpec.TargetBucketName)
+		time.Sleep(15 * time.Second)
 
-	difftool.srcBucketManifest, difftool.tgtBucketManifest, err = difftool.collectionsManifestsSvc.GetLatestManifests(difftool.specifiedSpec, false)
+		difftool.srcBucketManifest, difftool.tgtBucketManifest, err = difftool.collectionsManifestsSvc.GetLatestManifests(difftool.specifiedSpec, false)
+	}
 	if err != nil {
 		difftool.logger.Errorf("PopulateManifestsAndMappings() - %v\n", err)
 		return err
@@ -1283,6 +1764,16 @@ func (difftool *xdcrDiffTool) PopulateManifestsAndMappings() error {
 		return err
 	}
 
+	err = difftool.applyCollectionSelection()
+	if err != nil {
+		return err
+	}
+	difftool.mappingPlan.Retain(difftool.srcToTgtColIdsMap)
+	var table strings.Builder
+	if err = difftool.mappingPlan.WriteTable(&table); err == nil {
+		difftool.logger.Infof("Mapping plan:\n%v", table.String())
+	}
+
 	// Once hardcoded compilation map has been generated, just stream these Collection IDs from DCP to minimize other noise
 	difftool.generateSrcAndTgtColIds()
 
@@ -1329,6 +1820,13 @@ func (difftool *xdcrDiffTool) compileCollectionMapping() error {
 
 	modes := difftool.specifiedSpec.Settings.GetCollectionModes()
 	rules := difftool.specifiedSpec.Settings.GetCollectionsRoutingRules()
+	if modes.IsMigrationOn() {
+		difftool.mappingPlan = base.NewMappingPlan(base.MappingRuleMigration)
+	} else if modes.IsExplicitMapping() {
+		difftool.mappingPlan = base.NewMappingPlan(base.MappingRuleExplicit)
+	} else {
+		difftool.mappingPlan = base.NewMappingPlan(base.MappingRuleImplicit)
+	}
 	if modes.IsMigrationOn() && !rules.IsExplicitMigrationRule() {
 		return difftool.compileMigrationMapping(namespaceMapping)
 	} else {
@@ -1358,12 +1856,110 @@ func (difftool *xdcrDiffTool) compileHardcodedColToColMapping(namespaceMapping m
 
 			tgtList := []uint32{tgtColId}
 			difftool.srcToTgtColIdsMap[srcColId] = tgtList
+			difftool.mappingPlan.Map(scopeName+"."+collectionName, srcColId, tgtScopeName+"."+tgtCollectionName, tgtColId)
 		}
 	}
 
 	difftool.logger.Infof("Collection namespace mapping: %v idsMap: %v", namespaceMapping, difftool.srcToTgtColIdsMap)
 }
 
+// If the user asked for a subset of source collections, prune the compiled mapping so that only those collection IDs
+// are streamed from DCP on either side and subsequently verified by the mutation differ
+func (difftool *xdcrDiffTool) applyCollectionSelection() error {
+	if options.collectionsToInclude == "" && options.collectionsToExclude == "" {
+		return nil
+	}
+
+	if len(difftool.colFilterOrderedKeys) > 0 {
+		return fmt.Errorf("collectionsToInclude and collectionsToExclude are not supported when replication is in migration mode")
+	}
+
+	includeIds, err := difftool.getSrcCollectionIds(options.collectionsToInclude)
+	if err != nil {
+		return fmt.Errorf("collectionsToInclude - %v", err)
+	}
+	excludeIds, err := difftool.getSrcCollectionIds(options.collectionsToExclude)
+	if err != nil {
+		return fmt.Errorf("collectionsToExclude - %v", err)
+	}
+
+	for srcColId, namespace := range includeIds {
+		if _, exists := difftool.srcToTgtColIdsMap[srcColId]; !exists {
+			difftool.logger.Warnf("Included collection %v is not replicated by the replication spec and will not be diffed\n", namespace)
+		}
+	}
+
+	for srcColId := range difftool.srcToTgtColIdsMap {
+		_, included := includeIds[srcColId]
+		_, excluded := excludeIds[srcColId]
+		if (len(includeIds) > 0 && !included) || excluded {
+			delete(difftool.srcToTgtColIdsMap, srcColId)
+		}
+	}
+
+	if len(difftool.srcToTgtColIdsMap) == 0 {
+		return fmt.Errorf("no replicated collection is left to diff after applying collectionsToInclude and collectionsToExclude")
+	}
+
+	difftool.logger.Infof("Collection selection applied. idsMap: %v", difftool.srcToTgtColIdsMap)
+	return nil
+}
+
+// Given a comma separated list of scope.collection namespaces, returns the source collection IDs they refer to
+func (difftool *xdcrDiffTool) getSrcCollectionIds(namespaces string) (map[uint32]string, error) {
+	colIds := make(map[uint32]string)
+	for _, namespace := range strings.Split(namespaces, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" {
+			continue
+		}
+		parts := strings.Split(namespace, ".")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid namespace %v, expected scope.collection", namespace)
+		}
+		colId, err := difftool.srcBucketManifest.GetCollectionId(parts[0], parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("cannot find %v from source manifest - %v", namespace, err)
+		}
+		colIds[colId] = namespace
+	}
+	return colIds, nil
+}
+
+// Loads the user-specified key list and resolves each entry's namespace to the source collection ID using the manifest
+func (difftool *xdcrDiffTool) populateVerifyKeys() error {
+	if len(difftool.colFilterOrderedKeys) > 0 {
+		return fmt.Errorf("key list verification is not supported when replication is in migration mode")
+	}
+
+	entries, err := base.LoadKeyList(options.verifyKeysFile)
+	if err != nil {
+		return err
+	}
+
+	difftool.verifyKeys = make(differ.DiffKeysMap)
+	for _, entry := range entries {
+		var srcColId uint32
+		if difftool.srcBucketManifest != nil {
+			srcColId, err = difftool.srcBucketManifest.GetCollectionId(entry.ScopeName, entry.CollectionName)
+			if err != nil {
+				return fmt.Errorf("cannot find %v from source manifest - %v", entry.Namespace(), err)
+			}
+		} else if entry.ScopeName != base.DefaultScopeCollectionName || entry.CollectionName != base.DefaultScopeCollectionName {
+			return fmt.Errorf("%v refers to a non-default collection but collections are not in use", entry)
+		}
+
+		if len(difftool.srcToTgtColIdsMap) > 0 {
+			if _, exists := difftool.srcToTgtColIdsMap[srcColId]; !exists {
+				return fmt.Errorf("%v is not replicated by the replication spec or has been excluded", entry.Namespace())
+			}
+		}
+		difftool.verifyKeys[srcColId] = append(difftool.verifyKeys[srcColId], entry.Key)
+	}
+	difftool.logger.Infof("Loaded %v keys to verify from %v\n", len(entries), options.verifyKeysFile)
+	return nil
+}
+
 func (difftool *xdcrDiffTool) generateSrcAndTgtColIds() {
 	tgtColIdDedupMap := make(map[uint32]bool)
 
@@ -1428,19 +2024,41 @@ func (difftool *xdcrDiffTool) compileMigrationMapping(nsMappings metadata.Collec
 	}
 
 	// Ensure that the colIdMappings are handled accordingly
-	for _, targetNs := range difftool.colFilterOrderedTargetNs {
+	for i, targetNs := range difftool.colFilterOrderedTargetNs {
 		targetColId, err := difftool.tgtBucketManifest.GetCollectionId(targetNs.ScopeName, targetNs.CollectionName)
 		if err != nil {
 			return fmt.Errorf("cannot find collection %v from manifest %v", targetNs.ToIndexString(), difftool.tgtBucketManifest.String())
 		}
 		difftool.srcToTgtColIdsMap[0] = append(difftool.srcToTgtColIdsMap[0], targetColId)
 		difftool.colFilterOrderedTargetColId = append(difftool.colFilterOrderedTargetColId, targetColId)
+		difftool.mappingPlan.AddMigrationFilter(difftool.colFilterOrderedKeys[i], targetNs.ToIndexString(), targetColId)
 	}
 
 	// The migrationMapping will be shared among many components, so we need to make sure it is sharable
 	return difftool.populateMigrationMapping(nsMappings)
 }
 
+// Writes the mapping plan, and a table of it, next to the outputs whose collection IDs it explains
+func (difftool *xdcrDiffTool) writeMappingPlan(dir string) {
+	if difftool.mappingPlan == nil {
+		return
+	}
+	err := difftool.mappingPlan.Write(dir + base.FileDirDelimiter + base.MappingPlanFileName)
+	if err != nil {
+		difftool.logger.Errorf("Error writing mapping plan: %v\n", err)
+		return
+	}
+	table, err := os.Create(dir + base.FileDirDelimiter + base.MappingPlanTableFileName)
+	if err != nil {
+		difftool.logger.Errorf("Error writing mapping plan table: %v\n", err)
+		return
+	}
+	defer table.Close()
+	if err = difftool.mappingPlan.WriteTable(table); err != nil {
+		difftool.logger.Errorf("Error writing mapping plan table: %v\n", err)
+	}
+}
+
 func (difftool *xdcrDiffTool) populateMigrationMapping(namespaceMappings metadata.CollectionNamespaceMapping) error {
 	difftool.migrationMapping = namespaceMappings.Clone()
 	filterMode := difftool.specifiedSpec.Settings.GetExpDelMode()
@@ -1458,30 +2076,11 @@ func (difftool *xdcrDiffTool) populateMigrationMapping(namespaceMappings metadat
 }
 
 func (difftool *xdcrDiffTool) getVbucketNo(isSource bool) (uint16, error) {
-	var ref *metadata.RemoteClusterReference
-	var bucketName string
-	var bucketInfo map[string]interface{}
-	var connStr string
-	var err error
-	if isSource {
-		if !difftool.srcCapabilities.HasHeartbeatSupport() { // both variableVB and heartbeat support was added in 8.0
-			return base.TraditionalNumberOfVbuckets, nil // below 8.0 clusters always have 1024 vbuckets
-		}
-		ref = difftool.selfRef
-		bucketName = options.sourceBucketName
-	} else {
-		if !difftool.tgtCapabilities.HasHeartbeatSupport() {
-			return base.TraditionalNumberOfVbuckets, nil // below 8.0 clusters always have 1024 vbuckets
-		}
-		ref = difftool.specifiedRef
-		bucketName = options.targetBucketName
-	}
-
-	connStr, err = ref.MyConnectionStr()
-	if err != nil {
-		return 0, err
+	if isSource && !difftool.srcCapabilities.HasHeartbeatSupport() || !isSource && !difftool.tgtCapabilities.HasHeartbeatSupport() {
+		// both variableVB and heartbeat support was added in 8.0
+		return base.TraditionalNumberOfVbuckets, nil // below 8.0 clusters always have 1024 vbuckets
 	}
-	bucketInfo, err = difftool.utils.GetBucketInfo(connStr, bucketName, ref.UserName_, ref.Password_, ref.HttpAuthMech(), ref.Certificate_, ref.SANInCertificate_, ref.ClientCertificate_, ref.ClientKey_, difftool.logger)
+	bucketInfo, err := difftool.getBucketInfo(isSource)
 	if err != nil {
 		return 0, err
 	}
@@ -1493,6 +2092,115 @@ func (difftool *xdcrDiffTool) getVbucketNo(isSource bool) (uint16, error) {
 	return uint16(numVbs), nil
 }
 
+func (difftool *xdcrDiffTool) parseVbuckets() ([]uint16, error) {
+	vbnos, err := base.ParseVbucketList(options.vbuckets)
+	if err != nil || vbnos == nil {
+		return nil, err
+	}
+	if difftool.vbInfo.isVariableVB {
+		return nil, fmt.Errorf("vbuckets cannot be specified when source has %v vbuckets and target has %v vbuckets",
+			difftool.vbInfo.sourceNoOfVbuckets, difftool.vbInfo.targetNoOfVbuckets)
+	}
+	for _, vbno := range vbnos {
+		if vbno >= difftool.vbInfo.sourceNoOfVbuckets {
+			return nil, fmt.Errorf("vbucket %v does not exist. The bucket has %v vbuckets", vbno, difftool.vbInfo.sourceNoOfVbuckets)
+		}
+	}
+	return vbnos, nil
+}
+
+// Compares the item counts of both clusters, without streaming, and writes the differences to the pre-flight report
+func (difftool *xdcrDiffTool) runPreflightCheck() (*differ.PreflightReport, error) {
+	difftool.logger.Infof("Pre-flight check started\n")
+	defer difftool.logger.Infof("Pre-flight check completed\n")
+
+	var srcCounts, tgtCounts *dcp.ItemCounts
+	var srcErr, tgtErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		srcCounts, srcErr = difftool.newInspectionDcpDriver(base.SourceClusterName).GetItemCounts()
+	}()
+	go func() {
+		defer wg.Done()
+		tgtCounts, tgtErr = difftool.newInspectionDcpDriver(base.TargetClusterName).GetItemCounts()
+	}()
+	wg.Wait()
+	if srcErr != nil || tgtErr != nil {
+		return nil, fmt.Errorf("failed to get item counts. srcErr=%v tgtErr=%v", srcErr, tgtErr)
+	}
+
+	report := differ.NewPreflightReport(srcCounts.Vbuckets, tgtCounts.Vbuckets, difftool.canCompareVbucketItemCounts(), difftool.vbnos,
+		srcCounts.Collections, tgtCounts.Collections, difftool.srcToTgtColIdsMap)
+	fmt.Printf("Pre-flight check: source has %v items and target has %v items. %v vbuckets and %v collections differ\n",
+		report.SourceItems, report.TargetItems, len(report.Vbuckets), len(report.Collections))
+	if report.SuspiciousVbuckets != "" {
+		fmt.Printf("Vbuckets whose item counts differ: %v\n", report.SuspiciousVbuckets)
+	}
+
+	err := os.MkdirAll(options.preflightDir, 0777)
+	if err != nil {
+		return nil, err
+	}
+	return report, report.Write(options.preflightDir + base.FileDirDelimiter + base.PreflightReportFileName)
+}
+
+// Documents keep their vbucket when replicated, so the per vbucket item counts match
+// unless the replication leaves some documents out or moves them into differently keyed namespaces
+func (difftool *xdcrDiffTool) canCompareVbucketItemCounts() bool {
+	if difftool.vbInfo.isVariableVB {
+		return false
+	}
+	if expr, ok := difftool.specifiedSpec.Settings.Values[metadata.FilterExpressionKey].(string); ok && len(expr) > 0 {
+		return false
+	}
+	modes := difftool.specifiedSpec.Settings.GetCollectionModes()
+	return !modes.IsMigrationOn() && !modes.IsExplicitMapping()
+}
+
+func (difftool *xdcrDiffTool) getBucketInfo(isSource bool) (map[string]interface{}, error) {
+	ref := difftool.specifiedRef
+	bucketName := options.targetBucketName
+	if isSource {
+		ref = difftool.selfRef
+		bucketName = options.sourceBucketName
+	}
+
+	connStr, err := ref.MyConnectionStr()
+	if err != nil {
+		return nil, err
+	}
+	return difftool.utils.GetBucketInfo(connStr, bucketName, ref.UserName_, ref.Password_, ref.HttpAuthMech(), ref.Certificate_, ref.SANInCertificate_, ref.ClientCertificate_, ref.ClientKey_, difftool.logger)
+}
+
+// The conflict resolution type decides which versions are the same, and which side XDCR keeps when they are not
+// XDCR only replicates between buckets of the same type, so the source bucket's is used if they differ
+func (difftool *xdcrDiffTool) getConflictResolutionType() (string, error) {
+	var crTypes []string
+	for _, isSource := range []bool{true, false} {
+		bucketInfo, err := difftool.getBucketInfo(isSource)
+		if err != nil {
+			return "", err
+		}
+		crType, ok := bucketInfo[base.ConflictResolutionTypeKey].(string)
+		if !ok {
+			return "", fmt.Errorf("invalid type %T for %v. Expected string", bucketInfo[base.ConflictResolutionTypeKey], base.ConflictResolutionTypeKey)
+		}
+		if err = base.ValidateConflictResolutionType(crType); err != nil {
+			return "", err
+		}
+		crTypes = append(crTypes, crType)
+	}
+
+	if crTypes[0] != crTypes[1] {
+		difftool.logger.Warnf("Source bucket uses %v conflict resolution but target bucket uses %v. Comparing with the source's\n", crTypes[0], crTypes[1])
+	} else {
+		difftool.logger.Infof("Both buckets use %v conflict resolution\n", crTypes[0])
+	}
+	return crTypes[0], nil
+}
+
 func (difftool *xdcrDiffTool) getVbInfo() (*vbInfo, error) {
 	var noOfSourceVbs, noOfTargetVbs uint16
 	var srcErr, tgtErr error
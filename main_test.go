@@ -0,0 +1,40 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	xdcrLog "github.com/couchbase/goxdcr/v8/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDiffToolReplicationSpecFile(t *testing.T) {
+	assert := assert.New(t)
+	defer func(saved inputOptions) { options = saved }(options)
+
+	// as exported from the REST API, with keys the differ ignores
+	options.replicationSpecFile = filepath.Join(t.TempDir(), "replicationSettings.json")
+	err := os.WriteFile(options.replicationSpecFile, []byte(`{"checkpointInterval":600,"FilterExpression":"a","versionPruningWindowHrs":24}`), 0644)
+	assert.Nil(err)
+	// fails right after the replication settings file is loaded, before any cluster is contacted
+	options.keyRegex = "("
+
+	assert.NotPanics(func() {
+		_, err = NewDiffTool(true)
+	})
+	assert.NotNil(err)
+
+	difftool := &xdcrDiffTool{logger: xdcrLog.NewLogger("xdcrDiffTool", xdcrLog.DefaultLoggerContext)}
+	assert.Nil(difftool.loadReplicationSpecFile(options.replicationSpecFile))
+	assert.Equal(24, difftool.specFile.VersionPruningWindowHrs)
+}
@@ -25,8 +25,9 @@ function printHelp() {
 	findExec
 
 	cat <<EOF
-This script will set up the necessary environment variable to allow the XDCR diff tool to connect to the metakv service in the
-specified source cluster (NOTE: over http://) and retrieve the specified replication spec and run the difftool on it.
+This script runs the XDCR diff tool with its run command, which connects to the metakv service in the specified source
+cluster (NOTE: over http://), retrieves the specified replication spec and runs the difftool on it.
+It is kept for compatibility: the same options can be given to "xdcrDiffer run" directly, see xdcrDiffer run -h.
 
 Usage:
 	${BASH_SOURCE[0]} --username=<username> --password=<password> --hostname=<host:port> --sourceBucket=<sourceBucketName> --targetBucket=<targetBucketName> --remoteClusterName=<remoteClusterRefName> [--clear <To clean before run>] [--compareType=<meta | body | both>] [--xattrExcludeKeysFile=<path/to/file>]
@@ -35,7 +36,7 @@ Usage:
 
 Options:
 	-h <host:port> OR --hostname=<host:port>                     : Specify Couchbase server hostname and port number.
-	-p <password> OR --password=<password>                       : Specify Couchbase server password.
+	-p <password> OR --password=<password>                       : Specify Couchbase server password. Prompted for if omitted and stdin is a terminal.
 	-u <username> OR --username=<username>                       : Specify Couchbase server username.
 	-r <remoteClusterName> OR --remoteClusterName=<name>         : Specify the remote cluster name.
 	-s <sourceBucket> OR --sourceBucket=<bucket>                 : Specify the source bucket.
@@ -53,6 +54,9 @@ Options:
 	[--ckptInterval=<interval>]                                  : Checkpoint interval in seconds.
 	[--help]                                                     : Show this help message and exit.
 
+	OR
+	${BASH_SOURCE[0]} <run | capture | filediff | verify | report | checkpoint | configSchema> [OPTIONS]  : Run a command of xdcrDiffer, see xdcrDiffer <command> -h
+
 Example usage:
 	${BASH_SOURCE[0]} -h 127.0.0.1:8091 -u admin -p password -s sourceBucket -t targetBucket -r remoteCluster -c
 	${BASH_SOURCE[0]} --hostname=127.0.0.1:8091 --username=admin --password=password --sourceBucket=sourceBucketName --targetBucket=targetBucketName --remoteClusterName=RemoteRefName --clear
@@ -60,27 +64,13 @@ Example usage:
 EOF
 }
 
-function waitForBgJobs {
-	local pid=$1
-	# Check if the PID is provided
-	if [[ -z "$pid" ]]; then
-		echo "Usage: wait_for_pid <pid>"
-		return 1
-	fi
-
-	# Loop until the process no longer exists
-	while kill -0 "$pid" 2>/dev/null; do
-		echo "Waiting for PID $pid to terminate..."
-		sleep 20
-	done
-}
-
-function killBgTail {
-	local tailPid=$(jobs -l | grep tail | awk '{print $2}')
-	if [[ ! -z "$tailPid" ]]; then
-		kill $tailPid >/dev/null 2>&1
-	fi
-}
+# The commands of the binary are handed over as they are, so that the image can run them through this script as well
+case "$1" in
+run | capture | filediff | verify | report | checkpoint | configSchema)
+	findExec
+	exec "$execGo" "$@"
+	;;
+esac
 
 while getopts ":h:p:u:r:s:t:cm:e:w:d:o:y:-:" opt; do
 	case ${opt} in
@@ -195,218 +185,74 @@ while getopts ":h:p:u:r:s:t:cm:e:w:d:o:y:-:" opt; do
 done
 shift $((OPTIND - 1))
 
-differLogFilePath=""
-execString=""
-
-function clearBeforeRun {
-	local clean=$1
-	local outDir=$2
-	if [[ ! -z "$clean" ]]; then
-		echo "Cleaning up before run..."
-		for directory in "source" "target" "fileDiff" "mutationDiff" "checkpoint" "xdcrDiffer.log"; do
-			rm -rf "$outDir/$directory"
-		done
-	fi
-}
-
-function setupFromCmdLine {
-
-	if [[ -z "$outputDirectory" ]]; then
-		cwd=$(pwd)
-		outputDirectory="$cwd/outputs"
-	fi
-
-	# If the specifed directory does not exist then create one.
-	# If already present then its a no-op
-	mkdir -p $outputDirectory
-
-	# clears existing data if any based on the flag set
-	clearBeforeRun $cleanBeforeRun $outputDirectory
-
-	sourceDir="$outputDirectory/source"
-	targetDir="$outputDirectory/target"
-	fileDiffDir="$outputDirectory/fileDiff"
-	mutationDiffDir="$outputDirectory/mutationDiff"
-	checkpointDir="$outputDirectory/checkpoint"
-	differLogFilePath="$outputDirectory/xdcrDiffer.log"
-
-	if [[ -z "$username" ]]; then
-		echo "Missing username"
-		printHelp
-		exit 1
-	elif [[ -z "$password" ]]; then
-		echo "Missing password"
-		printHelp
-		exit 1
-	elif [[ -z "$hostname" ]]; then
-		echo "Missing hostname and port"
-		printHelp
-		exit 1
-	elif [[ -z "$sourceBucketName" ]]; then
-		echo "Missing sourceBucket"
-		printHelp
-		exit 1
-	elif [[ -z "$targetBucketName" ]]; then
-		echo "Missing targetBucket"
-		printHelp
-		exit 1
-	elif [[ -z "$remoteClusterName" ]] && [[ -z "$targetUrl" ]]; then
-		echo "Missing remoteCluster name or target URL"
-		printHelp
-		exit 1
-	fi
-
-	findExec
-
-	export CBAUTH_REVRPC_URL="http://$username:$password@$hostname"
-
-	unameOut=$(uname)
-	maxFileDescs=""
-
-	if [[ "$unameOut" == "Linux" ]] || [[ "$unameOut" == "Darwin" ]]; then
-		maxFileDescs=$(ulimit -n)
-		if (($? == 0)) && [[ "$maxFileDescs" =~ ^[[:digit:]]+$ ]] && (($maxFileDescs > 4)); then
-			# use 3/4 to prevent overrun
-			maxFileDescs=$(echo $(($maxFileDescs / 4 * 3)))
-		fi
-	fi
-
-	execString="$execGo"
-	execString="${execString} -sourceUrl"
-	execString="${execString} $hostname"
-	execString="${execString} -sourceUsername"
-	execString="${execString} $username"
-	execString="${execString} -sourcePassword"
-	execString="${execString} $password"
-	execString="${execString} -sourceBucketName"
-	execString="${execString} $sourceBucketName"
-	execString="${execString} -targetBucketName"
-	execString="${execString} $targetBucketName"
-
-	if [[ ! -z "$remoteClusterUsername" ]] && [[ ! -z "$remoteClusterPassword" ]]; then
-		execString="${execString} -targetUsername"
-		execString="${execString} $remoteClusterUsername"
-		execString="${execString} -targetPassword"
-		execString="${execString} $remoteClusterPassword"
-	fi
-	if [[ ! -z "$remoteClusterName" ]]; then
-		execString="${execString} -remoteClusterName"
-		execString="${execString} $remoteClusterName"
-	elif [[ ! -z "$targetUrl" ]]; then
-		execString="${execString} -targetUrl"
-		execString="${execString} $targetUrl"
-	fi
-	if [[ ! -z "$maxFileDescs" ]]; then
-		execString="${execString} -numberOfFileDesc"
-		execString="${execString} $maxFileDescs"
-	fi
-	if [[ ! -z "$compareType" ]]; then
-		execString="${execString} -compareType"
-		execString="${execString} $compareType"
-	fi
-	if [[ ! -z "$mutationRetries" ]]; then
-		execString="${execString} -mutationRetries"
-		execString="${execString} $mutationRetries"
-	fi
-	if [[ ! -z "$setupTimeout" ]]; then
-		execString="${execString} -setupTimeout"
-		execString="${execString} $setupTimeout"
-	fi
-	if [[ ! -z "$debugMode" ]]; then
-		execString="${execString} -debugMode"
-		execString="${execString} $debugMode"
-	fi
-	if [[ ! -z "$xattrExcludeKeysFile" ]]; then
-		execString="${execString} -fileContaingXattrKeysForNoComapre"
-		execString="${execString} $xattrExcludeKeysFile"
-	fi
-	if [[ ! -z "$newCkptFile" ]]; then
-		execString="${execString} -newCheckpointFileName"
-		execString="${execString} $newCkptFile"
-	fi
-	if [[ ! -z "$oldCkptFile" ]]; then
-		execString="${execString} -oldCheckpointFileName"
-		execString="${execString} $oldCkptFile"
-	fi
-	if [[ ! -z "$ckptInterval" ]]; then
-		execString="${execString} -checkpointInterval"
-		execString="${execString} $ckptInterval"
-	fi
-
-	execString="${execString} -sourceFileDir"
-	execString="${execString} $sourceDir"
-	execString="${execString} -targetFileDir"
-	execString="${execString} $targetDir"
-	execString="${execString} -fileDifferDir"
-	execString="${execString} $fileDiffDir"
-	execString="${execString} -mutationDifferDir"
-	execString="${execString} $mutationDiffDir"
-	execString="${execString} -checkpointFileDir"
-	execString="${execString} $checkpointDir"
-
-	if [[ @PRODUCT_VERSION@ != @* ]]; then
-		if [[ "${outputDirectory}" == /opt/couchbase* ]]; then
-			echo "outputDir should be specified and should not be under /opt/couchbase"
-			exit 2
-		fi
-	fi
-}
-
-function setupFromYaml {
-	# Check if yq is installed
-	if ! command -v yq &>/dev/null; then
-		echo "Error: yq is not installed. Please install it first."
-		exit 1
-	fi
-
-	hostname=$(yq eval '.sourceUrl' $yamlFile)
-	username=$(yq eval '.sourceUsername' $yamlFile)
-	password=$(yq eval '.sourcePassword' $yamlFile)
-	clear=$(yq eval '.clearBeforeRun' $yamlFile)
-	outDir=$(yq eval '.outputFileDir' $yamlFile)
-
-	# If the specifed directory does not exist then create one.
-	# If already present then its a no-op
-	mkdir -p $outDir
-
-	# clears existing data if any based on the flag set
-	clearBeforeRun $clear $outDir
-
-	findExec
-
-	export CBAUTH_REVRPC_URL="http://$username:$password@$hostname"
-
-	execString="$execGo"
-	execString="${execString} -yamlConfigFilePath"
-	execString="${execString} $yamlFile"
-
-	differLogFilePath="$outDir/xdcrDiffer.log"
-}
+findExec
 
 if [[ ! -z "$yamlFile" ]]; then
-	setupFromYaml
-else
-	setupFromCmdLine
+	# everything else, including the credentials and the outputs, comes from the yaml config file
+	exec "$execGo" run -yamlConfigFilePath "$yamlFile"
 fi
 
-# Execute the differ in background and watch the pid to be finished
-$execString >$differLogFilePath 2>&1 &
-bgPid=$(jobs -p)
-
-# in the meantime, trap ctrl-c and pass the signal to the program
-trap ctrl_c INT
-
-function ctrl_c() {
-	if [[ -z "$bgPid" ]]; then
-		exit 0
-	else
-		kill -SIGINT $bgPid
-		killBgTail
-	fi
-}
+if [[ -z "$username" ]]; then
+	echo "Missing username"
+	printHelp
+	exit 1
+elif [[ -z "$hostname" ]]; then
+	echo "Missing hostname and port"
+	printHelp
+	exit 1
+elif [[ -z "$sourceBucketName" ]]; then
+	echo "Missing sourceBucket"
+	printHelp
+	exit 1
+elif [[ -z "$targetBucketName" ]]; then
+	echo "Missing targetBucket"
+	printHelp
+	exit 1
+elif [[ -z "$remoteClusterName" ]]; then
+	echo "Missing remoteCluster name"
+	printHelp
+	exit 1
+fi
 
-tail -f $differLogFilePath &
-waitForBgJobs $bgPid
-killBgTail
+# The run command sets up the outputs and the log file, and prompts for the password if it is not given and stdin is a terminal
+args=(run -sourceUrl "$hostname" -sourceUsername "$username" -sourceBucketName "$sourceBucketName"
+	-targetBucketName "$targetBucketName" -remoteClusterName "$remoteClusterName")
+if [[ ! -z "$outputDirectory" ]]; then
+	args+=(-outputFileDir "$outputDirectory")
+fi
+if [[ ! -z "$cleanBeforeRun" ]]; then
+	args+=(-clearBeforeRun)
+fi
+if [[ ! -z "$compareType" ]]; then
+	args+=(-compareType "$compareType")
+fi
+if [[ ! -z "$mutationRetries" ]]; then
+	args+=(-mutationRetries "$mutationRetries")
+fi
+if [[ ! -z "$setupTimeout" ]]; then
+	args+=(-setupTimeout "$setupTimeout")
+fi
+if [[ ! -z "$debugMode" ]]; then
+	args+=(-debugMode)
+fi
+if [[ ! -z "$xattrExcludeKeysFile" ]]; then
+	args+=(-fileContaingXattrKeysForNoComapre "$xattrExcludeKeysFile")
+fi
+if [[ ! -z "$newCkptFile" ]]; then
+	args+=(-newCheckpointFileName "$newCkptFile")
+fi
+if [[ ! -z "$oldCkptFile" ]]; then
+	args+=(-oldCheckpointFileName "$oldCkptFile")
+fi
+if [[ ! -z "$ckptInterval" ]]; then
+	args+=(-checkpointInterval "$ckptInterval")
+fi
 
-unset CBAUTH_REVRPC_URL
+if [[ ! -z "$password" ]]; then
+	# Passwords are handed over on stdin so that they do not show up in the process list
+	exec "$execGo" "${args[@]}" -credentialsFile - <<<"sourcePassword=${password}"
+fi
+if [[ -t 0 ]]; then
+	args+=(-passwordPrompt)
+fi
+exec "$execGo" "${args[@]}"
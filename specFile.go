@@ -0,0 +1,93 @@
+// Copyright (c) 2018 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	xdcrBase "github.com/couchbase/goxdcr/v8/base"
+	"github.com/couchbase/goxdcr/v8/metadata"
+	"github.com/couchbase/xdcrDiffer/base"
+)
+
+const bucketScopesPathFmt = "/pools/default/buckets/%v/scopes"
+
+// Sets the replication settings of replicationSpecFile on a spec that was not read from metakv
+func applyReplicationSpecFile(spec *metadata.ReplicationSpecification, specFile *base.ReplicationSpecFile) {
+	if specFile.FilterExpression != "" {
+		spec.Settings.Values[metadata.FilterExpressionKey] = specFile.FilterExpression
+		spec.Settings.Values[metadata.FilterVersionKey] = xdcrBase.FilterVersionAdvanced
+	}
+
+	var expDelMode xdcrBase.FilterExpDelType
+	expDelMode.SetSkipExpiration(specFile.FilterExpiration)
+	expDelMode.SetSkipDeletes(specFile.FilterDeletion)
+	expDelMode.SetStripExpiration(specFile.FilterBypassExpiry)
+	expDelMode.SetSkipBinary(specFile.FilterBinary)
+	spec.Settings.Values[metadata.FilterExpDelKey] = expDelMode
+
+	spec.Settings.Values[metadata.MobileCompatibleKey] = specFile.MobileCompatible
+
+	var collectionModes xdcrBase.CollectionsMgtType
+	collectionModes.SetExplicitMapping(specFile.CollectionsExplicitMapping)
+	collectionModes.SetMigration(specFile.CollectionsMigrationMode)
+	spec.Settings.Values[metadata.CollectionsMgtMultiKey] = collectionModes
+	if len(specFile.ColMappingRules) > 0 {
+		spec.Settings.Values[metadata.CollectionsMappingRulesKey] = metadata.CollectionsMappingRulesType(specFile.ColMappingRules)
+	}
+}
+
+// Without metakv there is no remote cluster service to get the target capabilities from, so the target is asked directly
+func (difftool *xdcrDiffTool) retrieveTargetCapabilities() error {
+	connStr, err := difftool.specifiedRef.MyConnectionStr()
+	if err != nil {
+		return fmt.Errorf("retrieveTargetCapabilities.myConnStr(%v) - %v", difftool.specifiedRef.Name(), err)
+	}
+	defaultPoolInfo, err := difftool.utils.GetClusterInfo(connStr, xdcrBase.DefaultPoolPath, difftool.specifiedRef.UserName(),
+		difftool.specifiedRef.Password(), difftool.specifiedRef.HttpAuthMech(), difftool.specifiedRef.Certificates(),
+		difftool.specifiedRef.SANInCertificate(), difftool.specifiedRef.ClientCertificate(), difftool.specifiedRef.ClientKey(),
+		difftool.logger)
+	if err != nil {
+		return fmt.Errorf("retrieveTargetCapabilities.getClusterInfo(%v) - %v", difftool.specifiedRef.Name(), err)
+	}
+	return difftool.tgtCapabilities.LoadFromDefaultPoolInfo(defaultPoolInfo, difftool.logger)
+}
+
+// Without metakv there is no collections manifest service either, so the manifests are read from each cluster
+func (difftool *xdcrDiffTool) retrieveManifestsFromClusters() (*metadata.CollectionsManifest, *metadata.CollectionsManifest, error) {
+	srcManifest, err := difftool.retrieveManifest(difftool.selfRef, difftool.specifiedSpec.SourceBucketName)
+	if err != nil {
+		return nil, nil, err
+	}
+	tgtManifest, err := difftool.retrieveManifest(difftool.specifiedRef, difftool.specifiedSpec.TargetBucketName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return srcManifest, tgtManifest, nil
+}
+
+func (difftool *xdcrDiffTool) retrieveManifest(ref *metadata.RemoteClusterReference, bucketName string) (*metadata.CollectionsManifest, error) {
+	connStr, err := ref.MyConnectionStr()
+	if err != nil {
+		return nil, fmt.Errorf("retrieveManifest.myConnStr(%v) - %v", ref.Name(), err)
+	}
+	manifestInfo, err := difftool.utils.GetClusterInfo(connStr, fmt.Sprintf(bucketScopesPathFmt, url.PathEscape(bucketName)),
+		ref.UserName(), ref.Password(), ref.HttpAuthMech(), ref.Certificates(), ref.SANInCertificate(),
+		ref.ClientCertificate(), ref.ClientKey(), difftool.logger)
+	if err != nil {
+		return nil, fmt.Errorf("retrieveManifest.getClusterInfo(%v, %v) - %v", ref.Name(), bucketName, err)
+	}
+	manifest, err := metadata.NewCollectionsManifestFromMap(manifestInfo)
+	if err != nil {
+		return nil, fmt.Errorf("retrieveManifest.NewCollectionsManifestFromMap(%v, %v) - %v", ref.Name(), bucketName, err)
+	}
+	return &manifest, nil
+}
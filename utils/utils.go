@@ -139,6 +139,72 @@ func ParseHighSeqnoStat(statsMap map[string]map[string]string, highSeqnoMap map[
 	return nil
 }
 
+// Vbuckets that are missing from the stats are left out of the map
+// The highest purge seqno across nodes is taken, since replicas can lag behind the active copy
+func ParseVbucketPurgeSeqnoStat(statsMap map[string]map[string]string, purgeSeqnoMap map[uint16]uint64, numberOfVbs int) error {
+	for _, statsMapPerServer := range statsMap {
+		for vbno := 0; vbno < numberOfVbs; vbno++ {
+			purgeSeqnoStr, ok := statsMapPerServer[fmt.Sprintf(base.VbucketPurgeSeqnoStatsKey, vbno)]
+			if !ok || purgeSeqnoStr == "" {
+				continue
+			}
+			purgeSeqno, err := strconv.ParseUint(purgeSeqnoStr, 10, 64)
+			if err != nil {
+				return fmt.Errorf("purge seqno for vbno=%v in stats map is not a valid uint64. purge seqno=%v", vbno, purgeSeqnoStr)
+			}
+			if purgeSeqno > purgeSeqnoMap[uint16(vbno)] {
+				purgeSeqnoMap[uint16(vbno)] = purgeSeqno
+			}
+		}
+	}
+	return nil
+}
+
+// Item counts are only taken from the node that holds the active copy of each vbucket
+func ParseVbucketItemCountStat(statsMap map[string]map[string]string, itemCountMap map[uint16]uint64, numberOfVbs int) error {
+	for _, statsMapPerServer := range statsMap {
+		for vbno := 0; vbno < numberOfVbs; vbno++ {
+			if statsMapPerServer[fmt.Sprintf(base.VbucketStateStatsKey, vbno)] != base.VbucketStateActive {
+				continue
+			}
+			numItemsStr := statsMapPerServer[fmt.Sprintf(base.VbucketNumItemsStatsKey, vbno)]
+			numItems, err := strconv.ParseUint(numItemsStr, 10, 64)
+			if err != nil {
+				return fmt.Errorf("item count for vbno=%v in stats map is not a valid uint64. item count=%v", vbno, numItemsStr)
+			}
+			itemCountMap[uint16(vbno)] = numItems
+		}
+	}
+
+	if len(itemCountMap) != numberOfVbs {
+		return fmt.Errorf("did not get item counts of all active vbuckets. len(itemCountMap) =%v", len(itemCountMap))
+	}
+	return nil
+}
+
+// Collection stats are keyed by <scopeId>:<collectionId>:<stat> in hex, i.e. 0x0:0x8:items
+// The item counts of each node only cover its active vbuckets, so they are summed across nodes
+func ParseCollectionItemCountStat(statsMap map[string]map[string]string, itemCountMap map[uint32]uint64) error {
+	for _, statsMapPerServer := range statsMap {
+		for key, value := range statsMapPerServer {
+			parts := strings.Split(key, ":")
+			if len(parts) != 3 || parts[2] != base.CollectionItemsStatName {
+				continue
+			}
+			colId, err := strconv.ParseUint(strings.TrimPrefix(parts[1], "0x"), 16, 32)
+			if err != nil {
+				return fmt.Errorf("invalid collection id in stat %v", key)
+			}
+			numItems, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("item count for stat %v is not a valid uint64. item count=%v", key, value)
+			}
+			itemCountMap[uint32(colId)] += numItems
+		}
+	}
+	return nil
+}
+
 func WaitForWaitGroup(waitGroup *sync.WaitGroup, doneChan chan bool) {
 	waitGroup.Wait()
 	close(doneChan)